@@ -51,6 +51,12 @@ var ErrOffsetOverflow = errors.New("Offset overflow")
 // returned when trying to read a byte from stream and the read size is different than byte size
 var ErrByteRead = errors.New("Error reading byte")
 
+// returned when writing to a read-only buffer
+var ErrReadOnly = errors.New("Read-only buffer")
+
+// returned when decoding a varint that does not fit 64 bits
+var ErrVarintOverflow = errors.New("Varint overflow")
+
 // implemented interfaces
 //	io.Seeker
 //  io.Reader
@@ -62,6 +68,15 @@ var ErrByteRead = errors.New("Error reading byte")
 type ByteBuffer struct {
 	buff []byte
 	pos  int
+	// stack of the debug Pool.Put call that released this buffer
+	released []byte
+	// snapshots still sharing pages of buff
+	snaps []*ByteSnapshot
+	// open transactions, innermost last, and their undo log
+	txs   []txSavepoint
+	txlog []txEntry
+	// named bookmarks
+	marks bookmarks
 }
 
 // create a new ByteBuffer with of (size) bytes
@@ -71,19 +86,47 @@ func NewByteBuffer(size uint) *ByteBuffer {
 	return (&ByteBuffer{}).Reset(size)
 }
 
-// creates a new internal buffer of size (size), position is reset to ZERO
+// resizes the internal buffer to (size) ZERO bytes, position is reset to ZERO
 // NOTE:
 //	- any pre-existing data will be LOST
+//	- the backing array is kept if it can hold (size) bytes, it is
+//		reallocated otherwise
 func (m *ByteBuffer) Reset(size uint) *ByteBuffer {
-	if size > 0 {
-		m.buff = make([]byte, size)
+	m.checkReleased()
+
+	m.journal(0, len(m.buff))
+	if uint(cap(m.buff)) >= size && m.buff != nil {
+		m.protect(0, int(size))
+		m.buff = m.buff[:size]
+		for i := range m.buff {
+			m.buff[i] = 0
+		}
 	} else {
-		m.buff = []byte{}
+		m.protect(0, cap(m.buff)+1)
+		m.buff = make([]byte, size)
 	}
 	m.pos = 0
+	m.marks.clamp(int(size))
 	return m
 }
 
+// shrinks buffer to (size) bytes, position is moved to the new end if it was
+// past it
+// errors:
+//	ErrOffsetOverflow, when size is larger than buffer
+func (m *ByteBuffer) Truncate(size uint) error {
+	m.checkReleased()
+
+	if size > uint(len(m.buff)) {
+		return ErrOffsetOverflow
+	}
+	m.journal(int(size), len(m.buff))
+	m.buff = m.buff[:size]
+	m.pos = min_int(m.pos, int(size))
+	m.marks.clamp(int(size))
+	return nil
+}
+
 // @ByteBuffer.Reset(0)
 func (m *ByteBuffer) Clear() *ByteBuffer {
 	return m.Reset(0)
@@ -114,6 +157,12 @@ func (m *ByteBuffer) Size() uint {
 	return uint(len(m.buff))
 }
 
+// returns capacity in bytes of internal buffer, the buffer can grow up to
+// this size without reallocating
+func (m *ByteBuffer) Cap() uint {
+	return uint(cap(m.buff))
+}
+
 // returns internal buffer position
 func (m *ByteBuffer) Pos() int {
 	return m.pos
@@ -121,6 +170,8 @@ func (m *ByteBuffer) Pos() int {
 
 // returns a copy of internal buffer as a byte slice
 func (m *ByteBuffer) Bytes() []byte {
+	m.checkReleased()
+
 	r := make([]byte, len(m.buff))
 	copy(r, m.buff)
 	return r
@@ -163,25 +214,11 @@ func (m *ByteBuffer) SeekToEnd() (int64, error) {
 //	ErrSeekOverflow
 //	ErrWhenceUnknown
 func (m *ByteBuffer) Seek(offset int64, whence int) (int64, error) {
-	pos := int(offset)
-
-	// validate whence
-	switch whence {
-	case io.SeekStart:
-		// seeking from the beginning
-	case io.SeekCurrent:
-		// inc position by offset, offset can be both positive and negative
-		pos += m.pos
-	case io.SeekEnd:
-		// set position to buffer length + offset, offset must be negative
-		pos += len(m.buff)
-	default:
-		return -1, ErrWhenceUnknown
-	}
+	m.checkReleased()
 
-	// sanity checks
-	if pos < 0 {
-		return -1, ErrSeekNegative
+	pos, err := seek_abs(offset, whence, m.pos, len(m.buff))
+	if err != nil {
+		return -1, err
 	}
 
 	// check for overflow
@@ -230,6 +267,8 @@ func (m *ByteBuffer) readFromPos(p []byte, pos int, incPos bool) (n int, err err
 //	- will return io.EOF error if the number of bytes read is less than the
 //		size of p, however, p will contain the first n bytes from buffer
 func (m *ByteBuffer) Read(p []byte) (n int, err error) {
+	m.checkReleased()
+
 	return m.readFromPos(p, m.pos, true)
 }
 
@@ -242,7 +281,10 @@ func (m *ByteBuffer) Read(p []byte) (n int, err error) {
 // NOTE:
 //	- ReadAt will NOT modify internal position
 //	- multiple readers may read at the same time, provided no write happens in between reads
+//		SyncByteBuffer enforces this
 func (m *ByteBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	m.checkReleased()
+
 	pos := int(off)
 
 	// sanity checks
@@ -269,10 +311,12 @@ func (m *ByteBuffer) writeFromPos(p []byte, pos int) (appended int, written int,
 	nappend := l - noverlap
 	if noverlap > 0 {
 		// override noverlap bytes
+		m.beforeWrite(pos, pos+noverlap)
 		copy(m.buff[pos:], p[:noverlap])
 	}
 	if nappend > 0 {
 		// append nappend bytes
+		m.beforeWrite(len(m.buff), len(m.buff)+nappend)
 		m.buff = append(m.buff, p[noverlap:]...)
 	}
 
@@ -285,6 +329,8 @@ func (m *ByteBuffer) writeFromPos(p []byte, pos int) (appended int, written int,
 //	- if current position is within the buffer, some or all of the bytes will be
 //		overwritten
 func (m *ByteBuffer) Write(p []byte) (n int, err error) {
+	m.checkReleased()
+
 	appended, written, err := m.writeFromPos(p, m.pos)
 	if err != nil {
 		return -1, err
@@ -298,6 +344,8 @@ func (m *ByteBuffer) Write(p []byte) (n int, err error) {
 //	ErrOffsetNegative
 //	ErrOffsetOverflow
 func (m *ByteBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	m.checkReleased()
+
 	// sanity checks
 	if off < 0 {
 		return -1, ErrOffsetNegative
@@ -336,7 +384,10 @@ func (m *ByteBuffer) ReadByte() (byte, error) {
 // NOTE: this function will never return an error, in case we're out of memory
 // a panic will most likely occur
 func (m *ByteBuffer) WriteByte(c byte) error {
+	m.checkReleased()
+
 	// append byte to buffer
+	m.beforeWrite(len(m.buff), len(m.buff)+1)
 	m.buff = append(m.buff, c)
 	m.pos = len(m.buff)
 
@@ -365,6 +416,121 @@ func (m *ByteBuffer) WriteUInt64Var(x uint64) (int, error) {
 }
 
 // reads and returns an uint64s or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, the varint is truncated
+//	ErrVarintOverflow
+// NOTE:
+//	- on error the position is left unchanged, typed reads are all-or-nothing
+//		so a parser can retry once more data has been written
 func (m *ByteBuffer) ReadUInt64Var() (uint64, error) {
-	return binary.ReadUvarint(m)
+	m.checkReleased()
+
+	x, n, err := peek_uvarint(m.buff, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	return x, nil
+}
+
+// reads n bytes from current position, all-or-nothing
+func (m *ByteBuffer) readFixed(n int) ([]byte, error) {
+	m.checkReleased()
+
+	p, err := peek_fixed(m.buff, m.pos, n)
+	if err != nil {
+		return nil, err
+	}
+	m.pos += n
+	return p, nil
+}
+
+// returns the number of bytes written or error
+func (m *ByteBuffer) WriteUInt16(x uint16, order binary.ByteOrder) (int, error) {
+	buff := make([]byte, 2)
+	order.PutUint16(buff, x)
+	return m.Write(buff)
+}
+
+// returns the number of bytes written or error
+func (m *ByteBuffer) WriteUInt32(x uint32, order binary.ByteOrder) (int, error) {
+	buff := make([]byte, 4)
+	order.PutUint32(buff, x)
+	return m.Write(buff)
+}
+
+// returns the number of bytes written or error
+func (m *ByteBuffer) WriteUInt64(x uint64, order binary.ByteOrder) (int, error) {
+	buff := make([]byte, 8)
+	order.PutUint64(buff, x)
+	return m.Write(buff)
+}
+
+// reads and returns an uint16 or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, fewer than 2 bytes left
+// NOTE:
+//	- on error the position is left unchanged
+func (m *ByteBuffer) ReadUInt16(order binary.ByteOrder) (uint16, error) {
+	p, err := m.readFixed(2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint16(p), nil
+}
+
+// reads and returns an uint32 or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, fewer than 4 bytes left
+// NOTE:
+//	- on error the position is left unchanged
+func (m *ByteBuffer) ReadUInt32(order binary.ByteOrder) (uint32, error) {
+	p, err := m.readFixed(4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint32(p), nil
+}
+
+// reads and returns an uint64 or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, fewer than 8 bytes left
+// NOTE:
+//	- on error the position is left unchanged
+func (m *ByteBuffer) ReadUInt64(order binary.ByteOrder) (uint64, error) {
+	p, err := m.readFixed(8)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint64(p), nil
+}
+
+// writes p prefixed by its length as an uvarint
+// returns the number of bytes written, prefix included, or error
+func (m *ByteBuffer) WriteBytesVar(p []byte) (int, error) {
+	buff := make([]byte, binary.MaxVarintLen64+len(p))
+	n := binary.PutUvarint(buff, uint64(len(p)))
+	n += copy(buff[n:], p)
+	return m.Write(buff[:n])
+}
+
+// reads bytes written by WriteBytesVar, returns a copy of them or error
+// errors:
+//	@ReadUInt64Var
+//	io.ErrUnexpectedEOF, fewer bytes left than the prefix announces
+// NOTE:
+//	- on error the position is left unchanged
+func (m *ByteBuffer) ReadBytesVar() ([]byte, error) {
+	m.checkReleased()
+
+	p, n, err := peek_prefixed(m.buff, m.pos)
+	if err != nil {
+		return nil, err
+	}
+	m.pos += n
+	return append([]byte{}, p...), nil
 }
@@ -28,6 +28,7 @@ package mbytes
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
 	"testing"
 )
@@ -76,6 +77,51 @@ func TestByteBufferReset(t *testing.T) {
 	}
 }
 
+func TestByteBufferResetKeepsBackingArray(t *testing.T) {
+	tag := "ByteBuffer.Reset(keep)"
+
+	b := NewByteBuffer(128)
+	b.WriteAt([]byte("abracadabra"), 0)
+	c := b.Cap()
+
+	b.Reset(0)
+	if b.Size() != 0 || b.Cap() != c {
+		t.Fatalf(tag+" unexpected size/capacity %v/%v, expected 0/%v", b.Size(), b.Cap(), c)
+	}
+
+	// data from before the reset must not show up again
+	b.Reset(16)
+	for i := 0; i < 16; i++ {
+		x, _ := b.ByteAt(i)
+		if x != 0 {
+			t.Fatalf(tag+" unexpected byte @%v, expected 0, found %v", i, x)
+		}
+	}
+}
+
+func TestByteBufferTruncate(t *testing.T) {
+	tag := "ByteBuffer.Truncate()"
+
+	b := NewByteBuffer(0)
+	b.Write([]byte("abracadabra"))
+
+	err := b.Truncate(4)
+	if err != nil {
+		t.Fatalf(tag+" unexpected error: %v", err.Error())
+	}
+	if string(b.Bytes()) != "abra" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(b.Bytes()))
+	}
+	if b.Pos() != 4 {
+		t.Fatalf(tag+" unexpected position, expected 4, found %v", b.Pos())
+	}
+
+	err = b.Truncate(5)
+	if err != ErrOffsetOverflow {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrOffsetOverflow.Error(), errOrNilStr(err))
+	}
+}
+
 func TestByteBufferCmpWith(t *testing.T) {
 	tag := "ByteBuffer.CmpWith()"
 
@@ -785,3 +831,115 @@ func TestByteBufferUInt64Var(t *testing.T) {
 		}
 	}
 }
+
+func TestByteBufferFixedAndPrefixed(t *testing.T) {
+	tag := "ByteBuffer.ReadUInt16/32/64/BytesVar()"
+
+	b := NewByteBuffer(0)
+	b.WriteUInt16(0xbeef, binary.BigEndian)
+	b.WriteUInt32(0xdeadbeef, binary.LittleEndian)
+	b.WriteUInt64(1<<63|42, binary.BigEndian)
+	b.WriteBytesVar([]byte("payload"))
+	b.WriteBytesVar(nil)
+	b.SeekToStart()
+
+	x16, err := b.ReadUInt16(binary.BigEndian)
+	if err != nil || x16 != 0xbeef {
+		t.Fatalf(tag+" unexpected uint16 %x, error [%v]", x16, errOrNilStr(err))
+	}
+	x32, err := b.ReadUInt32(binary.LittleEndian)
+	if err != nil || x32 != 0xdeadbeef {
+		t.Fatalf(tag+" unexpected uint32 %x, error [%v]", x32, errOrNilStr(err))
+	}
+	x64, err := b.ReadUInt64(binary.BigEndian)
+	if err != nil || x64 != 1<<63|42 {
+		t.Fatalf(tag+" unexpected uint64 %x, error [%v]", x64, errOrNilStr(err))
+	}
+	p, err := b.ReadBytesVar()
+	if err != nil || string(p) != "payload" {
+		t.Fatalf(tag+" unexpected bytes [%v], error [%v]", string(p), errOrNilStr(err))
+	}
+	p, err = b.ReadBytesVar()
+	if err != nil || len(p) != 0 {
+		t.Fatalf(tag+" unexpected bytes [%v], error [%v]", string(p), errOrNilStr(err))
+	}
+	if b.Pos() != int(b.Size()) {
+		t.Fatalf(tag+" unexpected position, expected %v, found %v", b.Size(), b.Pos())
+	}
+	_, err = b.ReadUInt16(binary.BigEndian)
+	if err != io.EOF {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.EOF, errOrNilStr(err))
+	}
+}
+
+func TestByteBufferAtomicDecode(t *testing.T) {
+	tag := "ByteBuffer(atomic decode)"
+
+	full := NewByteBuffer(0)
+	full.WriteUInt64Var(1 << 40)
+	full.WriteUInt32(7, binary.BigEndian)
+	full.WriteBytesVar([]byte("hello"))
+	stream := full.Bytes()
+
+	decode := []func(b *ByteBuffer) error{
+		func(b *ByteBuffer) error {
+			x, err := b.ReadUInt64Var()
+			if err == nil && x != 1<<40 {
+				t.Fatalf(tag+" unexpected varint %v", x)
+			}
+			return err
+		},
+		func(b *ByteBuffer) error {
+			x, err := b.ReadUInt32(binary.BigEndian)
+			if err == nil && x != 7 {
+				t.Fatalf(tag+" unexpected uint32 %v", x)
+			}
+			return err
+		},
+		func(b *ByteBuffer) error {
+			p, err := b.ReadBytesVar()
+			if err == nil && string(p) != "hello" {
+				t.Fatalf(tag+" unexpected bytes [%v]", string(p))
+			}
+			return err
+		},
+	}
+
+	// feed the stream one byte at a time, every failed decode must leave the
+	// position alone so the next attempt starts over the same bytes
+	b := NewByteBuffer(0)
+	next := 0
+	for i := 0; i < len(stream); i++ {
+		b.WriteByte(stream[i])
+		b.SeekFromStart(0)
+		for j := 0; j < next; j++ {
+			if err := decode[j](b); err != nil {
+				t.Fatalf(tag+" unexpected replay error: %v", err)
+			}
+		}
+		pos := b.Pos()
+		err := decode[next](b)
+		if err != nil {
+			if err != io.ErrUnexpectedEOF && err != io.EOF {
+				t.Fatalf(tag+" unexpected error @%v: %v", i, err)
+			}
+			if b.Pos() != pos {
+				t.Fatalf(tag+" position moved on error @%v, expected %v, found %v", i, pos, b.Pos())
+			}
+			continue
+		}
+		next++
+	}
+	if next != len(decode) {
+		t.Fatalf(tag+" decoded %v values, expected %v", next, len(decode))
+	}
+
+	// a varint longer than 64 bits is a decode error, not a short read
+	b = NewByteBuffer(0)
+	b.Write(bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64+1))
+	b.SeekToStart()
+	_, err := b.ReadUInt64Var()
+	if err != ErrVarintOverflow || b.Pos() != 0 {
+		t.Fatalf(tag+" unexpected overflow error [%v], position %v", errOrNilStr(err), b.Pos())
+	}
+}
@@ -0,0 +1,399 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// default chunk size used by NewChunkedBuffer when chunk size is ZERO
+const KCHUNK_SIZE = 64 * 1024
+
+// a byte buffer that stores data in fixed-size chunks, growing the buffer
+// allocates a new chunk and never copies existing data
+// the API mirrors ByteBuffer
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//  io.WriterTo
+//	io.ByteReader
+//	io.ByteWriter
+type ChunkedBuffer struct {
+	chunks    [][]byte
+	chunkSize int
+	size      int
+	pos       int
+}
+
+// create a new ChunkedBuffer with of (size) bytes, stored in chunks of
+// (chunkSize) bytes
+// NOTE:
+//	- passing ZERO for size is allowed, chunks are allocated on demand
+//	- passing ZERO for chunkSize selects KCHUNK_SIZE
+func NewChunkedBuffer(size uint, chunkSize uint) *ChunkedBuffer {
+	if chunkSize == 0 {
+		chunkSize = KCHUNK_SIZE
+	}
+	return (&ChunkedBuffer{chunkSize: int(chunkSize)}).Reset(size)
+}
+
+// drops all chunks and allocates enough for (size) bytes, position is reset to ZERO
+// NOTE:
+//	- any pre-existing data will be LOST
+func (m *ChunkedBuffer) Reset(size uint) *ChunkedBuffer {
+	m.chunks = nil
+	m.size = 0
+	m.pos = 0
+	m.grow(int(size))
+	m.size = int(size)
+	return m
+}
+
+// @ChunkedBuffer.Reset(0)
+func (m *ChunkedBuffer) Clear() *ChunkedBuffer {
+	return m.Reset(0)
+}
+
+// returns true if the buffer holds no data
+func (m *ChunkedBuffer) Empty() bool {
+	return m.size == 0
+}
+
+// compare contents of this and other
+func (m *ChunkedBuffer) CmpWith(other *ChunkedBuffer) int {
+	return bytes.Compare(m.Bytes(), other.Bytes())
+}
+
+// returns a new clone of this, using the same chunk size
+// position in the clone is set to ZERO
+func (m *ChunkedBuffer) Clone() *ChunkedBuffer {
+	r := NewChunkedBuffer(0, uint(m.chunkSize))
+	r.writeFromPos(m.Bytes(), 0)
+	return r
+}
+
+// returns size in bytes of the data held in buffer
+func (m *ChunkedBuffer) Size() uint {
+	return uint(m.size)
+}
+
+// returns the chunk size
+func (m *ChunkedBuffer) ChunkSize() uint {
+	return uint(m.chunkSize)
+}
+
+// returns internal buffer position
+func (m *ChunkedBuffer) Pos() int {
+	return m.pos
+}
+
+// returns a copy of the data flattened into one contiguous byte slice
+func (m *ChunkedBuffer) Bytes() []byte {
+	r := make([]byte, m.size)
+	m.readFromPos(r, 0, false)
+	return r
+}
+
+// returns a new ByteBuffer holding a contiguous copy of the data
+// position in the ByteBuffer is set to ZERO
+func (m *ChunkedBuffer) ByteBuffer() *ByteBuffer {
+	r := NewByteBuffer(0)
+	r.buff = m.Bytes()
+	return r
+}
+
+// returns the data as net.Buffers, one entry per chunk, suitable for writev
+// NOTE:
+//	- entries share memory with the buffer, nothing is copied, the result is
+//		only valid until the next write
+//	- consuming the result through net.Buffers.WriteTo or Read does NOT
+//		affect the buffer
+func (m *ChunkedBuffer) Buffers() net.Buffers {
+	r := make(net.Buffers, 0, len(m.chunks))
+	left := m.size
+	for i := 0; left > 0; i++ {
+		n := min_int(left, m.chunkSize)
+		r = append(r, m.chunks[i][:n])
+		left -= n
+	}
+	return r
+}
+
+// io.WriterTo implementation
+// writes the whole buffer to w using net.Buffers, which results in a single
+// writev call when w is a net.Conn
+// NOTE:
+//	- position is NOT modified
+func (m *ChunkedBuffer) WriteTo(w io.Writer) (int64, error) {
+	r := m.Buffers()
+	return r.WriteTo(w)
+}
+
+// check if p is overflowing buffer
+func (m *ChunkedBuffer) posOverflow(p int) bool {
+	return p >= m.size
+}
+
+// allocates chunks until the buffer can hold (size) bytes
+func (m *ChunkedBuffer) grow(size int) {
+	for len(m.chunks)*m.chunkSize < size {
+		m.chunks = append(m.chunks, make([]byte, m.chunkSize))
+	}
+}
+
+// @ChunkedBuffer.Seek(offset, io.SeekStart)
+func (m *ChunkedBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @ChunkedBuffer.Seek(offset, io.SeekCurrent)
+func (m *ChunkedBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @ChunkedBuffer.Seek(offset, io.SeekEnd)
+func (m *ChunkedBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @ChunkedBuffer.Seek(0, io.SeekStart)
+func (m *ChunkedBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @ChunkedBuffer.Seek(0, io.SeekEnd)
+func (m *ChunkedBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation
+// returns offset position if err == nil
+// errors:
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *ChunkedBuffer) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seek_abs(offset, whence, m.pos, m.size)
+	if err != nil {
+		return -1, err
+	}
+
+	// check for overflow
+	if m.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+
+	// update position
+	m.pos = pos
+
+	return int64(pos), nil
+}
+
+func (m *ChunkedBuffer) readFromPos(p []byte, pos int, incPos bool) (n int, err error) {
+	l := len(p)
+
+	// number of available bytes to read from position
+	avail := m.size - pos
+	if avail > 0 {
+		// read the minimum amount of bytes
+		n = min_int(avail, l)
+
+		// copy chunk by chunk
+		for c := 0; c < n; {
+			i, off := (pos+c)/m.chunkSize, (pos+c)%m.chunkSize
+			c += copy(p[c:n], m.chunks[i][off:])
+		}
+
+		// increment position only if called by Read, ReadAt also calls this function
+		if incPos {
+			m.pos += n
+		}
+
+		// check if we've read less bytes than the size of p
+		if n < l {
+			err = io.EOF
+		}
+		return
+	}
+	return 0, io.EOF
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+// NOTE:
+//	- will return io.EOF error if the number of bytes read is less than the
+//		size of p, however, p will contain the first n bytes from buffer
+func (m *ChunkedBuffer) Read(p []byte) (n int, err error) {
+	return m.readFromPos(p, m.pos, true)
+}
+
+// io.ReaderAt implementation
+// reads up to len(p) from buffer at offset off, reads may span chunks
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+// NOTE:
+//	- ReadAt will NOT modify internal position
+func (m *ChunkedBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	pos := int(off)
+
+	// sanity checks
+	if pos < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return -1, ErrOffsetOverflow
+	}
+
+	return m.readFromPos(p, pos, false)
+}
+
+func (m *ChunkedBuffer) writeFromPos(p []byte, pos int) (appended int, written int, err error) {
+	l := len(p)
+	end := pos + l
+
+	// new chunks are only added, existing ones are never moved
+	m.grow(end)
+
+	for c := 0; c < l; {
+		i, off := (pos+c)/m.chunkSize, (pos+c)%m.chunkSize
+		c += copy(m.chunks[i][off:], p[c:])
+	}
+
+	if end > m.size {
+		appended = end - m.size
+		m.size = end
+	}
+
+	return appended, l, nil
+}
+
+// io.Writer implementation
+// writes p to internal buffer at current position
+// NOTE:
+//	- if current position is within the buffer, some or all of the bytes will be
+//		overwritten, same as ByteBuffer.Write
+func (m *ChunkedBuffer) Write(p []byte) (n int, err error) {
+	appended, written, err := m.writeFromPos(p, m.pos)
+	if err != nil {
+		return -1, err
+	}
+	m.pos += appended
+	return written, err
+}
+
+// io.WriteAt implementation, writes may span chunks
+// returns
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+func (m *ChunkedBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	// sanity checks
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+
+	appended, written, err := m.writeFromPos(p, int(off))
+	if err != nil {
+		return -1, err
+	}
+
+	// in case of overwrite + append, we want to move the position to the last
+	// appended byte in buffer
+	m.pos += appended
+
+	return written, err
+}
+
+// io.ByteReader implementation
+func (m *ChunkedBuffer) ReadByte() (byte, error) {
+	if m.posOverflow(m.pos) {
+		return 0, io.EOF
+	}
+	c := m.chunks[m.pos/m.chunkSize][m.pos%m.chunkSize]
+	m.pos++
+	return c, nil
+}
+
+// io.ByteWriter implementation, appends c to buffer, same as ByteBuffer
+// NOTE: this function will never return an error, in case we're out of memory
+// a panic will most likely occur
+func (m *ChunkedBuffer) WriteByte(c byte) error {
+	m.grow(m.size + 1)
+	m.chunks[m.size/m.chunkSize][m.size%m.chunkSize] = c
+	m.size++
+	m.pos = m.size
+
+	return nil
+}
+
+// returns a byte at a specific position in buffer
+// much like indexing a byte slice
+func (m *ChunkedBuffer) ByteAt(pos int) (byte, error) {
+	if pos < 0 {
+		return 0, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return 0, ErrOffsetOverflow
+	}
+	return m.chunks[pos/m.chunkSize][pos%m.chunkSize], nil
+}
+
+// returns the number of bytes written or error
+func (m *ChunkedBuffer) WriteUInt64Var(x uint64) (int, error) {
+	buff := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buff, x)
+	return m.Write(buff[:n])
+}
+
+// reads and returns an uint64s or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, the varint is truncated
+//	ErrVarintOverflow
+// NOTE:
+//	- on error the position is left unchanged, @ByteBuffer.ReadUInt64Var
+func (m *ChunkedBuffer) ReadUInt64Var() (uint64, error) {
+	x, n, err := read_uvarint_at(m.readFromPos, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	return x, nil
+}
@@ -0,0 +1,178 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestNewChunkedBuffer(t *testing.T) {
+	tag := "NewChunkedBuffer()"
+
+	test_sizes := []uint{0, 1, 3, 5, 1024}
+
+	for i := 0; i < len(test_sizes); i++ {
+		expected_size := test_sizes[i]
+		b := NewChunkedBuffer(expected_size, 4)
+		size := b.Size()
+		if size != expected_size {
+			t.Fatalf(tag+" size error, expected %v, found %v", expected_size, size)
+		}
+	}
+
+	b := NewChunkedBuffer(0, 0)
+	if b.ChunkSize() != KCHUNK_SIZE {
+		t.Fatalf(tag+" chunk size error, expected %v, found %v", KCHUNK_SIZE, b.ChunkSize())
+	}
+}
+
+func TestChunkedBufferWrite(t *testing.T) {
+	tag := "ChunkedBuffer.Write()"
+
+	// same sequence of writes on both buffers, chunk boundaries fall in the
+	// middle of every write
+	b := NewChunkedBuffer(0, 5)
+	expected := NewByteBuffer(0)
+	buff := []byte{'a', 'b', 'c', 'd', 'e', 'f', 'g'}
+
+	for i := 0; i < 5; i++ {
+		b.Write(buff)
+		expected.Write(buff)
+	}
+
+	b.SeekFromStart(3)
+	expected.SeekFromStart(3)
+	b.Write(buff)
+	expected.Write(buff)
+
+	off := int64(expected.Size() - 3)
+	b.WriteAt(buff, off)
+	expected.WriteAt(buff, off)
+
+	b.WriteByte('x')
+	expected.WriteByte('x')
+
+	if bytes.Compare(expected.Bytes(), b.Bytes()) != 0 {
+		t.Fatalf(tag+" data mismatch\nexpected [%v]\nfound [%v]", expected.Bytes(), b.Bytes())
+	}
+	if expected.Pos() != b.Pos() {
+		t.Fatalf(tag+" unexpected position, expected %v, found %v", expected.Pos(), b.Pos())
+	}
+	if b.ByteBuffer().CmpWith(expected) != 0 {
+		t.Fatal(tag + " ByteBuffer data mismatch")
+	}
+
+	_, err := b.WriteAt(buff, int64(b.Size()))
+	if err != ErrOffsetOverflow {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrOffsetOverflow.Error(), errOrNilStr(err))
+	}
+}
+
+func TestChunkedBufferReadAt(t *testing.T) {
+	tag := "ChunkedBuffer.ReadAt()"
+
+	b := NewChunkedBuffer(0, 4)
+	s := "abracadabra"
+	b.Write([]byte(s))
+
+	buff := make([]byte, 6)
+	n, err := b.ReadAt(buff, 3)
+	if err != nil {
+		t.Fatalf(tag+" unexpected error: %v", err.Error())
+	}
+	if n != len(buff) || string(buff) != s[3:9] {
+		t.Fatalf(tag+" unexpected read, expected [%v], found [%v]", s[3:9], string(buff[:n]))
+	}
+	if b.Pos() != len(s) {
+		t.Fatalf(tag+" unexpected position, expected %v, found %v", len(s), b.Pos())
+	}
+	n, err = b.Read(buff)
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+	b.SeekToStart()
+	data, err := io.ReadAll(b)
+	if err != nil || string(data) != s {
+		t.Fatalf(tag+" unexpected io.ReadAll result [%v] %v", string(data), errOrNilStr(err))
+	}
+
+	n, err = b.ReadAt(buff, 7)
+	if err != io.EOF {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.EOF.Error(), errOrNilStr(err))
+	}
+	if n != 4 || string(buff[:n]) != s[7:] {
+		t.Fatalf(tag+" unexpected read, expected [%v], found [%v]", s[7:], string(buff[:n]))
+	}
+
+	for i := 0; i < len(s); i++ {
+		c, err := b.ByteAt(i)
+		if err != nil {
+			t.Fatalf(tag+" unexpected read error: %v", err.Error())
+		}
+		if c != s[i] {
+			t.Fatalf(tag+" unexpected read value @%v, expected %v, found %v", i, s[i], c)
+		}
+	}
+}
+
+func TestChunkedBufferBuffers(t *testing.T) {
+	tag := "ChunkedBuffer.Buffers()"
+
+	b := NewChunkedBuffer(0, 4)
+	s := "abracadabra"
+	b.Write([]byte(s))
+
+	bufs := b.Buffers()
+	if len(bufs) != 3 {
+		t.Fatalf(tag+" unexpected number of buffers, expected 3, found %v", len(bufs))
+	}
+	if len(bufs[2]) != 3 {
+		t.Fatalf(tag+" unexpected last buffer size, expected 3, found %v", len(bufs[2]))
+	}
+
+	dst := NewByteBuffer(0)
+	n, err := b.WriteTo(dst)
+	if err != nil {
+		t.Fatalf(tag+" unexpected error: %v", err.Error())
+	}
+	if n != int64(len(s)) || string(dst.Bytes()) != s {
+		t.Fatalf(tag+" unexpected WriteTo result [%v]", string(dst.Bytes()))
+	}
+
+	// io.Copy goes through the reader
+	b.SeekToStart()
+	dst.Clear()
+	_, err = io.Copy(dst, io.LimitReader(b, 100))
+	if err != nil {
+		t.Fatalf(tag+" unexpected error in io.Copy: %v", err.Error())
+	}
+	if string(dst.Bytes()) != s {
+		t.Fatalf(tag+" unexpected io.Copy result [%v]", string(dst.Bytes()))
+	}
+}
@@ -0,0 +1,346 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/lzw"
+	"compress/zlib"
+	"errors"
+	"io"
+)
+
+// returned when the compression algorithm is unknown
+var ErrCompressAlgUnknown = errors.New("Unknown compression algorithm")
+
+// returned when decompressed data grows past the allowed size
+var ErrDecompressLimit = errors.New("Decompressed size limit exceeded")
+
+type CompressAlg int
+
+const (
+	KCOMPRESS_GZIP CompressAlg = iota
+	KCOMPRESS_ZLIB
+	KCOMPRESS_FLATE
+	// LSB order, 8 bit literals, same as gif and tiff, level is ignored
+	KCOMPRESS_LZW
+)
+
+// default maximum decompressed size, guards against decompression bombs
+const KDECOMPRESS_LIMIT = 256 << 20
+
+// LZW parameters used by KCOMPRESS_LZW
+const (
+	klzwOrder    = lzw.LSB
+	klzwLitWidth = 8
+)
+
+// returns a compressor of (alg) writing to w
+func compress_writer(w io.Writer, alg CompressAlg, level int) (io.WriteCloser, error) {
+	switch alg {
+	case KCOMPRESS_GZIP:
+		return gzip.NewWriterLevel(w, level)
+	case KCOMPRESS_ZLIB:
+		return zlib.NewWriterLevel(w, level)
+	case KCOMPRESS_FLATE:
+		return flate.NewWriter(w, level)
+	case KCOMPRESS_LZW:
+		return lzw.NewWriter(w, klzwOrder, klzwLitWidth), nil
+	}
+	return nil, ErrCompressAlgUnknown
+}
+
+// returns a decompressor of (alg) reading from r
+func decompress_reader(r io.Reader, alg CompressAlg) (io.ReadCloser, error) {
+	switch alg {
+	case KCOMPRESS_GZIP:
+		return gzip.NewReader(r)
+	case KCOMPRESS_ZLIB:
+		return zlib.NewReader(r)
+	case KCOMPRESS_FLATE:
+		return flate.NewReader(r), nil
+	case KCOMPRESS_LZW:
+		return lzw.NewReader(r, klzwOrder, klzwLitWidth), nil
+	}
+	return nil, ErrCompressAlgUnknown
+}
+
+// replaces buffer contents with p, position is reset to ZERO
+func (m *ByteBuffer) replace(p []byte) {
+	m.journal(0, len(m.buff))
+	m.protect(0, cap(m.buff)+1)
+	m.buff = p
+	m.pos = 0
+	m.marks.clamp(len(p))
+}
+
+// returns a new buffer holding the compressed contents of this
+// errors:
+//	ErrCompressAlgUnknown
+//	errors of the compress package, e.g. an invalid level
+// NOTE:
+//	- level is one of the compress/flate levels, e.g. flate.BestSpeed
+func (m *ByteBuffer) Compressed(alg CompressAlg, level int) (*ByteBuffer, error) {
+	m.checkReleased()
+
+	r := NewByteBuffer(0)
+	w, err := compress_writer(r, alg, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(m.buff); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	r.pos = 0
+	return r, nil
+}
+
+// compresses buffer contents in place, position is reset to ZERO
+// errors:
+//	@ByteBuffer.Compressed, the buffer is left untouched on error
+func (m *ByteBuffer) Compress(alg CompressAlg, level int) error {
+	r, err := m.Compressed(alg, level)
+	if err != nil {
+		return err
+	}
+	m.replace(r.buff)
+	return nil
+}
+
+// returns a new buffer holding the decompressed contents of this
+// errors:
+//	ErrCompressAlgUnknown
+//	ErrDecompressLimit, decompressed data is larger than limit bytes
+//	errors of the compress package, e.g. corrupt input
+func (m *ByteBuffer) Decompressed(alg CompressAlg, limit int64) (*ByteBuffer, error) {
+	m.checkReleased()
+
+	d, err := decompress_reader(m.NewCursor(), alg)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	r := NewByteBuffer(0)
+	// read one byte past the limit to tell "exactly limit" from "more"
+	n, err := io.Copy(r, io.LimitReader(d, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, ErrDecompressLimit
+	}
+	r.pos = 0
+	return r, nil
+}
+
+// @ByteBuffer.DecompressLimit(alg, KDECOMPRESS_LIMIT)
+func (m *ByteBuffer) Decompress(alg CompressAlg) error {
+	return m.DecompressLimit(alg, KDECOMPRESS_LIMIT)
+}
+
+// decompresses buffer contents in place, position is reset to ZERO
+// errors:
+//	@ByteBuffer.Decompressed, the buffer is left untouched on error
+func (m *ByteBuffer) DecompressLimit(alg CompressAlg, limit int64) error {
+	r, err := m.Decompressed(alg, limit)
+	if err != nil {
+		return err
+	}
+	m.replace(r.buff)
+	return nil
+}
+
+// compresses the bytes in between a cursor and the end of the buffer
+type compressReader struct {
+	src  *Cursor
+	w    io.WriteCloser
+	out  *QueueBuffer
+	in   []byte
+	done bool
+}
+
+// returns a reader of the compressed bytes in between current position and
+// the end of buffer, data is compressed as it is read
+// errors:
+//	@ByteBuffer.Compressed
+// NOTE:
+//	- buffer position is left untouched
+//	- the buffer must not be written to until the reader is done
+func (m *ByteBuffer) NewCompressReader(alg CompressAlg, level int) (io.ReadCloser, error) {
+	m.checkReleased()
+
+	r := &compressReader{
+		src: &Cursor{b: m, pos: m.pos},
+		out: NewQueueBuffer(0),
+		in:  make([]byte, 32*1024),
+	}
+	w, err := compress_writer(r.out, alg, level)
+	if err != nil {
+		return nil, err
+	}
+	r.w = w
+	return r, nil
+}
+
+// io.Reader implementation
+func (m *compressReader) Read(p []byte) (int, error) {
+	// the compressor holds data back, feed it until output shows up
+	for m.out.Len() == 0 && !m.done {
+		n, _ := m.src.Read(m.in)
+		if n > 0 {
+			if _, err := m.w.Write(m.in[:n]); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		m.done = true
+		if err := m.w.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if m.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return m.out.Read(p)
+}
+
+// io.Closer implementation, drops any unread output
+func (m *compressReader) Close() error {
+	m.done = true
+	m.out.Reset()
+	return nil
+}
+
+// compresses written bytes into the buffer
+type compressWriter struct {
+	b   *ByteBuffer
+	w   io.WriteCloser
+	off int
+}
+
+// returns a writer that compresses what is written to it into the buffer at
+// current position, Close must be called to flush the compressed stream
+// errors:
+//	@ByteBuffer.Compressed
+// NOTE:
+//	- bytes at current position are overwritten, the buffer grows as needed
+//	- position follows the compressed stream
+func (m *ByteBuffer) NewCompressWriter(alg CompressAlg, level int) (io.WriteCloser, error) {
+	m.checkReleased()
+
+	cw := &compressWriter{b: m, off: m.pos}
+	w, err := compress_writer(compressSink{cw}, alg, level)
+	if err != nil {
+		return nil, err
+	}
+	cw.w = w
+	return cw, nil
+}
+
+// io.Writer implementation
+func (m *compressWriter) Write(p []byte) (int, error) {
+	return m.w.Write(p)
+}
+
+// io.Closer implementation, flushes the compressed stream into the buffer
+func (m *compressWriter) Close() error {
+	return m.w.Close()
+}
+
+// receives the compressor output, unlike ByteBuffer.Write it always moves
+// past what it wrote
+type compressSink struct {
+	m *compressWriter
+}
+
+func (s compressSink) Write(p []byte) (int, error) {
+	b := s.m.b
+	b.checkReleased()
+
+	_, n, err := b.writeFromPos(p, s.m.off)
+	if err != nil {
+		return 0, err
+	}
+	s.m.off += n
+	b.pos = s.m.off
+	return n, nil
+}
+
+// decompresses the bytes in between a cursor and the end of the buffer
+type decompressReader struct {
+	d     io.ReadCloser
+	limit int64
+	n     int64
+}
+
+// returns a reader of the decompressed bytes in between current position
+// and the end of buffer
+// errors:
+//	ErrCompressAlgUnknown
+//	errors of the compress package, e.g. a bad gzip header
+// NOTE:
+//	- Read returns ErrDecompressLimit once more than limit bytes came out
+//	- buffer position is left untouched
+//	- the buffer must not be written to until the reader is done
+func (m *ByteBuffer) NewDecompressReader(alg CompressAlg, limit int64) (io.ReadCloser, error) {
+	m.checkReleased()
+
+	d, err := decompress_reader(&Cursor{b: m, pos: m.pos}, alg)
+	if err != nil {
+		return nil, err
+	}
+	return &decompressReader{d: d, limit: limit}, nil
+}
+
+// io.Reader implementation
+func (m *decompressReader) Read(p []byte) (int, error) {
+	if m.n >= m.limit {
+		// anything left means the limit is exceeded
+		var probe [1]byte
+		n, err := m.d.Read(probe[:])
+		if n > 0 {
+			return 0, ErrDecompressLimit
+		}
+		return 0, err
+	}
+	if int64(len(p)) > m.limit-m.n {
+		p = p[:m.limit-m.n]
+	}
+	n, err := m.d.Read(p)
+	m.n += int64(n)
+	return n, err
+}
+
+// io.Closer implementation
+func (m *decompressReader) Close() error {
+	return m.d.Close()
+}
@@ -0,0 +1,181 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"testing"
+)
+
+var compressAlgs = []CompressAlg{KCOMPRESS_GZIP, KCOMPRESS_ZLIB, KCOMPRESS_FLATE, KCOMPRESS_LZW}
+
+func compressSample() []byte {
+	return bytes.Repeat([]byte("a config blob that compresses rather well, "), 200)
+}
+
+func TestByteBufferCompress(t *testing.T) {
+	tag := "ByteBuffer.Compress/Decompress()"
+
+	data := compressSample()
+	for _, alg := range compressAlgs {
+		b := NewByteBuffer(0)
+		b.Write(data)
+
+		err := b.Compress(alg, flate.BestCompression)
+		if err != nil {
+			t.Fatalf(tag+" alg %v unexpected compress error: %v", alg, err)
+		}
+		if b.Size() >= uint(len(data)) || b.Pos() != 0 {
+			t.Fatalf(tag+" alg %v unexpected size %v, position %v", alg, b.Size(), b.Pos())
+		}
+
+		// into a new buffer, this one untouched
+		c, err := b.Decompressed(alg, KDECOMPRESS_LIMIT)
+		if err != nil || !bytes.Equal(c.Bytes(), data) {
+			t.Fatalf(tag+" alg %v unexpected decompressed copy, error [%v]", alg, errOrNilStr(err))
+		}
+
+		err = b.Decompress(alg)
+		if err != nil || !bytes.Equal(b.Bytes(), data) {
+			t.Fatalf(tag+" alg %v unexpected decompressed contents, error [%v]", alg, errOrNilStr(err))
+		}
+	}
+}
+
+func TestByteBufferCompressErrors(t *testing.T) {
+	tag := "ByteBuffer.Compress(errors)"
+
+	b := NewByteBuffer(0)
+	b.Write(compressSample())
+	original := b.Bytes()
+
+	if err := b.Compress(CompressAlg(42), 0); err != ErrCompressAlgUnknown {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrCompressAlgUnknown, errOrNilStr(err))
+	}
+	if err := b.Compress(KCOMPRESS_GZIP, 42); err == nil {
+		t.Fatal(tag + " invalid level accepted")
+	}
+	// not compressed, decompression fails and leaves contents alone
+	if err := b.Decompress(KCOMPRESS_ZLIB); err == nil {
+		t.Fatal(tag + " decompressed garbage")
+	}
+	if !bytes.Equal(b.Bytes(), original) {
+		t.Fatal(tag + " contents changed by failed calls")
+	}
+
+	// a small bomb, limit exactly at the size is fine, one byte less is not
+	b.Compress(KCOMPRESS_GZIP, flate.BestCompression)
+	if _, err := b.Decompressed(KCOMPRESS_GZIP, int64(len(original))); err != nil {
+		t.Fatalf(tag+" unexpected error at the limit: %v", err)
+	}
+	err := b.DecompressLimit(KCOMPRESS_GZIP, int64(len(original)-1))
+	if err != ErrDecompressLimit {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrDecompressLimit, errOrNilStr(err))
+	}
+}
+
+func TestByteBufferCompressTx(t *testing.T) {
+	tag := "ByteBuffer.Compress(tx)"
+
+	b := NewByteBuffer(0)
+	b.Write(compressSample())
+	original := b.Bytes()
+
+	tx := b.Begin()
+	b.Compress(KCOMPRESS_FLATE, flate.DefaultCompression)
+	tx.Rollback()
+	if !bytes.Equal(b.Bytes(), original) {
+		t.Fatal(tag + " rollback did not restore the uncompressed contents")
+	}
+}
+
+func TestByteBufferCompressAdapters(t *testing.T) {
+	tag := "ByteBuffer.NewCompressReader/Writer()"
+
+	header := []byte("HEADER--")
+	data := compressSample()
+
+	for _, alg := range compressAlgs {
+		// compress everything after the header through the writer
+		b := NewByteBuffer(0)
+		b.Write(header)
+		w, err := b.NewCompressWriter(alg, flate.BestSpeed)
+		if err != nil {
+			t.Fatalf(tag+" alg %v unexpected writer error: %v", alg, err)
+		}
+		for i := 0; i < len(data); i += 100 {
+			w.Write(data[i:min_int(i+100, len(data))])
+		}
+		w.Close()
+		if b.Pos() != int(b.Size()) {
+			t.Fatalf(tag+" alg %v position not at the end of the stream, %v of %v", alg, b.Pos(), b.Size())
+		}
+		if !bytes.Equal(b.Bytes()[:len(header)], header) {
+			t.Fatalf(tag+" alg %v header overwritten", alg)
+		}
+
+		// decompress from the cursor
+		b.SeekFromStart(int64(len(header)))
+		r, err := b.NewDecompressReader(alg, KDECOMPRESS_LIMIT)
+		if err != nil {
+			t.Fatalf(tag+" alg %v unexpected reader error: %v", alg, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf(tag+" alg %v unexpected decompressed data, error [%v]", alg, errOrNilStr(err))
+		}
+		if b.Pos() != len(header) {
+			t.Fatalf(tag+" alg %v reader moved position to %v", alg, b.Pos())
+		}
+
+		// limit on the streaming reader
+		r, _ = b.NewDecompressReader(alg, 1000)
+		_, err = io.ReadAll(r)
+		if err != ErrDecompressLimit {
+			t.Fatalf(tag+" alg %v unexpected error, expected [%v], found [%v]", alg, ErrDecompressLimit, errOrNilStr(err))
+		}
+
+		// compress reader over the plain data from a cursor
+		p := NewByteBuffer(0)
+		p.Write(header)
+		p.Write(data)
+		p.SeekFromStart(int64(len(header)))
+		cr, err := p.NewCompressReader(alg, flate.BestSpeed)
+		if err != nil {
+			t.Fatalf(tag+" alg %v unexpected compress reader error: %v", alg, err)
+		}
+		z := NewByteBuffer(0)
+		io.Copy(z, cr)
+		cr.Close()
+		if err = z.Decompress(alg); err != nil || !bytes.Equal(z.Bytes(), data) {
+			t.Fatalf(tag+" alg %v compress reader round trip failed, error [%v]", alg, errOrNilStr(err))
+		}
+	}
+}
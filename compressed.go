@@ -0,0 +1,652 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// returned when a serialized CompressedBuffer is malformed or a frame fails
+// its checksum
+var ErrCompressedCorrupt = errors.New("Corrupt compressed buffer")
+
+// default size of uncompressed frames
+const KCOMPRESSED_FRAME_SIZE = 64 * 1024
+
+// default number of decompressed frames kept around
+const KCOMPRESSED_CACHE_FRAMES = 4
+
+// magic bytes at both ends of a serialized CompressedBuffer
+const KCOMPRESSED_MAGIC = "MBCF"
+
+// serialization format version
+const kcompressedVersion = 1
+
+// size of the serialized trailer, index offset, index crc32 and magic
+const kcompressedTrailer = 8 + 4 + len(KCOMPRESSED_MAGIC)
+
+// a compressed frame, data is nil while the frame still lives in the
+// io.ReaderAt the buffer was opened from
+type compressedFrame struct {
+	data []byte
+	off  int64
+	clen int
+	// crc32 of the uncompressed frame
+	sum uint32
+}
+
+// a decompressed frame in the cache
+type cachedFrame struct {
+	idx   int
+	plain []byte
+}
+
+// a byte buffer that keeps its contents as independently compressed frames of
+// a fixed size, only the frames a read or write touches are decompressed
+// the last frame stays uncompressed until it fills up
+// NOTE:
+//	- not safe for concurrent use, even ReadAt updates the frame cache
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//  io.WriterTo
+//	io.ByteReader
+//	io.ByteWriter
+type CompressedBuffer struct {
+	alg       CompressAlg
+	level     int
+	frameSize int
+	// full frames, compressed
+	frames []compressedFrame
+	// last frame, shorter than frameSize, uncompressed
+	tail []byte
+	pos  int
+	// where frames of an opened buffer are read from
+	src io.ReaderAt
+	// most recently used first
+	cache      []cachedFrame
+	cacheLimit int
+	// number of frames decompressed so far
+	decompressed int
+}
+
+// creates a new empty CompressedBuffer, frames of (frameSize) bytes are
+// compressed with (alg) at (level)
+// errors:
+//	@ByteBuffer.Compressed
+// NOTE:
+//	- passing ZERO for frameSize selects KCOMPRESSED_FRAME_SIZE
+func NewCompressedBuffer(alg CompressAlg, level int, frameSize uint) (*CompressedBuffer, error) {
+	// fail early on a bad algorithm or level
+	w, err := compress_writer(io.Discard, alg, level)
+	if err != nil {
+		return nil, err
+	}
+	w.Close()
+
+	if frameSize == 0 {
+		frameSize = KCOMPRESSED_FRAME_SIZE
+	}
+	return &CompressedBuffer{
+		alg:        alg,
+		level:      level,
+		frameSize:  int(frameSize),
+		cacheLimit: KCOMPRESSED_CACHE_FRAMES,
+	}, nil
+}
+
+// opens a CompressedBuffer serialized by WriteTo, (size) is the size of the
+// serialized data in r
+// frames are read from r as they are needed, r must outlive the buffer
+// errors:
+//	ErrCompressedCorrupt
+//	errors returned by r
+func OpenCompressedBuffer(r io.ReaderAt, size int64) (*CompressedBuffer, error) {
+	if size < int64(kcompressedTrailer) {
+		return nil, ErrCompressedCorrupt
+	}
+
+	// trailer: index offset, index crc32, magic
+	trailer := make([]byte, kcompressedTrailer)
+	if _, err := r.ReadAt(trailer, size-int64(kcompressedTrailer)); err != nil {
+		return nil, err
+	}
+	if string(trailer[12:]) != KCOMPRESSED_MAGIC {
+		return nil, ErrCompressedCorrupt
+	}
+	indexOff := int64(binary.BigEndian.Uint64(trailer))
+	indexEnd := size - int64(kcompressedTrailer)
+	if indexOff < 0 || indexOff > indexEnd {
+		return nil, ErrCompressedCorrupt
+	}
+	index := make([]byte, indexEnd-indexOff)
+	if _, err := r.ReadAt(index, indexOff); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(index) != binary.BigEndian.Uint32(trailer[8:]) {
+		return nil, ErrCompressedCorrupt
+	}
+
+	// header: magic, version, algorithm, level, frame size
+	header := make([]byte, len(KCOMPRESSED_MAGIC)+3+binary.MaxVarintLen64)
+	n, err := r.ReadAt(header, 0)
+	if n < len(KCOMPRESSED_MAGIC)+4 {
+		if err == nil {
+			err = ErrCompressedCorrupt
+		}
+		return nil, err
+	}
+	h := NewByteBuffer(0)
+	h.Write(header[:n])
+	h.pos = len(KCOMPRESSED_MAGIC)
+	if string(header[:len(KCOMPRESSED_MAGIC)]) != KCOMPRESSED_MAGIC || header[h.pos] != kcompressedVersion {
+		return nil, ErrCompressedCorrupt
+	}
+	m := &CompressedBuffer{
+		alg:        CompressAlg(header[h.pos+1]),
+		level:      int(int8(header[h.pos+2])),
+		src:        r,
+		cacheLimit: KCOMPRESSED_CACHE_FRAMES,
+	}
+	h.pos += 3
+	frameSize, err := h.ReadUInt64Var()
+	if err != nil || frameSize == 0 || frameSize > 1<<31 {
+		return nil, ErrCompressedCorrupt
+	}
+	m.frameSize = int(frameSize)
+
+	// index: frame count, per frame compressed size and crc32, plain size
+	ib := NewByteBuffer(0)
+	ib.Write(index)
+	ib.pos = 0
+	count, err := ib.ReadUInt64Var()
+	if err != nil || count > uint64(len(index)) {
+		return nil, ErrCompressedCorrupt
+	}
+	off := int64(h.pos)
+	frames := make([]compressedFrame, count)
+	for i := range frames {
+		clen, err := ib.ReadUInt64Var()
+		if err != nil || clen > uint64(indexOff-off) {
+			return nil, ErrCompressedCorrupt
+		}
+		sum, err := ib.ReadUInt32(binary.BigEndian)
+		if err != nil {
+			return nil, ErrCompressedCorrupt
+		}
+		frames[i] = compressedFrame{off: off, clen: int(clen), sum: sum}
+		off += int64(clen)
+	}
+	plainSize, err := ib.ReadUInt64Var()
+	if err != nil || off != indexOff {
+		return nil, ErrCompressedCorrupt
+	}
+	if (plainSize+frameSize-1)/frameSize != count {
+		return nil, ErrCompressedCorrupt
+	}
+	m.frames = frames
+
+	// a partial last frame becomes the uncompressed tail
+	if last := int(plainSize % frameSize); last != 0 {
+		tail, err := m.loadFrame(len(frames)-1, last)
+		if err != nil {
+			return nil, err
+		}
+		m.frames = m.frames[:len(frames)-1]
+		m.tail = tail
+	}
+	return m, nil
+}
+
+// sets the number of decompressed frames kept around, ZERO disables caching
+func (m *CompressedBuffer) SetCacheFrames(n int) {
+	m.cacheLimit = max_int(n, 0)
+	if len(m.cache) > m.cacheLimit {
+		m.cache = m.cache[:m.cacheLimit]
+	}
+}
+
+// returns size in bytes of the uncompressed contents
+func (m *CompressedBuffer) Size() uint {
+	return uint(m.len())
+}
+
+func (m *CompressedBuffer) len() int {
+	return len(m.frames)*m.frameSize + len(m.tail)
+}
+
+// returns the size in bytes of the compressed frames, the uncompressed last
+// frame is not included
+func (m *CompressedBuffer) CompressedSize() int64 {
+	var n int64
+	for _, f := range m.frames {
+		n += int64(f.clen)
+	}
+	return n
+}
+
+// returns the number of compressed frames
+func (m *CompressedBuffer) Frames() int {
+	return len(m.frames)
+}
+
+// returns frame size
+func (m *CompressedBuffer) FrameSize() int {
+	return m.frameSize
+}
+
+// returns internal buffer position
+func (m *CompressedBuffer) Pos() int {
+	return m.pos
+}
+
+// returns true if the buffer holds no bytes
+func (m *CompressedBuffer) Empty() bool {
+	return m.len() == 0
+}
+
+// returns the decompressed contents of frame (i) of (size) bytes, bypassing the
+// cache
+func (m *CompressedBuffer) loadFrame(i int, size int) ([]byte, error) {
+	f := m.frames[i]
+	data := f.data
+	if data == nil {
+		data = make([]byte, f.clen)
+		if _, err := m.src.ReadAt(data, f.off); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	d, err := decompress_reader(bytes.NewReader(data), m.alg)
+	if err != nil {
+		return nil, ErrCompressedCorrupt
+	}
+	defer d.Close()
+	plain := make([]byte, size)
+	if _, err := io.ReadFull(d, plain); err != nil {
+		return nil, ErrCompressedCorrupt
+	}
+	if crc32.ChecksumIEEE(plain) != f.sum {
+		return nil, ErrCompressedCorrupt
+	}
+	m.decompressed++
+	return plain, nil
+}
+
+// returns the uncompressed contents of frame (i), the tail included
+func (m *CompressedBuffer) frame(i int) ([]byte, error) {
+	if i == len(m.frames) {
+		return m.tail, nil
+	}
+	for k, c := range m.cache {
+		if c.idx == i {
+			// move to front
+			copy(m.cache[1:k+1], m.cache[:k])
+			m.cache[0] = c
+			return c.plain, nil
+		}
+	}
+	plain, err := m.loadFrame(i, m.frameSize)
+	if err != nil {
+		return nil, err
+	}
+	m.cacheFrame(i, plain)
+	return plain, nil
+}
+
+// puts frame (i) at the front of the cache
+func (m *CompressedBuffer) cacheFrame(i int, plain []byte) {
+	if m.cacheLimit == 0 {
+		return
+	}
+	for k, c := range m.cache {
+		if c.idx == i {
+			m.cache = append(m.cache[:k], m.cache[k+1:]...)
+			break
+		}
+	}
+	if len(m.cache) == m.cacheLimit {
+		m.cache = m.cache[:len(m.cache)-1]
+	}
+	m.cache = append(m.cache, cachedFrame{})
+	copy(m.cache[1:], m.cache)
+	m.cache[0] = cachedFrame{idx: i, plain: plain}
+}
+
+// compresses plain into a frame
+func (m *CompressedBuffer) compressFrame(plain []byte) (compressedFrame, error) {
+	var out bytes.Buffer
+	w, err := compress_writer(&out, m.alg, m.level)
+	if err != nil {
+		return compressedFrame{}, err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return compressedFrame{}, err
+	}
+	if err := w.Close(); err != nil {
+		return compressedFrame{}, err
+	}
+	return compressedFrame{
+		data: out.Bytes(),
+		clen: out.Len(),
+		sum:  crc32.ChecksumIEEE(plain),
+	}, nil
+}
+
+// check if p is overflowing buffer
+func (m *CompressedBuffer) posOverflow(p int) bool {
+	return p >= m.len()
+}
+
+// @CompressedBuffer.Seek(offset, io.SeekStart)
+func (m *CompressedBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @CompressedBuffer.Seek(offset, io.SeekCurrent)
+func (m *CompressedBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @CompressedBuffer.Seek(offset, io.SeekEnd)
+func (m *CompressedBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @CompressedBuffer.Seek(0, io.SeekStart)
+func (m *CompressedBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @CompressedBuffer.Seek(0, io.SeekEnd)
+func (m *CompressedBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation, same rules as ByteBuffer.Seek
+// NOTE:
+//	- seeking doesn't decompress anything, reads do
+// errors:
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *CompressedBuffer) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seek_abs(offset, whence, m.pos, m.len())
+	if err != nil {
+		return -1, err
+	}
+	if m.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+	m.pos = pos
+	return int64(pos), nil
+}
+
+func (m *CompressedBuffer) readFromPos(p []byte, pos int) (n int, err error) {
+	if pos >= m.len() {
+		return 0, io.EOF
+	}
+	for n < len(p) && pos < m.len() {
+		i := pos / m.frameSize
+		plain, err := m.frame(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], plain[pos-i*m.frameSize:])
+		n += c
+		pos += c
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// io.Reader implementation, same rules as ByteBuffer.Read
+func (m *CompressedBuffer) Read(p []byte) (n int, err error) {
+	n, err = m.readFromPos(p, m.pos)
+	if n > 0 {
+		m.pos += n
+	}
+	return n, err
+}
+
+// io.ReaderAt implementation, only the frames in between off and off+len(p)
+// are decompressed
+// errors:
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+//	ErrCompressedCorrupt
+//	io.EOF
+func (m *CompressedBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+	return m.readFromPos(p, int(off))
+}
+
+// io.ByteReader implementation
+func (m *CompressedBuffer) ReadByte() (byte, error) {
+	p := make([]byte, 1)
+	n, err := m.Read(p)
+	if err != nil {
+		return 0, err
+	}
+	if n != 1 {
+		return 0, ErrByteRead
+	}
+	return p[0], nil
+}
+
+// returns a byte at a specific position in buffer
+func (m *CompressedBuffer) ByteAt(pos int) (byte, error) {
+	p := make([]byte, 1)
+	n, err := m.ReadAt(p, int64(pos))
+	if err != nil {
+		return 0, err
+	}
+	if n != 1 {
+		return 0, ErrByteRead
+	}
+	return p[0], nil
+}
+
+func (m *CompressedBuffer) writeFromPos(p []byte, pos int) (appended int, written int, err error) {
+	l := len(p)
+
+	// overwrite compressed frames, each one is decompressed, patched and
+	// compressed again
+	for len(p) > 0 && pos < len(m.frames)*m.frameSize {
+		i := pos / m.frameSize
+		plain, err := m.frame(i)
+		if err != nil {
+			return 0, l - len(p), err
+		}
+		plain = append([]byte{}, plain...)
+		c := copy(plain[pos-i*m.frameSize:], p)
+		f, err := m.compressFrame(plain)
+		if err != nil {
+			return 0, l - len(p), err
+		}
+		m.frames[i] = f
+		m.cacheFrame(i, plain)
+		p = p[c:]
+		pos += c
+	}
+
+	// overwrite the tail, then append to it
+	if len(p) > 0 && pos < m.len() {
+		c := copy(m.tail[pos-len(m.frames)*m.frameSize:], p)
+		p = p[c:]
+	}
+	appended = len(p)
+	for len(p) > 0 {
+		c := min_int(len(p), m.frameSize-len(m.tail))
+		m.tail = append(m.tail, p[:c]...)
+		p = p[c:]
+		if len(m.tail) == m.frameSize {
+			f, err := m.compressFrame(m.tail)
+			if err != nil {
+				// the full tail stays uncompressed, the next write retries
+				return appended - len(p), l - len(p), err
+			}
+			m.frames = append(m.frames, f)
+			m.tail = nil
+		}
+	}
+	return appended, l, nil
+}
+
+// io.Writer implementation, same rules as ByteBuffer.Write
+// NOTE:
+//	- overwriting compressed data recompresses every frame it touches
+func (m *CompressedBuffer) Write(p []byte) (n int, err error) {
+	appended, written, err := m.writeFromPos(p, m.pos)
+	m.pos += appended
+	if err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// io.WriteAt implementation, same rules as ByteBuffer.WriteAt
+// errors:
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+func (m *CompressedBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+	appended, written, err := m.writeFromPos(p, int(off))
+	m.pos += appended
+	if err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// io.ByteWriter implementation, always appends
+func (m *CompressedBuffer) WriteByte(c byte) error {
+	_, _, err := m.writeFromPos([]byte{c}, m.len())
+	m.pos = m.len()
+	return err
+}
+
+// returns the uncompressed contents in a new ByteBuffer
+// errors:
+//	ErrCompressedCorrupt
+func (m *CompressedBuffer) ByteBuffer() (*ByteBuffer, error) {
+	r := NewByteBuffer(uint(m.len()))
+	if m.len() == 0 {
+		return r, nil
+	}
+	if _, err := m.readFromPos(r.buff, 0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// io.WriterTo implementation, serializes the buffer, see OpenCompressedBuffer
+// format, integers are uvarints unless noted:
+//	"MBCF", version byte, algorithm byte, level as a signed byte, frame size
+//	compressed frames back to back, the last one may be shorter
+//	index:
+//		frame count
+//		per frame: compressed size, crc32 (IEEE) of the uncompressed frame
+//			as a big endian uint32
+//		uncompressed size
+//	trailer:
+//		offset of the index as a big endian uint64
+//		crc32 (IEEE) of the index as a big endian uint32
+//		"MBCF"
+func (m *CompressedBuffer) WriteTo(w io.Writer) (int64, error) {
+	frames := m.frames
+	if len(m.tail) > 0 {
+		f, err := m.compressFrame(m.tail)
+		if err != nil {
+			return 0, err
+		}
+		frames = append(frames[:len(frames):len(frames)], f)
+	}
+
+	var written int64
+	write := func(p []byte) error {
+		n, err := w.Write(p)
+		written += int64(n)
+		return err
+	}
+
+	h := NewByteBuffer(0)
+	h.Write([]byte(KCOMPRESSED_MAGIC))
+	h.Write([]byte{kcompressedVersion, byte(m.alg), byte(int8(m.level))})
+	h.WriteUInt64Var(uint64(m.frameSize))
+	if err := write(h.buff); err != nil {
+		return written, err
+	}
+
+	index := NewByteBuffer(0)
+	index.WriteUInt64Var(uint64(len(frames)))
+	for _, f := range frames {
+		data := f.data
+		if data == nil {
+			data = make([]byte, f.clen)
+			if _, err := m.src.ReadAt(data, f.off); err != nil && err != io.EOF {
+				return written, err
+			}
+		}
+		if err := write(data); err != nil {
+			return written, err
+		}
+		index.WriteUInt64Var(uint64(f.clen))
+		index.WriteUInt32(f.sum, binary.BigEndian)
+	}
+	index.WriteUInt64Var(uint64(m.len()))
+
+	indexOff := written
+	if err := write(index.buff); err != nil {
+		return written, err
+	}
+	trailer := make([]byte, kcompressedTrailer)
+	binary.BigEndian.PutUint64(trailer, uint64(indexOff))
+	binary.BigEndian.PutUint32(trailer[8:], crc32.ChecksumIEEE(index.buff))
+	copy(trailer[12:], KCOMPRESSED_MAGIC)
+	err := write(trailer)
+	return written, err
+}
@@ -0,0 +1,246 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// compressible random data, runs of random bytes
+func compressedSample(r *rand.Rand, n int) []byte {
+	p := make([]byte, 0, n)
+	for len(p) < n {
+		c := byte(r.Intn(16))
+		for i := r.Intn(32); i >= 0 && len(p) < n; i-- {
+			p = append(p, c)
+		}
+	}
+	return p
+}
+
+func TestNewCompressedBuffer(t *testing.T) {
+	tag := "NewCompressedBuffer()"
+
+	_, err := NewCompressedBuffer(CompressAlg(42), 0, 0)
+	if err != ErrCompressAlgUnknown {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrCompressAlgUnknown, errOrNilStr(err))
+	}
+	_, err = NewCompressedBuffer(KCOMPRESS_FLATE, 42, 0)
+	if err == nil {
+		t.Fatal(tag + " invalid level accepted")
+	}
+	b, err := NewCompressedBuffer(KCOMPRESS_FLATE, flate.DefaultCompression, 0)
+	if err != nil || b.FrameSize() != KCOMPRESSED_FRAME_SIZE || !b.Empty() {
+		t.Fatalf(tag+" unexpected buffer, error [%v]", errOrNilStr(err))
+	}
+}
+
+func TestCompressedBufferReadAt(t *testing.T) {
+	tag := "CompressedBuffer.ReadAt()"
+
+	r := rand.New(rand.NewSource(48))
+	data := compressedSample(r, 10*1024+123)
+
+	for _, alg := range compressAlgs {
+		b, _ := NewCompressedBuffer(alg, flate.BestSpeed, 1024)
+		// odd sized writes straddle frames
+		for i := 0; i < len(data); i += 777 {
+			b.Write(data[i:min_int(i+777, len(data))])
+		}
+		if b.Size() != uint(len(data)) || b.Frames() != 10 {
+			t.Fatalf(tag+" alg %v unexpected size %v, frames %v", alg, b.Size(), b.Frames())
+		}
+		if b.CompressedSize() >= int64(len(data)) {
+			t.Fatalf(tag+" alg %v data not compressed, %v bytes", alg, b.CompressedSize())
+		}
+
+		// a read inside one frame decompresses that frame only
+		before := b.decompressed
+		p := make([]byte, 100)
+		n, err := b.ReadAt(p, 5000)
+		if err != nil || n != 100 || !bytes.Equal(p, data[5000:5100]) {
+			t.Fatalf(tag+" alg %v unexpected read %v, error [%v]", alg, n, errOrNilStr(err))
+		}
+		if b.decompressed-before != 1 {
+			t.Fatalf(tag+" alg %v decompressed %v frames for one", alg, b.decompressed-before)
+		}
+		// cached now
+		b.ReadAt(p, 5010)
+		if b.decompressed-before != 1 {
+			t.Fatalf(tag+" alg %v cache missed", alg)
+		}
+
+		// across frames and into the tail
+		for i := 0; i < 50; i++ {
+			off := r.Intn(len(data))
+			p := make([]byte, r.Intn(3000))
+			n, err := b.ReadAt(p, int64(off))
+			expected := min_int(len(p), len(data)-off)
+			if n != expected || !bytes.Equal(p[:n], data[off:off+n]) {
+				t.Fatalf(tag+" alg %v unexpected read @%v, %v bytes, error [%v]", alg, off, n, errOrNilStr(err))
+			}
+			if n < len(p) && err != io.EOF {
+				t.Fatalf(tag+" alg %v short read without io.EOF", alg)
+			}
+		}
+
+		_, err = b.ReadAt(p, int64(len(data)))
+		if err != ErrOffsetOverflow {
+			t.Fatalf(tag+" alg %v unexpected error, expected [%v], found [%v]", alg, ErrOffsetOverflow, errOrNilStr(err))
+		}
+
+		// sequential reads end with ZERO bytes
+		b.SeekToStart()
+		got, err := io.ReadAll(b)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf(tag+" alg %v unexpected sequential read, error [%v]", alg, errOrNilStr(err))
+		}
+		n, err = b.Read(p)
+		if n != 0 || err != io.EOF {
+			t.Fatalf(tag+" alg %v unexpected read at the end %v [%v]", alg, n, errOrNilStr(err))
+		}
+	}
+}
+
+func TestCompressedBufferWrite(t *testing.T) {
+	tag := "CompressedBuffer.Write/WriteAt()"
+
+	r := rand.New(rand.NewSource(1))
+	data := compressedSample(r, 5000)
+
+	b, _ := NewCompressedBuffer(KCOMPRESS_ZLIB, flate.BestSpeed, 512)
+	b.Write(data)
+
+	// overwrite across compressed frames and the tail, then append
+	patch := bytes.Repeat([]byte{0xee}, 900)
+	n, err := b.WriteAt(patch, 4500)
+	if err != nil || n != 900 {
+		t.Fatalf(tag+" unexpected write %v, error [%v]", n, errOrNilStr(err))
+	}
+	expected := append(append([]byte{}, data[:4500]...), patch...)
+	if b.Size() != uint(len(expected)) {
+		t.Fatalf(tag+" unexpected size, expected %v, found %v", len(expected), b.Size())
+	}
+	b.WriteAt([]byte("hello"), 100)
+	copy(expected[100:], "hello")
+	b.WriteByte('!')
+	expected = append(expected, '!')
+
+	bb, err := b.ByteBuffer()
+	if err != nil || !bytes.Equal(bb.Bytes(), expected) {
+		t.Fatalf(tag+" unexpected contents, error [%v]", errOrNilStr(err))
+	}
+
+	// sequential reads
+	b.SeekToStart()
+	got, _ := io.ReadAll(b)
+	if !bytes.Equal(got, expected) {
+		t.Fatal(tag + " unexpected contents read back")
+	}
+}
+
+func TestCompressedBufferSerialize(t *testing.T) {
+	tag := "CompressedBuffer.WriteTo/OpenCompressedBuffer()"
+
+	r := rand.New(rand.NewSource(2))
+	data := compressedSample(r, 20000)
+
+	for _, size := range []int{0, 100, 4096, 20000} {
+		b, _ := NewCompressedBuffer(KCOMPRESS_GZIP, -1, 4096)
+		b.Write(data[:size])
+
+		var out bytes.Buffer
+		n, err := b.WriteTo(&out)
+		if err != nil || n != int64(out.Len()) {
+			t.Fatalf(tag+" unexpected serialize result %v, error [%v]", n, errOrNilStr(err))
+		}
+
+		o, err := OpenCompressedBuffer(bytes.NewReader(out.Bytes()), int64(out.Len()))
+		if err != nil {
+			t.Fatalf(tag+" unexpected open error @%v: %v", size, err)
+		}
+		if o.Size() != uint(size) || o.level != -1 || o.alg != KCOMPRESS_GZIP {
+			t.Fatalf(tag+" unexpected reopened buffer @%v, size %v", size, o.Size())
+		}
+		// only the partial last frame is decompressed on open
+		if o.decompressed > 1 {
+			t.Fatalf(tag+" open decompressed %v frames", o.decompressed)
+		}
+		bb, _ := o.ByteBuffer()
+		if !bytes.Equal(bb.Bytes(), data[:size]) {
+			t.Fatalf(tag+" unexpected reopened contents @%v", size)
+		}
+
+		// keeps growing, and serializes again
+		for _, c := range []byte("more") {
+			o.WriteByte(c)
+		}
+		var again bytes.Buffer
+		o.WriteTo(&again)
+		o, err = OpenCompressedBuffer(bytes.NewReader(again.Bytes()), int64(again.Len()))
+		if err != nil {
+			t.Fatalf(tag+" unexpected reopen error @%v: %v", size, err)
+		}
+		bb, _ = o.ByteBuffer()
+		if !bytes.Equal(bb.Bytes(), append(append([]byte{}, data[:size]...), "more"...)) {
+			t.Fatalf(tag+" unexpected contents after append @%v", size)
+		}
+	}
+}
+
+func TestCompressedBufferCorrupt(t *testing.T) {
+	tag := "OpenCompressedBuffer(corrupt)"
+
+	b, _ := NewCompressedBuffer(KCOMPRESS_FLATE, flate.BestSpeed, 256)
+	b.Write(bytes.Repeat([]byte("0123456789"), 100))
+	var out bytes.Buffer
+	b.WriteTo(&out)
+	raw := out.Bytes()
+
+	for _, l := range []int{0, 5, len(raw) - 1} {
+		_, err := OpenCompressedBuffer(bytes.NewReader(raw[:l]), int64(l))
+		if err == nil {
+			t.Fatalf(tag+" truncated buffer @%v accepted", l)
+		}
+	}
+
+	// a damaged frame is caught by its checksum when read
+	bad := append([]byte{}, raw...)
+	bad[len(KCOMPRESSED_MAGIC)+5] ^= 0xff
+	o, err := OpenCompressedBuffer(bytes.NewReader(bad), int64(len(bad)))
+	if err != nil {
+		t.Fatalf(tag+" unexpected open error: %v", err)
+	}
+	_, err = o.ReadAt(make([]byte, 10), 0)
+	if err != ErrCompressedCorrupt {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrCompressedCorrupt, errOrNilStr(err))
+	}
+}
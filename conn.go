@@ -0,0 +1,312 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// number of in-flight writes a Conn with latency keeps before writers block
+const KCONN_INFLIGHT = 64
+
+// settings shared by both ends of a NewConnPair
+type ConnConfig struct {
+	// bytes buffered in each direction before writers block, ZERO selects
+	// KPIPE_HIGH_WATER
+	HighWater uint
+	// delay in between a write and the data becoming readable by the peer
+	Latency time.Duration
+	// bytes per second, writers are slowed down accordingly, ZERO means
+	// unlimited
+	Bandwidth int
+}
+
+// fake address of a Conn
+type connAddr string
+
+func (m connAddr) Network() string {
+	return "mbytes"
+}
+
+func (m connAddr) String() string {
+	return string(m)
+}
+
+// used in order to give every pair unique addresses
+var connPairs uint64
+
+// one end of an in-memory connection, see ConnPair
+// implemented interfaces
+//	net.Conn
+type Conn struct {
+	in     *BufferedPipe
+	out    *BufferedPipe
+	local  net.Addr
+	remote net.Addr
+	cfg    ConnConfig
+
+	closed    int32
+	closeOnce sync.Once
+
+	// latency only, writes are queued and delivered to out in order
+	segs      chan connSegment
+	wmu       sync.RWMutex
+	wclosed   bool
+	wdone     chan struct{}
+	wdoneOnce sync.Once
+
+	dmu       sync.Mutex
+	wdeadline time.Time
+}
+
+type connSegment struct {
+	at   time.Time
+	data []byte
+}
+
+// @NewConnPair(ConnConfig{})
+func ConnPair() (*Conn, *Conn) {
+	return NewConnPair(ConnConfig{})
+}
+
+// returns two connected in-memory net.Conn endpoints, what one end writes the
+// other one reads, each direction has its own buffer so both ends can write
+// first without deadlocking, unlike net.Pipe
+func NewConnPair(cfg ConnConfig) (*Conn, *Conn) {
+	id := atomic.AddUint64(&connPairs, 1)
+	a := connAddr(fmt.Sprintf("pair-%d:a", id))
+	b := connAddr(fmt.Sprintf("pair-%d:b", id))
+	ab := NewBufferedPipe(cfg.HighWater)
+	ba := NewBufferedPipe(cfg.HighWater)
+	return newConn(ba, ab, a, b, cfg), newConn(ab, ba, b, a, cfg)
+}
+
+func newConn(in, out *BufferedPipe, local, remote net.Addr, cfg ConnConfig) *Conn {
+	m := &Conn{
+		in:     in,
+		out:    out,
+		local:  local,
+		remote: remote,
+		cfg:    cfg,
+		wdone:  make(chan struct{}),
+	}
+	if cfg.Latency > 0 {
+		m.segs = make(chan connSegment, KCONN_INFLIGHT)
+		go m.deliver()
+	}
+	return m
+}
+
+// moves queued writes to the peer once their latency has passed
+func (m *Conn) deliver() {
+	for seg := range m.segs {
+		time.Sleep(time.Until(seg.at))
+		// errors mean the peer is gone, keep draining
+		m.out.Write(seg.data)
+	}
+	m.out.Close()
+}
+
+func (m *Conn) isClosed() bool {
+	return atomic.LoadInt32(&m.closed) != 0
+}
+
+// net.Conn implementation
+// errors:
+//	io.EOF, once the peer closed its write end and all data was read
+//	net.ErrClosed, after Close
+//	os.ErrDeadlineExceeded
+func (m *Conn) Read(p []byte) (int, error) {
+	if m.isClosed() {
+		return 0, net.ErrClosed
+	}
+	n, err := m.in.Read(p)
+	if err == io.ErrClosedPipe && m.isClosed() {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+// net.Conn implementation
+// errors:
+//	io.ErrClosedPipe, after CloseWrite or once the peer is closed
+//	net.ErrClosed, after Close
+//	os.ErrDeadlineExceeded
+func (m *Conn) Write(p []byte) (int, error) {
+	if m.isClosed() {
+		return 0, net.ErrClosed
+	}
+
+	// split writes in chunks of about 10ms worth of bandwidth
+	chunk := len(p)
+	if m.cfg.Bandwidth > 0 {
+		chunk = max_int(m.cfg.Bandwidth/100, 1)
+	}
+
+	written := 0
+	for written < len(p) {
+		q := p[written:min_int(written+chunk, len(p))]
+
+		if m.cfg.Bandwidth > 0 {
+			d := time.Duration(len(q)) * time.Second / time.Duration(m.cfg.Bandwidth)
+			err := m.sleep(d)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n, err := m.send(q)
+		written += n
+		if err != nil {
+			if m.isClosed() {
+				err = net.ErrClosed
+			}
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// waits for d, fails if the write deadline comes first
+func (m *Conn) sleep(d time.Duration) error {
+	m.dmu.Lock()
+	deadline := m.wdeadline
+	m.dmu.Unlock()
+
+	if !deadline.IsZero() && time.Now().Add(d).After(deadline) {
+		time.Sleep(time.Until(deadline))
+		return os.ErrDeadlineExceeded
+	}
+	time.Sleep(d)
+	return nil
+}
+
+// hands q over to the peer, right away or through the latency queue
+func (m *Conn) send(q []byte) (int, error) {
+	if m.segs == nil {
+		return m.out.Write(q)
+	}
+
+	m.wmu.RLock()
+	defer m.wmu.RUnlock()
+	if m.wclosed {
+		return 0, io.ErrClosedPipe
+	}
+
+	m.dmu.Lock()
+	deadline := m.wdeadline
+	m.dmu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	seg := connSegment{at: time.Now().Add(m.cfg.Latency), data: make([]byte, len(q))}
+	copy(seg.data, q)
+	select {
+	case m.segs <- seg:
+		return len(q), nil
+	case <-m.wdone:
+		return 0, io.ErrClosedPipe
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// closes the write end, the peer reads io.EOF once it has read everything
+// written so far, this end can still read
+func (m *Conn) CloseWrite() error {
+	if m.segs == nil {
+		return m.out.Close()
+	}
+	m.wdoneOnce.Do(func() {
+		// release blocked writers, then stop the queue once they're gone
+		close(m.wdone)
+		m.wmu.Lock()
+		m.wclosed = true
+		close(m.segs)
+		m.wmu.Unlock()
+	})
+	return nil
+}
+
+// net.Conn implementation, closes both directions
+// pending reads and writes are released with net.ErrClosed
+func (m *Conn) Close() error {
+	first := false
+	m.closeOnce.Do(func() {
+		first = true
+		atomic.StoreInt32(&m.closed, 1)
+		m.CloseWrite()
+		m.in.CloseRead()
+	})
+	if !first {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// net.Conn implementation
+func (m *Conn) LocalAddr() net.Addr {
+	return m.local
+}
+
+// net.Conn implementation
+func (m *Conn) RemoteAddr() net.Addr {
+	return m.remote
+}
+
+// net.Conn implementation
+func (m *Conn) SetDeadline(t time.Time) error {
+	m.SetReadDeadline(t)
+	return m.SetWriteDeadline(t)
+}
+
+// net.Conn implementation
+func (m *Conn) SetReadDeadline(t time.Time) error {
+	return m.in.SetReadDeadline(t)
+}
+
+// net.Conn implementation
+func (m *Conn) SetWriteDeadline(t time.Time) error {
+	m.dmu.Lock()
+	m.wdeadline = t
+	m.dmu.Unlock()
+	if m.segs != nil {
+		// the delivery goroutine writes to out, it must never time out
+		return nil
+	}
+	return m.out.SetWriteDeadline(t)
+}
@@ -0,0 +1,162 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConnPairWriteFirst(t *testing.T) {
+	tag := "ConnPair(write first)"
+
+	a, b := ConnPair()
+	var _ net.Conn = a
+
+	// both ends write before reading, net.Pipe would deadlock here
+	_, err := a.Write([]byte("hello from a"))
+	if err != nil {
+		t.Fatalf(tag+" unexpected write error: %v", err.Error())
+	}
+	_, err = b.Write([]byte("hello from b"))
+	if err != nil {
+		t.Fatalf(tag+" unexpected write error: %v", err.Error())
+	}
+
+	rbuff := make([]byte, 32)
+	n, _ := a.Read(rbuff)
+	if string(rbuff[:n]) != "hello from b" {
+		t.Fatalf(tag+" unexpected read [%v]", string(rbuff[:n]))
+	}
+	n, _ = b.Read(rbuff)
+	if string(rbuff[:n]) != "hello from a" {
+		t.Fatalf(tag+" unexpected read [%v]", string(rbuff[:n]))
+	}
+
+	if a.LocalAddr().String() != b.RemoteAddr().String() || a.LocalAddr().String() == b.LocalAddr().String() {
+		t.Fatalf(tag+" unexpected addresses %v/%v", a.LocalAddr(), b.LocalAddr())
+	}
+
+	a.Close()
+	_, err = a.Read(rbuff)
+	if err != net.ErrClosed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", net.ErrClosed.Error(), errOrNilStr(err))
+	}
+	_, err = b.Read(rbuff)
+	if err != io.EOF {
+		t.Fatalf(tag+" expected io.EOF, found %v", errOrNilStr(err))
+	}
+	_, err = b.Write(rbuff)
+	if err != io.ErrClosedPipe {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrClosedPipe.Error(), errOrNilStr(err))
+	}
+}
+
+func TestConnPairCloseWrite(t *testing.T) {
+	tag := "Conn.CloseWrite()"
+
+	test_configs := []ConnConfig{{}, {Latency: time.Millisecond}}
+	for _, cfg := range test_configs {
+		a, b := NewConnPair(cfg)
+		a.Write([]byte("request"))
+		a.CloseWrite()
+
+		// b reads until EOF then still answers
+		dst := NewByteBuffer(0)
+		io.Copy(dst, b)
+		if string(dst.Bytes()) != "request" {
+			t.Fatalf(tag+" unexpected request [%v]", string(dst.Bytes()))
+		}
+		b.Write([]byte("response"))
+		b.Close()
+
+		dst.Clear()
+		io.Copy(dst, a)
+		if string(dst.Bytes()) != "response" {
+			t.Fatalf(tag+" unexpected response [%v]", string(dst.Bytes()))
+		}
+		_, err := a.Write([]byte{1})
+		if err != io.ErrClosedPipe {
+			t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrClosedPipe.Error(), errOrNilStr(err))
+		}
+		a.Close()
+	}
+}
+
+func TestConnPairDeadline(t *testing.T) {
+	tag := "Conn(deadline)"
+
+	a, b := NewConnPair(ConnConfig{HighWater: 4})
+	defer a.Close()
+	defer b.Close()
+
+	a.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := a.Read(make([]byte, 1))
+	if err != os.ErrDeadlineExceeded {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", os.ErrDeadlineExceeded.Error(), errOrNilStr(err))
+	}
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf(tag+" expected a net.Error timeout, found %v", err)
+	}
+
+	a.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	n, err := a.Write([]byte("abracadabra"))
+	if err != os.ErrDeadlineExceeded || n != 4 {
+		t.Fatalf(tag+" unexpected write result %v %v", n, errOrNilStr(err))
+	}
+}
+
+func TestConnPairLatencyBandwidth(t *testing.T) {
+	tag := "Conn(latency/bandwidth)"
+
+	latency := 30 * time.Millisecond
+	a, b := NewConnPair(ConnConfig{Latency: latency, Bandwidth: 1000})
+	defer a.Close()
+	defer b.Close()
+
+	// 20 bytes at 1000 bytes/s take 20ms, plus latency
+	start := time.Now()
+	n, err := a.Write(make([]byte, 20))
+	if err != nil || n != 20 {
+		t.Fatalf(tag+" unexpected write result %v %v", n, errOrNilStr(err))
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf(tag+" write not slowed down by bandwidth, took %v", d)
+	}
+
+	rbuff := make([]byte, 20)
+	_, err = io.ReadFull(b, rbuff)
+	if err != nil {
+		t.Fatalf(tag+" unexpected read error: %v", err.Error())
+	}
+	if d := time.Since(start); d < latency+20*time.Millisecond {
+		t.Fatalf(tag+" data arrived too early, after %v", d)
+	}
+}
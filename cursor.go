@@ -0,0 +1,218 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// an independent read position over a ByteBuffer, cursors share data with the
+// buffer and never copy it
+// NOTE:
+//	- any number of cursors may walk the same buffer from different goroutines
+//		provided the buffer is frozen, i.e. nothing writes to it meanwhile
+//	- cursors don't move the buffer position and are not moved by it
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//	io.ByteReader
+type Cursor struct {
+	b   *ByteBuffer
+	pos int
+}
+
+// returns a new Cursor over this buffer, cursor position is set to ZERO
+func (m *ByteBuffer) NewCursor() *Cursor {
+	return &Cursor{b: m}
+}
+
+// returns the buffer this cursor walks
+func (m *Cursor) Buffer() *ByteBuffer {
+	return m.b
+}
+
+// returns cursor position
+func (m *Cursor) Pos() int {
+	return m.pos
+}
+
+// returns the number of bytes in between cursor position and end of buffer
+func (m *Cursor) Len() int {
+	return max_int(len(m.b.buff)-m.pos, 0)
+}
+
+// @Cursor.Seek(offset, io.SeekStart)
+func (m *Cursor) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @Cursor.Seek(offset, io.SeekCurrent)
+func (m *Cursor) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @Cursor.Seek(offset, io.SeekEnd)
+func (m *Cursor) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @Cursor.Seek(0, io.SeekStart)
+func (m *Cursor) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @Cursor.Seek(0, io.SeekEnd)
+func (m *Cursor) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation, same rules as ByteBuffer.Seek
+// returns offset position if err == nil
+// errors:
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *Cursor) Seek(offset int64, whence int) (int64, error) {
+	m.b.checkReleased()
+
+	pos, err := seek_abs(offset, whence, m.pos, len(m.b.buff))
+	if err != nil {
+		return -1, err
+	}
+
+	// check for overflow
+	if m.b.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+
+	m.pos = pos
+
+	return int64(pos), nil
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+// NOTE:
+//	- will return io.EOF error if the number of bytes read is less than the
+//		size of p, however, p will contain the first n bytes from buffer
+func (m *Cursor) Read(p []byte) (n int, err error) {
+	m.b.checkReleased()
+
+	n, err = m.b.readFromPos(p, m.pos, false)
+	if n <= 0 {
+		// ByteBuffer reports -1 at the end, io.Reader wants ZERO
+		return 0, err
+	}
+	m.pos += n
+	return n, err
+}
+
+// io.ReaderAt implementation, @ByteBuffer.ReadAt
+// NOTE:
+//	- ReadAt will NOT modify cursor position
+func (m *Cursor) ReadAt(p []byte, off int64) (n int, err error) {
+	return m.b.ReadAt(p, off)
+}
+
+// io.ByteReader implementation
+func (m *Cursor) ReadByte() (byte, error) {
+	m.b.checkReleased()
+
+	if m.b.posOverflow(m.pos) {
+		return 0, io.EOF
+	}
+	c := m.b.buff[m.pos]
+	m.pos++
+	return c, nil
+}
+
+// @ByteBuffer.ReadUInt64Var, on error the cursor position is left unchanged
+func (m *Cursor) ReadUInt64Var() (uint64, error) {
+	m.b.checkReleased()
+
+	x, n, err := peek_uvarint(m.b.buff, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	return x, nil
+}
+
+// reads n bytes from cursor position, all-or-nothing
+func (m *Cursor) readFixed(n int) ([]byte, error) {
+	m.b.checkReleased()
+
+	p, err := peek_fixed(m.b.buff, m.pos, n)
+	if err != nil {
+		return nil, err
+	}
+	m.pos += n
+	return p, nil
+}
+
+// @ByteBuffer.ReadUInt16, on error the cursor position is left unchanged
+func (m *Cursor) ReadUInt16(order binary.ByteOrder) (uint16, error) {
+	p, err := m.readFixed(2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint16(p), nil
+}
+
+// @ByteBuffer.ReadUInt32, on error the cursor position is left unchanged
+func (m *Cursor) ReadUInt32(order binary.ByteOrder) (uint32, error) {
+	p, err := m.readFixed(4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint32(p), nil
+}
+
+// @ByteBuffer.ReadUInt64, on error the cursor position is left unchanged
+func (m *Cursor) ReadUInt64(order binary.ByteOrder) (uint64, error) {
+	p, err := m.readFixed(8)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint64(p), nil
+}
+
+// @ByteBuffer.ReadBytesVar, on error the cursor position is left unchanged
+func (m *Cursor) ReadBytesVar() ([]byte, error) {
+	m.b.checkReleased()
+
+	p, n, err := peek_prefixed(m.b.buff, m.pos)
+	if err != nil {
+		return nil, err
+	}
+	m.pos += n
+	return append([]byte{}, p...), nil
+}
@@ -0,0 +1,148 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestCursorIndependent(t *testing.T) {
+	tag := "Cursor(independent)"
+
+	b := NewByteBuffer(0)
+	b.Write([]byte("abracadabra"))
+	b.SeekFromStart(4)
+
+	c1 := b.NewCursor()
+	c2 := b.NewCursor()
+	c2.SeekFromEnd(-4)
+
+	rbuff := make([]byte, 4)
+	n, err := c1.Read(rbuff)
+	if err != nil || string(rbuff[:n]) != "abra" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+	n, err = c2.Read(rbuff)
+	if err != nil || string(rbuff[:n]) != "abra" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+
+	if b.Pos() != 4 || c1.Pos() != 4 || c2.Pos() != 11 {
+		t.Fatalf(tag+" unexpected positions %v/%v/%v", b.Pos(), c1.Pos(), c2.Pos())
+	}
+	if c1.Len() != 7 || c2.Len() != 0 {
+		t.Fatalf(tag+" unexpected lengths %v/%v", c1.Len(), c2.Len())
+	}
+
+	// unlike ByteBuffer, ZERO bytes at the end, io.ReadAll depends on it
+	n, err = c2.Read(rbuff)
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+	data, err := io.ReadAll(b.NewCursor())
+	if err != nil || string(data) != "abracadabra" {
+		t.Fatalf(tag+" unexpected io.ReadAll result [%v] %v", string(data), errOrNilStr(err))
+	}
+
+	_, err = c2.ReadByte()
+	if err != io.EOF {
+		t.Fatalf(tag+" expected io.EOF, found %v", errOrNilStr(err))
+	}
+	_, err = c2.SeekFromCurrent(-20)
+	if err != ErrSeekNegative {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrSeekNegative.Error(), errOrNilStr(err))
+	}
+
+	x, err := c1.ReadByte()
+	if err != nil || x != 'c' {
+		t.Fatalf(tag+" unexpected read byte %v %v", x, errOrNilStr(err))
+	}
+}
+
+// meant to run with -race
+func TestCursorConcurrent(t *testing.T) {
+	tag := "Cursor(concurrent)"
+
+	b := NewByteBuffer(0)
+	vtimes := 63
+	v := uint64(1)
+	for i := 0; i < vtimes; i++ {
+		b.WriteUInt64Var(v)
+		v *= 2
+	}
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := b.NewCursor()
+			v := uint64(1)
+			for i := 0; i < vtimes; i++ {
+				x, err := c.ReadUInt64Var()
+				if err != nil || x != v {
+					t.Errorf(tag+" unexpected read value @%v expected %v, found %v %v", i, v, x, errOrNilStr(err))
+					return
+				}
+				v *= 2
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCursorAtomicDecode(t *testing.T) {
+	tag := "Cursor(atomic decode)"
+
+	b := NewByteBuffer(0)
+	b.WriteUInt16(513, binary.LittleEndian)
+	b.Write([]byte{0x80, 0x80})
+	c := b.NewCursor()
+
+	x, err := c.ReadUInt16(binary.LittleEndian)
+	if err != nil || x != 513 {
+		t.Fatalf(tag+" unexpected uint16 %v, error [%v]", x, errOrNilStr(err))
+	}
+	_, err = c.ReadUInt64Var()
+	if err != io.ErrUnexpectedEOF || c.Pos() != 2 {
+		t.Fatalf(tag+" unexpected error [%v], position %v", errOrNilStr(err), c.Pos())
+	}
+	_, err = c.ReadBytesVar()
+	if err != io.ErrUnexpectedEOF || c.Pos() != 2 {
+		t.Fatalf(tag+" unexpected error [%v], position %v", errOrNilStr(err), c.Pos())
+	}
+
+	// the varint completes once more data arrives
+	b.WriteByte(0x01)
+	v, err := c.ReadUInt64Var()
+	if err != nil || v != 1<<14 {
+		t.Fatalf(tag+" unexpected varint %v, error [%v]", v, errOrNilStr(err))
+	}
+}
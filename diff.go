@@ -0,0 +1,244 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// returned when a delta is malformed or truncated
+var ErrDeltaCorrupt = errors.New("Corrupt delta")
+
+// returned when a delta is applied to a base other than the one it was made from
+var ErrDeltaBase = errors.New("Delta base mismatch")
+
+// returned when the patched result does not match the checksum in the delta
+var ErrDeltaChecksum = errors.New("Delta checksum mismatch")
+
+// magic bytes at the start of every delta
+const KDELTA_MAGIC = "MBD1"
+
+// size of the blocks Diff looks up in the base, matches shorter than this
+// are sent as inserts
+const KDIFF_BLOCK_SIZE = 16
+
+// delta opcodes
+const (
+	// uvarint offset in base, uvarint length
+	KDELTA_COPY = 1
+	// uvarint length followed by the literal bytes
+	KDELTA_INSERT = 2
+)
+
+// multiplier of the rolling hash
+const kdiffPrime = 16777619
+
+// returns an edit script turning a into b, see Patch
+// format, integers are uvarints unless noted:
+//	"MBD1"
+//	size of a, size of b, crc32 (IEEE) of b as a big endian uint32
+//	ops until the end, each one opcode byte followed by
+//		KDELTA_COPY		offset in a, length
+//		KDELTA_INSERT	length, bytes
+// NOTE:
+//	- a and b are left untouched, positions included
+//	- blocks of a are found in b with a rolling hash, so moved and repeated
+//		data is copied rather than inserted
+func Diff(a, b *ByteBuffer) *ByteBuffer {
+	a.checkReleased()
+	b.checkReleased()
+
+	src, dst := a.buff, b.buff
+
+	d := NewByteBuffer(0)
+	d.Write([]byte(KDELTA_MAGIC))
+	d.WriteUInt64Var(uint64(len(src)))
+	d.WriteUInt64Var(uint64(len(dst)))
+	d.WriteUInt32(crc32.ChecksumIEEE(dst), binary.BigEndian)
+
+	// index block aligned offsets of src, first one wins
+	index := map[uint32]int{}
+	for off := 0; off+KDIFF_BLOCK_SIZE <= len(src); off += KDIFF_BLOCK_SIZE {
+		h := diff_hash(src[off : off+KDIFF_BLOCK_SIZE])
+		if _, ok := index[h]; !ok {
+			index[h] = off
+		}
+	}
+
+	// highest power of the rolling hash, drops the leaving byte
+	pow := uint32(1)
+	for i := 0; i < KDIFF_BLOCK_SIZE-1; i++ {
+		pow *= kdiffPrime
+	}
+
+	lit := 0 // start of pending literal bytes in dst
+	i := 0
+	var h uint32
+	if len(dst) >= KDIFF_BLOCK_SIZE {
+		h = diff_hash(dst[:KDIFF_BLOCK_SIZE])
+	}
+	for i+KDIFF_BLOCK_SIZE <= len(dst) {
+		off, ok := index[h]
+		if ok && bytes.Equal(src[off:off+KDIFF_BLOCK_SIZE], dst[i:i+KDIFF_BLOCK_SIZE]) {
+			// extend the match backwards over pending literals, then forwards
+			s, t := off, i
+			for s > 0 && t > lit && src[s-1] == dst[t-1] {
+				s--
+				t--
+			}
+			e := i + KDIFF_BLOCK_SIZE
+			for off+(e-i) < len(src) && e < len(dst) && src[off+(e-i)] == dst[e] {
+				e++
+			}
+
+			diff_insert(d, dst[lit:t])
+			d.WriteByte(KDELTA_COPY)
+			d.WriteUInt64Var(uint64(s))
+			d.WriteUInt64Var(uint64(e - t))
+
+			i, lit = e, e
+			if i+KDIFF_BLOCK_SIZE <= len(dst) {
+				h = diff_hash(dst[i : i+KDIFF_BLOCK_SIZE])
+			}
+			continue
+		}
+
+		// roll the hash one byte forward
+		if i+KDIFF_BLOCK_SIZE < len(dst) {
+			h = (h-uint32(dst[i])*pow)*kdiffPrime + uint32(dst[i+KDIFF_BLOCK_SIZE])
+		}
+		i++
+	}
+	diff_insert(d, dst[lit:])
+
+	d.pos = 0
+	return d
+}
+
+// polynomial hash of p, rolled by Diff
+func diff_hash(p []byte) uint32 {
+	var h uint32
+	for _, c := range p {
+		h = h*kdiffPrime + uint32(c)
+	}
+	return h
+}
+
+// appends an insert op for p, if any
+func diff_insert(d *ByteBuffer, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	d.WriteByte(KDELTA_INSERT)
+	d.WriteBytesVar(p)
+}
+
+// rebuilds the target of delta out of base and returns it, see Diff
+// errors:
+//	ErrDeltaCorrupt
+//	ErrDeltaBase, base has a different size than the one delta was made from
+//	ErrDeltaChecksum, the result differs from the target of delta
+// NOTE:
+//	- base and delta are left untouched, positions included
+func Patch(base, delta *ByteBuffer) (*ByteBuffer, error) {
+	base.checkReleased()
+
+	// walk delta with a cursor, delta position belongs to the caller
+	c := delta.NewCursor()
+
+	magic := make([]byte, len(KDELTA_MAGIC))
+	n, _ := c.Read(magic)
+	if n != len(magic) || string(magic) != KDELTA_MAGIC {
+		return nil, ErrDeltaCorrupt
+	}
+	srcSize, err := c.ReadUInt64Var()
+	if err != nil {
+		return nil, ErrDeltaCorrupt
+	}
+	dstSize, err := c.ReadUInt64Var()
+	if err != nil {
+		return nil, ErrDeltaCorrupt
+	}
+	sum, err := c.ReadUInt32(binary.BigEndian)
+	if err != nil {
+		return nil, ErrDeltaCorrupt
+	}
+	if srcSize != uint64(len(base.buff)) {
+		return nil, ErrDeltaBase
+	}
+
+	// dstSize is not trusted for the allocation, the ops are
+	src := base.buff
+	var out []byte
+	for {
+		op, err := c.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		switch op {
+		case KDELTA_COPY:
+			off, err := c.ReadUInt64Var()
+			if err != nil {
+				return nil, ErrDeltaCorrupt
+			}
+			l, err := c.ReadUInt64Var()
+			if err != nil {
+				return nil, ErrDeltaCorrupt
+			}
+			if off > uint64(len(src)) || l > uint64(len(src))-off {
+				return nil, ErrDeltaCorrupt
+			}
+			out = append(out, src[off:off+l]...)
+		case KDELTA_INSERT:
+			p, err := c.ReadBytesVar()
+			if err != nil {
+				return nil, ErrDeltaCorrupt
+			}
+			out = append(out, p...)
+		default:
+			return nil, ErrDeltaCorrupt
+		}
+		if uint64(len(out)) > dstSize {
+			return nil, ErrDeltaCorrupt
+		}
+	}
+
+	if uint64(len(out)) != dstSize {
+		return nil, ErrDeltaCorrupt
+	}
+	if crc32.ChecksumIEEE(out) != sum {
+		return nil, ErrDeltaChecksum
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return &ByteBuffer{buff: out}, nil
+}
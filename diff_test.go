@@ -0,0 +1,175 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func newDiffBuffer(p []byte) *ByteBuffer {
+	b := NewByteBuffer(0)
+	b.Write(p)
+	return b
+}
+
+// applies a few random edits to a copy of p
+func diffMutate(r *rand.Rand, p []byte) []byte {
+	out := append([]byte{}, p...)
+	edits := r.Intn(8)
+	for i := 0; i < edits; i++ {
+		at := 0
+		if len(out) > 0 {
+			at = r.Intn(len(out))
+		}
+		switch r.Intn(4) {
+		case 0:
+			// insert random bytes
+			ins := make([]byte, r.Intn(64))
+			r.Read(ins)
+			out = append(out[:at], append(ins, out[at:]...)...)
+		case 1:
+			// delete a range
+			end := min_int(len(out), at+r.Intn(128))
+			out = append(out[:at], out[end:]...)
+		case 2:
+			// overwrite a byte
+			if len(out) > 0 {
+				out[at] ^= 0xff
+			}
+		case 3:
+			// duplicate a range somewhere else
+			end := min_int(len(out), at+r.Intn(256))
+			dup := append([]byte{}, out[at:end]...)
+			to := r.Intn(len(out) + 1)
+			out = append(out[:to], append(dup, out[to:]...)...)
+		}
+	}
+	return out
+}
+
+func TestDiffPatchRoundTrip(t *testing.T) {
+	tag := "Diff/Patch(round trip)"
+
+	r := rand.New(rand.NewSource(45))
+	for i := 0; i < 300; i++ {
+		src := make([]byte, r.Intn(8192))
+		r.Read(src)
+		dst := diffMutate(r, src)
+		if i%10 == 0 {
+			// unrelated target
+			dst = make([]byte, r.Intn(512))
+			r.Read(dst)
+		}
+
+		a := newDiffBuffer(src)
+		b := newDiffBuffer(dst)
+		a.SeekFromStart(0)
+		d := Diff(a, b)
+		if a.CmpWith(newDiffBuffer(src)) != 0 || b.CmpWith(newDiffBuffer(dst)) != 0 {
+			t.Fatalf(tag+" Diff modified its input @%v", i)
+		}
+
+		// the delta survives serialization
+		d = newDiffBuffer(d.Bytes())
+		pos := d.Pos()
+		res, err := Patch(a, d)
+		if err != nil {
+			t.Fatalf(tag+" unexpected patch error @%v: %v", i, err)
+		}
+		if !bytes.Equal(res.Bytes(), dst) {
+			t.Fatalf(tag+" patched result differs from target @%v", i)
+		}
+		if d.Pos() != pos {
+			t.Fatalf(tag+" Patch moved delta position @%v", i)
+		}
+	}
+}
+
+func TestDiffCompact(t *testing.T) {
+	tag := "Diff(compact)"
+
+	r := rand.New(rand.NewSource(1))
+	src := make([]byte, 64*1024)
+	r.Read(src)
+	dst := append([]byte{}, src...)
+	copy(dst[1000:], "a small edit in the middle of a large blob")
+	dst = append(dst[:40000], dst[40100:]...)
+
+	d := Diff(newDiffBuffer(src), newDiffBuffer(dst))
+	if d.Size() > 256 {
+		t.Fatalf(tag+" delta too large, %v bytes for a small edit", d.Size())
+	}
+
+	d = Diff(newDiffBuffer(src), newDiffBuffer(src))
+	if d.Size() > 32 {
+		t.Fatalf(tag+" delta too large, %v bytes for identical buffers", d.Size())
+	}
+
+	// empty on either side
+	for _, pair := range [][2][]byte{{nil, src[:100]}, {src[:100], nil}, {nil, nil}} {
+		a, b := newDiffBuffer(pair[0]), newDiffBuffer(pair[1])
+		res, err := Patch(a, Diff(a, b))
+		if err != nil || res.CmpWith(b) != 0 {
+			t.Fatalf(tag+" unexpected empty round trip result, error [%v]", errOrNilStr(err))
+		}
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	tag := "Patch(errors)"
+
+	src := newDiffBuffer([]byte("the quick brown fox jumps over the lazy dog, twice: the quick brown fox"))
+	dst := newDiffBuffer([]byte("the quick brown cat jumps over the lazy dog, twice: the quick brown fox!"))
+	d := Diff(src, dst).Bytes()
+
+	_, err := Patch(newDiffBuffer([]byte("short")), newDiffBuffer(d))
+	if err != ErrDeltaBase {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrDeltaBase, errOrNilStr(err))
+	}
+
+	// same size, different contents
+	other := bytes.Repeat([]byte{'x'}, int(src.Size()))
+	_, err = Patch(newDiffBuffer(other), newDiffBuffer(d))
+	if err != ErrDeltaChecksum {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrDeltaChecksum, errOrNilStr(err))
+	}
+
+	// every truncation is caught
+	for i := 0; i < len(d); i++ {
+		_, err = Patch(src, newDiffBuffer(d[:i]))
+		if err == nil {
+			t.Fatalf(tag+" truncated delta @%v accepted", i)
+		}
+	}
+
+	_, err = Patch(src, newDiffBuffer([]byte("XXXX")))
+	if err != ErrDeltaCorrupt {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrDeltaCorrupt, errOrNilStr(err))
+	}
+}
@@ -0,0 +1,562 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// returned when a chunk fails authentication, it was tampered with, moved or
+// the key is wrong
+var ErrAuthFailed = errors.New("Message authentication failed")
+
+// returned when a serialized EncryptedBuffer is malformed
+var ErrEncryptedCorrupt = errors.New("Corrupt encrypted buffer")
+
+// default plaintext size of a chunk
+const KENCRYPTED_CHUNK_SIZE = 4096
+
+// magic bytes at the start of a serialized EncryptedBuffer
+const KENCRYPTED_MAGIC = "MBEB"
+
+// size of the random buffer id bound into every chunk
+const KENCRYPTED_ID_SIZE = 16
+
+// serialization format version
+const kencryptedVersion = 1
+
+type CipherAlg int
+
+const (
+	// any cipher.AEAD handed to NewEncryptedBufferAEAD, e.g.
+	// golang.org/x/crypto/chacha20poly1305 which the standard library doesn't
+	// export
+	KCIPHER_AEAD CipherAlg = iota
+	// crypto/aes with crypto/cipher GCM, 128, 192 or 256 bit keys
+	KCIPHER_AES_GCM
+)
+
+// a byte buffer kept encrypted in memory, content is split into chunks of a
+// fixed size, each one sealed on its own with a fresh random nonce, so reads
+// and writes only decrypt the chunks they touch
+// every chunk is bound to the buffer id, its index and whether it is the last
+// one, so chunks moved around, dropped from the end or taken from another
+// buffer fail authentication
+// NOTE:
+//	- plaintext only exists transiently and is zeroed after use, except what
+//		is handed out by reads
+//	- a chunk is sealed again with a new nonce on every write, random 96 bit
+//		nonces are fine for up to 2^32 writes per key
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//  io.WriterTo
+//	io.ByteReader
+//	io.ByteWriter
+type EncryptedBuffer struct {
+	aead      cipher.AEAD
+	alg       CipherAlg
+	chunkSize int
+	id        [KENCRYPTED_ID_SIZE]byte
+	// nonce followed by the sealed chunk, never empty, an empty buffer has one
+	// empty final chunk
+	chunks [][]byte
+	size   int
+	pos    int
+}
+
+// returns an AES-GCM cipher.AEAD for key
+func aes_gcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// creates a new empty EncryptedBuffer using AES-GCM with key, chunks hold
+// (chunkSize) bytes
+// errors:
+//	aes.KeySizeError, key must be 16, 24 or 32 bytes
+// NOTE:
+//	- passing ZERO for chunkSize selects KENCRYPTED_CHUNK_SIZE
+func NewEncryptedBuffer(key []byte, chunkSize uint) (*EncryptedBuffer, error) {
+	aead, err := aes_gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedBuffer(aead, KCIPHER_AES_GCM, chunkSize)
+}
+
+// @NewEncryptedBuffer with any AEAD, e.g. ChaCha20-Poly1305 from
+// golang.org/x/crypto
+// NOTE:
+//	- aead nonces should be at least 96 bits, they are picked at random
+func NewEncryptedBufferAEAD(aead cipher.AEAD, chunkSize uint) (*EncryptedBuffer, error) {
+	return newEncryptedBuffer(aead, KCIPHER_AEAD, chunkSize)
+}
+
+func newEncryptedBuffer(aead cipher.AEAD, alg CipherAlg, chunkSize uint) (*EncryptedBuffer, error) {
+	if chunkSize == 0 {
+		chunkSize = KENCRYPTED_CHUNK_SIZE
+	}
+	m := &EncryptedBuffer{
+		aead:      aead,
+		alg:       alg,
+		chunkSize: int(chunkSize),
+		chunks:    make([][]byte, 1),
+	}
+	if _, err := rand.Read(m.id[:]); err != nil {
+		return nil, err
+	}
+	c, err := m.seal(0, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	m.chunks[0] = c
+	return m, nil
+}
+
+// reads an EncryptedBuffer serialized by WriteTo, decrypting with AES-GCM
+// errors:
+//	aes.KeySizeError
+//	ErrEncryptedCorrupt
+//	ErrAuthFailed, wrong key, or the data was truncated or tampered with
+//	errors returned by r
+// NOTE:
+//	- only the last chunk is authenticated here, others are when first read
+func OpenEncryptedBuffer(r io.Reader, key []byte) (*EncryptedBuffer, error) {
+	aead, err := aes_gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	return openEncryptedBuffer(r, aead, KCIPHER_AES_GCM)
+}
+
+// @OpenEncryptedBuffer with the AEAD the buffer was created with
+func OpenEncryptedBufferAEAD(r io.Reader, aead cipher.AEAD) (*EncryptedBuffer, error) {
+	return openEncryptedBuffer(r, aead, KCIPHER_AEAD)
+}
+
+func openEncryptedBuffer(r io.Reader, aead cipher.AEAD, alg CipherAlg) (*EncryptedBuffer, error) {
+	header := make([]byte, len(KENCRYPTED_MAGIC)+2+4+KENCRYPTED_ID_SIZE+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrEncryptedCorrupt
+	}
+	h := header[len(KENCRYPTED_MAGIC):]
+	if string(header[:len(KENCRYPTED_MAGIC)]) != KENCRYPTED_MAGIC || h[0] != kencryptedVersion || CipherAlg(h[1]) != alg {
+		return nil, ErrEncryptedCorrupt
+	}
+	m := &EncryptedBuffer{
+		aead:      aead,
+		alg:       alg,
+		chunkSize: int(binary.BigEndian.Uint32(h[2:])),
+	}
+	copy(m.id[:], h[6:])
+	count := binary.BigEndian.Uint64(h[6+KENCRYPTED_ID_SIZE:])
+	if m.chunkSize == 0 || count == 0 {
+		return nil, ErrEncryptedCorrupt
+	}
+
+	// full chunks have one exact size, only the last may be shorter
+	full := aead.NonceSize() + m.chunkSize + aead.Overhead()
+	for i := uint64(0); i < count; i++ {
+		var l [4]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return nil, ErrEncryptedCorrupt
+		}
+		n := int(binary.BigEndian.Uint32(l[:]))
+		last := i == count-1
+		if n > full || n < aead.NonceSize()+aead.Overhead() || (!last && n != full) {
+			return nil, ErrEncryptedCorrupt
+		}
+		c := make([]byte, n)
+		if _, err := io.ReadFull(r, c); err != nil {
+			return nil, ErrEncryptedCorrupt
+		}
+		m.chunks = append(m.chunks, c)
+	}
+
+	// the last chunk must have been sealed as the last one, or chunks were
+	// dropped from the end
+	plain, err := m.open(len(m.chunks) - 1)
+	if err != nil {
+		return nil, err
+	}
+	m.size = (len(m.chunks)-1)*m.chunkSize + len(plain)
+	zero_bytes(plain)
+	return m, nil
+}
+
+// returns the additional authenticated data of chunk (i)
+func (m *EncryptedBuffer) aad(i int, final bool) []byte {
+	p := make([]byte, KENCRYPTED_ID_SIZE+4+8+1)
+	copy(p, m.id[:])
+	binary.BigEndian.PutUint32(p[KENCRYPTED_ID_SIZE:], uint32(m.chunkSize))
+	binary.BigEndian.PutUint64(p[KENCRYPTED_ID_SIZE+4:], uint64(i))
+	if final {
+		p[len(p)-1] = 1
+	}
+	return p
+}
+
+// returns the decrypted chunk (i), the caller zeroes it when done
+func (m *EncryptedBuffer) open(i int) ([]byte, error) {
+	return m.openChunk(i, i == len(m.chunks)-1)
+}
+
+// @EncryptedBuffer.open, final tells whether chunk (i) was sealed as the last
+// one, writes need this while chunks are being added
+func (m *EncryptedBuffer) openChunk(i int, final bool) ([]byte, error) {
+	c := m.chunks[i]
+	ns := m.aead.NonceSize()
+	plain, err := m.aead.Open(nil, c[:ns], c[ns:], m.aad(i, final))
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	return plain, nil
+}
+
+// encrypts plain as chunk (i) with a fresh nonce, returns the sealed chunk
+func (m *EncryptedBuffer) seal(i int, plain []byte, final bool) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize(), m.aead.NonceSize()+len(plain)+m.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return m.aead.Seal(nonce, nonce, plain, m.aad(i, final)), nil
+}
+
+// returns the number of chunks needed for size bytes
+func (m *EncryptedBuffer) chunksFor(size int) int {
+	return max_int(1, (size+m.chunkSize-1)/m.chunkSize)
+}
+
+// returns size in bytes of the plaintext
+func (m *EncryptedBuffer) Size() uint {
+	return uint(m.size)
+}
+
+// returns internal buffer position
+func (m *EncryptedBuffer) Pos() int {
+	return m.pos
+}
+
+// returns true if the buffer holds no bytes
+func (m *EncryptedBuffer) Empty() bool {
+	return m.size == 0
+}
+
+// returns chunk size
+func (m *EncryptedBuffer) ChunkSize() int {
+	return m.chunkSize
+}
+
+// returns the cipher the buffer was created with
+func (m *EncryptedBuffer) Alg() CipherAlg {
+	return m.alg
+}
+
+// check if p is overflowing buffer
+func (m *EncryptedBuffer) posOverflow(p int) bool {
+	return p >= m.size
+}
+
+// @EncryptedBuffer.Seek(offset, io.SeekStart)
+func (m *EncryptedBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @EncryptedBuffer.Seek(offset, io.SeekCurrent)
+func (m *EncryptedBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @EncryptedBuffer.Seek(offset, io.SeekEnd)
+func (m *EncryptedBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @EncryptedBuffer.Seek(0, io.SeekStart)
+func (m *EncryptedBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @EncryptedBuffer.Seek(0, io.SeekEnd)
+func (m *EncryptedBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation, same rules as ByteBuffer.Seek
+// errors:
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *EncryptedBuffer) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seek_abs(offset, whence, m.pos, m.size)
+	if err != nil {
+		return -1, err
+	}
+	if m.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+	m.pos = pos
+	return int64(pos), nil
+}
+
+func (m *EncryptedBuffer) readFromPos(p []byte, pos int) (n int, err error) {
+	if pos >= m.size {
+		return 0, io.EOF
+	}
+	for n < len(p) && pos < m.size {
+		i := pos / m.chunkSize
+		plain, err := m.open(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], plain[pos-i*m.chunkSize:])
+		zero_bytes(plain)
+		n += c
+		pos += c
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// io.Reader implementation, same rules as ByteBuffer.Read
+// errors:
+//	io.EOF
+//	ErrAuthFailed
+func (m *EncryptedBuffer) Read(p []byte) (n int, err error) {
+	n, err = m.readFromPos(p, m.pos)
+	if n > 0 {
+		m.pos += n
+	}
+	return n, err
+}
+
+// io.ReaderAt implementation, only the chunks in between off and off+len(p)
+// are decrypted
+// errors:
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+//	ErrAuthFailed
+//	io.EOF
+func (m *EncryptedBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+	return m.readFromPos(p, int(off))
+}
+
+// io.ByteReader implementation
+func (m *EncryptedBuffer) ReadByte() (byte, error) {
+	p := make([]byte, 1)
+	n, err := m.Read(p)
+	if err != nil {
+		return 0, err
+	}
+	if n != 1 {
+		return 0, ErrByteRead
+	}
+	return p[0], nil
+}
+
+// all-or-nothing, every chunk the write touches is opened and authenticated
+// before any of them is resealed
+func (m *EncryptedBuffer) writeFromPos(p []byte, pos int) (appended int, written int, err error) {
+	l := len(p)
+	if l == 0 {
+		return 0, 0, nil
+	}
+	end := pos + l
+	size := max_int(m.size, end)
+	oldLast := len(m.chunks) - 1
+	last := m.chunksFor(size) - 1
+	first := pos / m.chunkSize
+
+	// the previous last chunk is resealed as a regular one once chunks are
+	// added past it, even if the write does not touch it
+	if last != oldLast && oldLast < first {
+		first = oldLast
+	}
+
+	// plaintext of chunks first..(end-1)/chunkSize with p applied
+	plains := make([][]byte, 0, (end-1)/m.chunkSize-first+1)
+	defer func() {
+		for _, plain := range plains {
+			zero_bytes(plain)
+		}
+	}()
+	for i := first; i <= (end-1)/m.chunkSize; i++ {
+		start := i * m.chunkSize
+		plain := make([]byte, min_int(m.chunkSize, size-start))
+		plains = append(plains, plain)
+		if i <= oldLast {
+			old, err := m.openChunk(i, i == oldLast)
+			if err != nil {
+				return 0, 0, err
+			}
+			copy(plain, old)
+			zero_bytes(old)
+		}
+		if end > start && pos < start+len(plain) {
+			from := max_int(pos-start, 0)
+			copy(plain[from:], p[start+from-pos:])
+		}
+	}
+
+	sealed := make([][]byte, len(plains))
+	for j, plain := range plains {
+		sealed[j], err = m.seal(first+j, plain, first+j == last)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for len(m.chunks) <= last {
+		m.chunks = append(m.chunks, nil)
+	}
+	copy(m.chunks[first:], sealed)
+
+	appended = size - m.size
+	m.size = size
+	return appended, l, nil
+}
+
+// io.Writer implementation, same rules as ByteBuffer.Write
+// errors:
+//	ErrAuthFailed, a chunk to be partially overwritten failed authentication,
+//		nothing is written
+func (m *EncryptedBuffer) Write(p []byte) (n int, err error) {
+	appended, written, err := m.writeFromPos(p, m.pos)
+	if err != nil {
+		return -1, err
+	}
+	m.pos += appended
+	return written, nil
+}
+
+// io.WriteAt implementation, same rules as ByteBuffer.WriteAt
+// errors:
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+//	ErrAuthFailed
+func (m *EncryptedBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+	appended, written, err := m.writeFromPos(p, int(off))
+	if err != nil {
+		return -1, err
+	}
+	m.pos += appended
+	return written, nil
+}
+
+// io.ByteWriter implementation, always appends
+func (m *EncryptedBuffer) WriteByte(c byte) error {
+	_, _, err := m.writeFromPos([]byte{c}, m.size)
+	if err != nil {
+		return err
+	}
+	m.pos = m.size
+	return nil
+}
+
+// returns the decrypted contents in a new ByteBuffer
+// errors:
+//	ErrAuthFailed
+func (m *EncryptedBuffer) ByteBuffer() (*ByteBuffer, error) {
+	r := NewByteBuffer(uint(m.size))
+	if m.size == 0 {
+		return r, nil
+	}
+	if _, err := m.readFromPos(r.buff, 0); err != nil {
+		r.Reset(0)
+		return nil, err
+	}
+	return r, nil
+}
+
+// io.WriterTo implementation, serializes the buffer as it is in memory, no
+// plaintext is involved, see OpenEncryptedBuffer
+// format, integers are big endian:
+//	"MBEB", version byte, CipherAlg byte
+//	chunk size uint32
+//	buffer id, KENCRYPTED_ID_SIZE random bytes
+//	chunk count uint64, at least one
+//	per chunk:
+//		length uint32
+//		nonce, then the sealed chunk including the AEAD tag
+// each chunk is sealed with additional data:
+//	buffer id, chunk size uint32, chunk index uint64, 1 for the last chunk
+//		and 0 otherwise as a byte
+func (m *EncryptedBuffer) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 0, len(KENCRYPTED_MAGIC)+2+4+KENCRYPTED_ID_SIZE+8)
+	header = append(header, KENCRYPTED_MAGIC...)
+	header = append(header, kencryptedVersion, byte(m.alg))
+	header = binary.BigEndian.AppendUint32(header, uint32(m.chunkSize))
+	header = append(header, m.id[:]...)
+	header = binary.BigEndian.AppendUint64(header, uint64(len(m.chunks)))
+
+	n, err := w.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	for _, c := range m.chunks {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(c)))
+		n, err = w.Write(l[:])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		n, err = w.Write(c)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
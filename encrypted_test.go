@@ -0,0 +1,276 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var encryptedKey = []byte("0123456789abcdef0123456789abcdef")
+
+// serializes b
+func encryptedBytes(b *EncryptedBuffer) []byte {
+	var out bytes.Buffer
+	b.WriteTo(&out)
+	return out.Bytes()
+}
+
+// offset of chunk (i) in serialized data, its length prefix included
+func encryptedChunkOff(raw []byte, i int) int {
+	off := len(KENCRYPTED_MAGIC) + 2 + 4 + KENCRYPTED_ID_SIZE + 8
+	for ; i > 0; i-- {
+		off += 4 + int(binary.BigEndian.Uint32(raw[off:]))
+	}
+	return off
+}
+
+func TestNewEncryptedBuffer(t *testing.T) {
+	tag := "NewEncryptedBuffer()"
+
+	_, err := NewEncryptedBuffer([]byte("short"), 0)
+	if err == nil {
+		t.Fatal(tag + " invalid key accepted")
+	}
+	b, err := NewEncryptedBuffer(encryptedKey, 0)
+	if err != nil || !b.Empty() || b.ChunkSize() != KENCRYPTED_CHUNK_SIZE || b.Alg() != KCIPHER_AES_GCM {
+		t.Fatalf(tag+" unexpected buffer, error [%v]", errOrNilStr(err))
+	}
+	if bytes.Contains(encryptedBytes(b), encryptedKey) {
+		t.Fatal(tag + " key leaked into serialized data")
+	}
+}
+
+func TestEncryptedBufferRandomAccess(t *testing.T) {
+	tag := "EncryptedBuffer.ReadAt/WriteAt()"
+
+	r := rand.New(rand.NewSource(49))
+	b, _ := NewEncryptedBuffer(encryptedKey, 64)
+	var ref []byte
+
+	for i := 0; i < 500; i++ {
+		p := make([]byte, r.Intn(200))
+		r.Read(p)
+		switch {
+		case len(ref) == 0 || r.Intn(3) == 0:
+			// append
+			for _, c := range p {
+				b.WriteByte(c)
+			}
+			ref = append(ref, p...)
+		default:
+			// overwrite, may grow
+			off := r.Intn(len(ref))
+			n, err := b.WriteAt(p, int64(off))
+			if err != nil || n != len(p) {
+				t.Fatalf(tag+" unexpected write %v, error [%v]", n, errOrNilStr(err))
+			}
+			if off+len(p) > len(ref) {
+				ref = append(ref, make([]byte, off+len(p)-len(ref))...)
+			}
+			copy(ref[off:], p)
+		}
+		if b.Size() != uint(len(ref)) {
+			t.Fatalf(tag+" unexpected size @%v, expected %v, found %v", i, len(ref), b.Size())
+		}
+		if len(ref) == 0 {
+			continue
+		}
+
+		off := r.Intn(len(ref))
+		q := make([]byte, r.Intn(300))
+		n, err := b.ReadAt(q, int64(off))
+		if n != min_int(len(q), len(ref)-off) || !bytes.Equal(q[:n], ref[off:off+n]) {
+			t.Fatalf(tag+" unexpected read @%v, %v bytes, error [%v]", i, n, errOrNilStr(err))
+		}
+	}
+
+	// plaintext never shows up in memory
+	raw := encryptedBytes(b)
+	if bytes.Contains(raw, ref[:32]) {
+		t.Fatal(tag + " plaintext found in serialized data")
+	}
+
+	b.SeekToStart()
+	got, err := io.ReadAll(b)
+	if err != nil || !bytes.Equal(got, ref) {
+		t.Fatalf(tag+" unexpected sequential read, error [%v]", errOrNilStr(err))
+	}
+	n, err := b.Read(make([]byte, 4))
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+}
+
+func TestEncryptedBufferSerialize(t *testing.T) {
+	tag := "EncryptedBuffer.WriteTo/OpenEncryptedBuffer()"
+
+	data := bytes.Repeat([]byte("secret PII record;"), 50)
+	for _, size := range []int{0, 1, 64, 65, len(data)} {
+		b, _ := NewEncryptedBuffer(encryptedKey, 64)
+		if _, err := b.Write(data[:size]); err != nil {
+			t.Fatalf(tag+" unexpected write error @%v: %v", size, err)
+		}
+
+		raw := encryptedBytes(b)
+		o, err := OpenEncryptedBuffer(bytes.NewReader(raw), encryptedKey)
+		if err != nil {
+			t.Fatalf(tag+" unexpected open error @%v: %v", size, err)
+		}
+		bb, err := o.ByteBuffer()
+		if err != nil || !bytes.Equal(bb.Bytes(), data[:size]) {
+			t.Fatalf(tag+" unexpected contents @%v, error [%v]", size, errOrNilStr(err))
+		}
+
+		// still writable once reopened
+		o.WriteByte('!')
+		o, err = OpenEncryptedBuffer(bytes.NewReader(encryptedBytes(o)), encryptedKey)
+		if err != nil || o.Size() != uint(size+1) {
+			t.Fatalf(tag+" unexpected reopen @%v, error [%v]", size, errOrNilStr(err))
+		}
+	}
+
+	// any AEAD, here AES-GCM again, can't be opened as the built in cipher
+	aead, _ := aes_gcm(encryptedKey)
+	b, _ := NewEncryptedBufferAEAD(aead, 0)
+	b.Write(data)
+	raw := encryptedBytes(b)
+	if _, err := OpenEncryptedBuffer(bytes.NewReader(raw), encryptedKey); err != ErrEncryptedCorrupt {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrEncryptedCorrupt, errOrNilStr(err))
+	}
+	o, err := OpenEncryptedBufferAEAD(bytes.NewReader(raw), aead)
+	if err != nil || o.Size() != uint(len(data)) {
+		t.Fatalf(tag+" unexpected AEAD open, error [%v]", errOrNilStr(err))
+	}
+}
+
+func TestEncryptedBufferTamper(t *testing.T) {
+	tag := "EncryptedBuffer(tamper)"
+
+	b, _ := NewEncryptedBuffer(encryptedKey, 16)
+	b.Write(bytes.Repeat([]byte("0123456789abcdef"), 4))
+	raw := encryptedBytes(b)
+	open := func(raw []byte) (*EncryptedBuffer, error) {
+		return OpenEncryptedBuffer(bytes.NewReader(raw), encryptedKey)
+	}
+	readAll := func(o *EncryptedBuffer) error {
+		_, err := o.ByteBuffer()
+		return err
+	}
+
+	// wrong key
+	other := append([]byte{}, encryptedKey...)
+	other[0] ^= 1
+	if _, err := OpenEncryptedBuffer(bytes.NewReader(raw), other); err != ErrAuthFailed {
+		t.Fatalf(tag+" wrong key, expected [%v], found [%v]", ErrAuthFailed, errOrNilStr(err))
+	}
+
+	// flipped ciphertext bit, caught on read
+	bad := append([]byte{}, raw...)
+	bad[encryptedChunkOff(raw, 1)+20] ^= 1
+	o, err := open(bad)
+	if err != nil {
+		t.Fatalf(tag+" unexpected open error: %v", err)
+	}
+	if _, err := o.ReadAt(make([]byte, 4), 16); err != ErrAuthFailed {
+		t.Fatalf(tag+" flipped bit, expected [%v], found [%v]", ErrAuthFailed, errOrNilStr(err))
+	}
+	if _, err := o.ReadAt(make([]byte, 4), 0); err != nil {
+		t.Fatalf(tag+" untouched chunk failed: %v", err)
+	}
+
+	// swapped chunks
+	c1, c2 := encryptedChunkOff(raw, 1), encryptedChunkOff(raw, 2)
+	c3 := encryptedChunkOff(raw, 3)
+	bad = append([]byte{}, raw[:c1]...)
+	bad = append(bad, raw[c2:c3]...)
+	bad = append(bad, raw[c1:c2]...)
+	bad = append(bad, raw[c3:]...)
+	o, _ = open(bad)
+	if err := readAll(o); err != ErrAuthFailed {
+		t.Fatalf(tag+" reordered chunks, expected [%v], found [%v]", ErrAuthFailed, errOrNilStr(err))
+	}
+
+	// last chunk dropped, count fixed up to match
+	bad = append([]byte{}, raw[:c3]...)
+	binary.BigEndian.PutUint64(bad[len(KENCRYPTED_MAGIC)+2+4+KENCRYPTED_ID_SIZE:], 3)
+	if _, err := open(bad); err != ErrAuthFailed {
+		t.Fatalf(tag+" truncated buffer, expected [%v], found [%v]", ErrAuthFailed, errOrNilStr(err))
+	}
+
+	// cut short anywhere else
+	for _, l := range []int{0, 10, c1 + 3, len(raw) - 1} {
+		if _, err := open(raw[:l]); err != ErrEncryptedCorrupt {
+			t.Fatalf(tag+" cut @%v, expected [%v], found [%v]", l, ErrEncryptedCorrupt, errOrNilStr(err))
+		}
+	}
+
+	// chunk taken from another buffer under the same key
+	b2, _ := NewEncryptedBuffer(encryptedKey, 16)
+	b2.Write(bytes.Repeat([]byte("fedcba9876543210"), 4))
+	raw2 := encryptedBytes(b2)
+	bad = append([]byte{}, raw[:c1]...)
+	bad = append(bad, raw2[c1:c2]...)
+	bad = append(bad, raw[c2:]...)
+	o, _ = open(bad)
+	if err := readAll(o); err != ErrAuthFailed {
+		t.Fatalf(tag+" spliced chunk, expected [%v], found [%v]", ErrAuthFailed, errOrNilStr(err))
+	}
+}
+
+func TestEncryptedBufferWriteAtomic(t *testing.T) {
+	tag := "EncryptedBuffer.WriteAt(atomic)"
+
+	b, _ := NewEncryptedBuffer(encryptedKey, 16)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	b.Write(data)
+
+	// the second of the two chunks a write spans fails authentication
+	b.chunks[1][len(b.chunks[1])-1] ^= 1
+	first := append([]byte{}, b.chunks[0]...)
+	_, err := b.WriteAt(bytes.Repeat([]byte("x"), 20), 8)
+	if err != ErrAuthFailed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrAuthFailed, errOrNilStr(err))
+	}
+	if !bytes.Equal(b.chunks[0], first) {
+		t.Fatal(tag + " first chunk resealed by a failed write")
+	}
+	rbuff := make([]byte, 16)
+	if _, err = b.ReadAt(rbuff, 0); err != nil || !bytes.Equal(rbuff, data[:16]) {
+		t.Fatalf(tag+" first chunk modified [%v] %v", string(rbuff), errOrNilStr(err))
+	}
+
+	// same for an append that reseals the old last chunk
+	b.chunks[3][len(b.chunks[3])-1] ^= 1
+	_, err = b.Write([]byte("x"))
+	if err != ErrAuthFailed || b.Size() != uint(len(data)) || len(b.chunks) != 4 {
+		t.Fatalf(tag+" unexpected append, size %v, chunks %v, error [%v]", b.Size(), len(b.chunks), errOrNilStr(err))
+	}
+}
@@ -0,0 +1,187 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// an append-only ByteBuffer meant to be used as an in-process log
+// any number of followers can read it like `tail -f`, each from its own offset
+// safe for use by multiple goroutines
+// implemented interfaces
+//  io.Writer
+//  io.ReaderAt
+//	io.ByteWriter
+//  io.Closer
+type LogBuffer struct {
+	mu     sync.RWMutex
+	b      *ByteBuffer
+	closed bool
+	// closed and replaced on every append, wakes up all followers
+	changed chan struct{}
+}
+
+// a reader following a LogBuffer from a given offset
+// reads block at the end of the log until more data is appended or the log
+// is closed, followers never copy the log
+// implemented interfaces
+//  io.Reader
+type Follower struct {
+	log *LogBuffer
+	pos int
+}
+
+// create a new empty LogBuffer
+func NewLogBuffer() *LogBuffer {
+	return &LogBuffer{
+		b:       NewByteBuffer(0),
+		changed: make(chan struct{}),
+	}
+}
+
+// returns size in bytes of the log
+func (m *LogBuffer) Size() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.Size()
+}
+
+// returns a copy of the log
+func (m *LogBuffer) Bytes() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.Bytes()
+}
+
+// io.Writer implementation, appends p to the log and wakes up followers
+// errors:
+//	ErrClosed
+func (m *LogBuffer) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	m.b.buff = append(m.b.buff, p...)
+	m.b.pos = len(m.b.buff)
+	m.notify()
+	return len(p), nil
+}
+
+// io.ByteWriter implementation
+// errors:
+//	ErrClosed
+func (m *LogBuffer) WriteByte(c byte) error {
+	_, err := m.Write([]byte{c})
+	return err
+}
+
+// @ByteBuffer.ReadAt
+func (m *LogBuffer) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.ReadAt(p, off)
+}
+
+// closes the log for writing, followers get io.EOF once they reach the end
+func (m *LogBuffer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		m.notify()
+	}
+	return nil
+}
+
+// must be called with exclusive lock held
+func (m *LogBuffer) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// returns a new Follower reading from offset off, off may be equal to Size()
+// in order to follow new appends only
+// errors:
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+func (m *LogBuffer) Follow(off int64) (*Follower, error) {
+	if off < 0 {
+		return nil, ErrOffsetNegative
+	}
+	if off > int64(m.Size()) {
+		return nil, ErrOffsetOverflow
+	}
+	return &Follower{log: m, pos: int(off)}, nil
+}
+
+// returns follower position within the log
+func (m *Follower) Pos() int {
+	return m.pos
+}
+
+// @Follower.ReadContext(context.Background(), p)
+func (m *Follower) Read(p []byte) (int, error) {
+	return m.ReadContext(context.Background(), p)
+}
+
+// reads up to len(p) bytes from follower position, blocks at the end of the
+// log until more data is appended, the log is closed or ctx is done
+// errors:
+//	io.EOF, once the log is closed and fully read
+//	ctx.Err()
+func (m *Follower) ReadContext(ctx context.Context, p []byte) (int, error) {
+	for {
+		m.log.mu.RLock()
+		buff := m.log.b.buff
+		closed := m.log.closed
+		ch := m.log.changed
+		m.log.mu.RUnlock()
+
+		// appended bytes never change, buff can be read without the lock
+		if m.pos < len(buff) {
+			n := copy(p, buff[m.pos:])
+			m.pos += n
+			return n, nil
+		}
+		if closed {
+			return 0, io.EOF
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
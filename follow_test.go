@@ -0,0 +1,114 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// meant to run with -race
+func TestLogBufferFollow(t *testing.T) {
+	tag := "LogBuffer.Follow()"
+
+	l := NewLogBuffer()
+	l.Write([]byte("abracadabra"))
+
+	followers := 4
+	results := make([]*ByteBuffer, followers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < followers; i++ {
+		f, err := l.Follow(int64(i))
+		if err != nil {
+			t.Fatalf(tag+" unexpected follow error: %v", err.Error())
+		}
+		results[i] = NewByteBuffer(0)
+		wg.Add(1)
+		go func(f *Follower, dst *ByteBuffer) {
+			defer wg.Done()
+			_, err := io.Copy(dst, f)
+			if err != nil {
+				t.Errorf(tag+" unexpected error in io.Copy: %v", err.Error())
+			}
+		}(f, results[i])
+	}
+
+	// followers are blocked at the end by now, keep appending
+	for i := 0; i < 100; i++ {
+		l.Write([]byte("abracadabra"))
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	l.Close()
+	wg.Wait()
+
+	expected := l.Bytes()
+	for i := 0; i < followers; i++ {
+		if bytes.Compare(expected[i:], results[i].Bytes()) != 0 {
+			t.Fatalf(tag+" follower #%v data mismatch", i)
+		}
+	}
+
+	_, err := l.Write([]byte{1})
+	if err != ErrClosed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrClosed.Error(), errOrNilStr(err))
+	}
+	_, err = l.Follow(int64(len(expected)) + 1)
+	if err != ErrOffsetOverflow {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrOffsetOverflow.Error(), errOrNilStr(err))
+	}
+}
+
+func TestLogBufferFollowContext(t *testing.T) {
+	tag := "Follower.ReadContext()"
+
+	l := NewLogBuffer()
+	f, _ := l.Follow(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := f.ReadContext(ctx, make([]byte, 1))
+	if err != context.DeadlineExceeded {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", context.DeadlineExceeded.Error(), errOrNilStr(err))
+	}
+
+	// following from the end only sees new appends
+	l.Write([]byte("abra"))
+	f, _ = l.Follow(int64(l.Size()))
+	l.Write([]byte("cadabra"))
+	rbuff := make([]byte, 16)
+	n, err := f.Read(rbuff)
+	if err != nil || string(rbuff[:n]) != "cadabra" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+}
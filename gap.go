@@ -0,0 +1,444 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// minimum size of the gap created whenever a GapBuffer needs to grow
+const KGAP_MIN_SIZE = 64
+
+// a byte buffer with a movable gap kept at the last edit position, inserts and
+// deletes near the cursor are O(1) amortized
+// the API mirrors ByteBuffer, Insert and Delete are the editing operations
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//	io.ByteReader
+//	io.ByteWriter
+type GapBuffer struct {
+	buff []byte
+	// gap occupies buff[gapStart:gapEnd]
+	gapStart int
+	gapEnd   int
+	pos      int
+	// named bookmarks, follow Insert and Delete
+	marks bookmarks
+}
+
+// create a new GapBuffer with (size) zero bytes
+// NOTE:
+//	- passing ZERO for size is allowed, the internal buffer grows on demand
+func NewGapBuffer(size uint) *GapBuffer {
+	return (&GapBuffer{}).Reset(size)
+}
+
+// creates a new internal buffer of size (size), position is reset to ZERO
+// NOTE:
+//	- any pre-existing data will be LOST
+func (m *GapBuffer) Reset(size uint) *GapBuffer {
+	m.buff = make([]byte, int(size)+KGAP_MIN_SIZE)
+	m.gapStart = 0
+	m.gapEnd = KGAP_MIN_SIZE
+	m.pos = 0
+	m.marks.clamp(int(size))
+	return m
+}
+
+// @GapBuffer.Reset(0)
+func (m *GapBuffer) Clear() *GapBuffer {
+	return m.Reset(0)
+}
+
+// returns true if there's no data in buffer
+func (m *GapBuffer) Empty() bool {
+	return m.len() == 0
+}
+
+// compare contents of this and other
+func (m *GapBuffer) CmpWith(other *GapBuffer) int {
+	return bytes.Compare(m.Bytes(), other.Bytes())
+}
+
+// returns a new clone of this
+// position in the clone is set to ZERO
+func (m *GapBuffer) Clone() *GapBuffer {
+	r := NewGapBuffer(0)
+	r.Insert(m.Bytes())
+	r.pos = 0
+	return r
+}
+
+// returns size in bytes of the data held in buffer, the gap is not included
+func (m *GapBuffer) Size() uint {
+	return uint(m.len())
+}
+
+// returns internal buffer position
+func (m *GapBuffer) Pos() int {
+	return m.pos
+}
+
+// returns a copy of the logical contents as a byte slice
+func (m *GapBuffer) Bytes() []byte {
+	r := make([]byte, m.len())
+	n := copy(r, m.buff[:m.gapStart])
+	copy(r[n:], m.buff[m.gapEnd:])
+	return r
+}
+
+func (m *GapBuffer) gapLen() int {
+	return m.gapEnd - m.gapStart
+}
+
+func (m *GapBuffer) len() int {
+	return len(m.buff) - m.gapLen()
+}
+
+// check if p is overflowing buffer
+func (m *GapBuffer) posOverflow(p int) bool {
+	return p >= m.len()
+}
+
+// moves the gap so that it starts at logical position pos
+// cost is proportional to the distance between the gap and pos
+func (m *GapBuffer) moveGap(pos int) {
+	if pos < m.gapStart {
+		// shift the bytes in between to the right side of the gap
+		n := m.gapStart - pos
+		copy(m.buff[m.gapEnd-n:m.gapEnd], m.buff[pos:m.gapStart])
+		m.gapStart -= n
+		m.gapEnd -= n
+	} else if pos > m.gapStart {
+		// shift the bytes in between to the left side of the gap
+		n := pos - m.gapStart
+		copy(m.buff[m.gapStart:], m.buff[m.gapEnd:m.gapEnd+n])
+		m.gapStart += n
+		m.gapEnd += n
+	}
+}
+
+// makes sure the gap is able to hold at least n bytes
+func (m *GapBuffer) growGap(n int) {
+	if m.gapLen() >= n {
+		return
+	}
+
+	// double the buffer, but never create a gap smaller than KGAP_MIN_SIZE
+	l := m.len()
+	gap := max_int(max_int(l, n), KGAP_MIN_SIZE)
+	buff := make([]byte, l+gap)
+	copy(buff, m.buff[:m.gapStart])
+	tail := len(m.buff) - m.gapEnd
+	copy(buff[len(buff)-tail:], m.buff[m.gapEnd:])
+
+	m.gapEnd = len(buff) - tail
+	m.buff = buff
+}
+
+// @GapBuffer.Seek(offset, io.SeekStart)
+func (m *GapBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @GapBuffer.Seek(offset, io.SeekCurrent)
+func (m *GapBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @GapBuffer.Seek(offset, io.SeekEnd)
+func (m *GapBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @GapBuffer.Seek(0, io.SeekStart)
+func (m *GapBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @GapBuffer.Seek(0, io.SeekEnd)
+func (m *GapBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation
+// returns offset position if err == nil
+// errors:
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+// NOTE:
+//	- unlike ByteBuffer, seeking right past the last byte is allowed, the
+//		cursor sits in between bytes so that Insert can append
+//	- seeking does NOT move the gap, the gap follows edits only
+func (m *GapBuffer) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seek_abs(offset, whence, m.pos, m.len())
+	if err != nil {
+		return -1, err
+	}
+
+	// check for overflow
+	if pos > m.len() {
+		return -1, ErrSeekOverflow
+	}
+
+	// update position
+	m.pos = pos
+
+	return int64(pos), nil
+}
+
+func (m *GapBuffer) readFromPos(p []byte, pos int, incPos bool) (n int, err error) {
+	l := len(p)
+
+	// number of available bytes to read from position
+	avail := m.len() - pos
+	if avail > 0 {
+		// read the minimum amount of bytes
+		n = min_int(avail, l)
+
+		// copy from the left side of the gap, then from the right side
+		c := 0
+		if pos < m.gapStart {
+			c = copy(p[:n], m.buff[pos:m.gapStart])
+		}
+		if c < n {
+			copy(p[c:n], m.buff[m.gapEnd+pos+c-m.gapStart:])
+		}
+
+		// increment position only if called by Read, ReadAt also calls this function
+		if incPos {
+			m.pos += n
+		}
+
+		// check if we've read less bytes than the size of p
+		if n < l {
+			err = io.EOF
+		}
+		return
+	}
+	return 0, io.EOF
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+// NOTE:
+//	- will return io.EOF error if the number of bytes read is less than the
+//		size of p, however, p will contain the first n bytes from buffer
+func (m *GapBuffer) Read(p []byte) (n int, err error) {
+	return m.readFromPos(p, m.pos, true)
+}
+
+// io.ReaderAt implementation
+// reads up to len(p) from buffer at offset off
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+// NOTE:
+//	- ReadAt will NOT modify internal position
+func (m *GapBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	pos := int(off)
+
+	// sanity checks
+	if pos < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return -1, ErrOffsetOverflow
+	}
+
+	return m.readFromPos(p, pos, false)
+}
+
+// overwrites the bytes following pos and appends the rest, the write is done
+// as a delete followed by an insert at the gap, which leaves the gap right
+// after the written bytes
+func (m *GapBuffer) writeFromPos(p []byte, pos int) (appended int, written int, err error) {
+	l := len(p)
+
+	// number of overlap bytes
+	noverlap := min_int(m.len()-pos, l)
+
+	m.moveGap(pos)
+	// drop the bytes we're about to overwrite
+	m.gapEnd += noverlap
+	m.growGap(l)
+	copy(m.buff[m.gapStart:], p)
+	m.gapStart += l
+
+	return l - noverlap, l, nil
+}
+
+// io.Writer implementation
+// writes p to internal buffer at current position
+// NOTE:
+//	- if current position is within the buffer, some or all of the bytes will be
+//		overwritten, same as ByteBuffer.Write
+//	- use Insert in order to insert bytes at current position
+func (m *GapBuffer) Write(p []byte) (n int, err error) {
+	appended, written, err := m.writeFromPos(p, m.pos)
+	if err != nil {
+		return -1, err
+	}
+	m.pos += appended
+	return written, err
+}
+
+// io.WriteAt implementation
+// returns
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+func (m *GapBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	// sanity checks
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+
+	appended, written, err := m.writeFromPos(p, int(off))
+	if err != nil {
+		return -1, err
+	}
+
+	// in case of overwrite + append, we want to move the position to the last
+	// appended byte in buffer
+	m.pos += appended
+
+	return written, err
+}
+
+// inserts p at current position and moves the position right after the
+// inserted bytes, returns the number of inserted bytes
+func (m *GapBuffer) Insert(p []byte) int {
+	l := len(p)
+	m.moveGap(m.pos)
+	m.growGap(l)
+	copy(m.buff[m.gapStart:], p)
+	m.gapStart += l
+	m.marks.insert(m.pos, l)
+	m.pos += l
+	return l
+}
+
+// deletes up to n bytes following current position, position is unchanged
+// returns the number of deleted bytes
+func (m *GapBuffer) Delete(n int) int {
+	n = min_int(max_int(n, 0), m.len()-m.pos)
+	m.moveGap(m.pos)
+	m.gapEnd += n
+	m.marks.remove(m.pos, m.pos+n)
+	return n
+}
+
+// deletes up to n bytes preceding current position, much like a backspace
+// position moves back by the number of deleted bytes, which is returned
+func (m *GapBuffer) DeleteBack(n int) int {
+	n = min_int(max_int(n, 0), m.pos)
+	m.moveGap(m.pos)
+	m.gapStart -= n
+	m.pos -= n
+	m.marks.remove(m.pos, m.pos+n)
+	return n
+}
+
+// io.ByteReader implementation
+func (m *GapBuffer) ReadByte() (byte, error) {
+	if m.posOverflow(m.pos) {
+		return 0, io.EOF
+	}
+	c := m.byteAt(m.pos)
+	m.pos++
+	return c, nil
+}
+
+// io.ByteWriter implementation, appends c to buffer, same as ByteBuffer
+// NOTE: this function will never return an error, in case we're out of memory
+// a panic will most likely occur
+func (m *GapBuffer) WriteByte(c byte) error {
+	l := m.len()
+	m.moveGap(l)
+	m.growGap(1)
+	m.buff[m.gapStart] = c
+	m.gapStart++
+	m.pos = l + 1
+
+	return nil
+}
+
+func (m *GapBuffer) byteAt(pos int) byte {
+	if pos < m.gapStart {
+		return m.buff[pos]
+	}
+	return m.buff[pos+m.gapLen()]
+}
+
+// returns a byte at a specific position in buffer
+// much like indexing a byte slice
+func (m *GapBuffer) ByteAt(pos int) (byte, error) {
+	if pos < 0 {
+		return 0, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return 0, ErrOffsetOverflow
+	}
+	return m.byteAt(pos), nil
+}
+
+// returns the number of bytes written or error
+func (m *GapBuffer) WriteUInt64Var(x uint64) (int, error) {
+	buff := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buff, x)
+	return m.Write(buff[:n])
+}
+
+// reads and returns an uint64s or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, the varint is truncated
+//	ErrVarintOverflow
+// NOTE:
+//	- on error the position is left unchanged, @ByteBuffer.ReadUInt64Var
+func (m *GapBuffer) ReadUInt64Var() (uint64, error) {
+	x, n, err := read_uvarint_at(m.readFromPos, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	return x, nil
+}
@@ -0,0 +1,222 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestNewGapBuffer(t *testing.T) {
+	tag := "NewGapBuffer()"
+
+	test_sizes := []uint{0, 1, 3, 5, 1024}
+
+	for i := 0; i < len(test_sizes); i++ {
+		expected_size := test_sizes[i]
+		b := NewGapBuffer(expected_size)
+		size := b.Size()
+		if size != expected_size {
+			t.Fatalf(tag+" size error, expected %v, found %v", expected_size, size)
+		}
+		if uint(len(b.Bytes())) != expected_size {
+			t.Fatalf(tag+" bytes size error, expected %v, found %v", expected_size, len(b.Bytes()))
+		}
+	}
+}
+
+func TestGapBufferInsertDelete(t *testing.T) {
+	tag := "GapBuffer.Insert/Delete()"
+
+	b := NewGapBuffer(0)
+	b.Insert([]byte("abracadabra"))
+
+	// insert in the middle, gap has to travel back
+	_, err := b.SeekFromStart(4)
+	if err != nil {
+		t.Fatalf(tag+" unexpected seek error: %v", err.Error())
+	}
+	b.Insert([]byte("--"))
+	if string(b.Bytes()) != "abra--cadabra" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(b.Bytes()))
+	}
+	if b.Pos() != 6 {
+		t.Fatalf(tag+" unexpected position, expected 6, found %v", b.Pos())
+	}
+
+	// backspace the inserted bytes
+	n := b.DeleteBack(2)
+	if n != 2 {
+		t.Fatalf(tag+" unexpected delete size, expected 2, found %v", n)
+	}
+	if string(b.Bytes()) != "abracadabra" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(b.Bytes()))
+	}
+
+	// forward delete past the end is clamped
+	_, err = b.SeekFromEnd(-4)
+	if err != nil {
+		t.Fatalf(tag+" unexpected seek error: %v", err.Error())
+	}
+	n = b.Delete(100)
+	if n != 4 {
+		t.Fatalf(tag+" unexpected delete size, expected 4, found %v", n)
+	}
+	if string(b.Bytes()) != "abracad" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(b.Bytes()))
+	}
+
+	// cursor is allowed at the very end so we can append
+	_, err = b.SeekToEnd()
+	if err != nil {
+		t.Fatalf(tag+" unexpected seek error: %v", err.Error())
+	}
+	b.Insert([]byte("abra"))
+	if string(b.Bytes()) != "abracadabra" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(b.Bytes()))
+	}
+}
+
+func TestGapBufferGrow(t *testing.T) {
+	tag := "GapBuffer.Insert(grow)"
+
+	b := NewGapBuffer(0)
+	expected := []byte{}
+
+	// insert at alternating positions, forcing the gap to move and grow
+	for i := 0; i < 1024; i++ {
+		c := byte(i)
+		pos := (i * 7) % (len(expected) + 1)
+		b.SeekFromStart(int64(pos))
+		b.Insert([]byte{c})
+		expected = append(expected[:pos], append([]byte{c}, expected[pos:]...)...)
+	}
+
+	if bytes.Compare(expected, b.Bytes()) != 0 {
+		t.Fatalf(tag+" data mismatch\nexpected [%v]\nfound [%v]", expected, b.Bytes())
+	}
+
+	// ReadAt across the gap
+	b.SeekFromStart(512)
+	b.Insert([]byte{})
+	rbuff := make([]byte, 64)
+	n, err := b.ReadAt(rbuff, 480)
+	if err != nil {
+		t.Fatalf(tag+" unexpected read error: %v", err.Error())
+	}
+	if n != len(rbuff) {
+		t.Fatalf(tag+" unexpected read size, expected %v, found %v", len(rbuff), n)
+	}
+	if bytes.Compare(expected[480:544], rbuff) != 0 {
+		t.Fatalf(tag+" data mismatch\nexpected [%v]\nfound [%v]", expected[480:544], rbuff)
+	}
+}
+
+func TestGapBufferWrite(t *testing.T) {
+	tag := "GapBuffer.Write()"
+
+	// Write and WriteAt must behave exactly like ByteBuffer
+	b := NewGapBuffer(0)
+	expected := NewByteBuffer(0)
+	buff := []byte{'a', 'b', 'c', 'd', 'e', 'f'}
+
+	for i := 0; i < 3; i++ {
+		b.Write(buff)
+		expected.Write(buff)
+	}
+
+	b.SeekFromStart(3)
+	expected.SeekFromStart(3)
+	b.Write(buff)
+	expected.Write(buff)
+
+	off := int64(expected.Size() - 2)
+	b.WriteAt(buff, off)
+	expected.WriteAt(buff, off)
+
+	b.WriteByte('x')
+	expected.WriteByte('x')
+
+	if bytes.Compare(expected.Bytes(), b.Bytes()) != 0 {
+		t.Fatalf(tag+" data mismatch\nexpected [%v]\nfound [%v]", expected.Bytes(), b.Bytes())
+	}
+	if expected.Pos() != b.Pos() {
+		t.Fatalf(tag+" unexpected position, expected %v, found %v", expected.Pos(), b.Pos())
+	}
+
+	_, err := b.WriteAt(buff, int64(b.Size()))
+	if err != ErrOffsetOverflow {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrOffsetOverflow.Error(), errOrNilStr(err))
+	}
+}
+
+func TestGapBufferRead(t *testing.T) {
+	tag := "GapBuffer.Read()"
+
+	b := NewGapBuffer(0)
+	b.Insert([]byte("abcdef"))
+	b.SeekFromStart(3)
+	b.Insert([]byte("XYZ"))
+	b.SeekToStart()
+
+	dst := NewByteBuffer(0)
+	bw, err := io.Copy(dst, b)
+	if err != nil {
+		t.Fatalf(tag+" unexpected error in io.Copy: %v", err.Error())
+	}
+	if bw != int64(b.Size()) {
+		t.Fatalf(tag+" unexpected total size, expected %v, found %v", b.Size(), bw)
+	}
+	if string(dst.Bytes()) != "abcXYZdef" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(dst.Bytes()))
+	}
+
+	_, err = b.ReadByte()
+	if err != io.EOF {
+		t.Fatalf(tag+" expected io.EOF, found %v", errOrNilStr(err))
+	}
+	n, err := b.Read(make([]byte, 4))
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+	b.SeekToStart()
+	data, err := io.ReadAll(b)
+	if err != nil || string(data) != "abcXYZdef" {
+		t.Fatalf(tag+" unexpected io.ReadAll result [%v] %v", string(data), errOrNilStr(err))
+	}
+
+	for i, c := range []byte("abcXYZdef") {
+		x, err := b.ByteAt(i)
+		if err != nil {
+			t.Fatalf(tag+" unexpected read error: %v", err.Error())
+		}
+		if x != c {
+			t.Fatalf(tag+" unexpected read value @%v, expected %v, found %v", i, c, x)
+		}
+	}
+}
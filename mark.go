@@ -0,0 +1,193 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"errors"
+)
+
+// returned when seeking to a bookmark that was never set
+var ErrBookmarkUnknown = errors.New("Unknown bookmark")
+
+// a saved position, see ByteBuffer.Mark
+type Mark struct {
+	pos int
+}
+
+// returns the saved position
+func (m Mark) Pos() int {
+	return m.pos
+}
+
+// named positions, nil until the first bookmark is set
+type bookmarks map[string]int
+
+// n bytes were inserted at offset at, bookmarks past it move along
+// a bookmark right at the insertion point stays in front of the new bytes
+func (m bookmarks) insert(at, n int) {
+	for k, v := range m {
+		if v > at {
+			m[k] = v + n
+		}
+	}
+}
+
+// bytes [from, to) were deleted, bookmarks within collapse to from, bookmarks
+// past them move back
+func (m bookmarks) remove(from, to int) {
+	for k, v := range m {
+		if v >= to {
+			m[k] = v - (to - from)
+		} else if v > from {
+			m[k] = from
+		}
+	}
+}
+
+// size shrank, bookmarks past the end move to the end
+func (m bookmarks) clamp(size int) {
+	for k, v := range m {
+		if v > size {
+			m[k] = size
+		}
+	}
+}
+
+// returns a checkpoint of current position, see Restore
+func (m *ByteBuffer) Mark() Mark {
+	return Mark{pos: m.pos}
+}
+
+// moves position back to mk, never fails
+// NOTE:
+//	- a mark past the end, because the buffer shrank meanwhile, restores to
+//		the end
+func (m *ByteBuffer) Restore(mk Mark) {
+	m.pos = min_int(mk.pos, len(m.buff))
+}
+
+// runs decode and moves position back to where it was if decode fails
+// returns the error returned by decode
+func (m *ByteBuffer) Try(decode func(*ByteBuffer) error) error {
+	mk := m.Mark()
+	err := decode(m)
+	if err != nil {
+		m.Restore(mk)
+	}
+	return err
+}
+
+// sets bookmark (name) at current position, replacing any previous one
+// NOTE:
+//	- bookmarks move to the end when Truncate or Reset shrink the buffer
+func (m *ByteBuffer) SetBookmark(name string) {
+	if m.marks == nil {
+		m.marks = bookmarks{}
+	}
+	m.marks[name] = m.pos
+}
+
+// returns the position of bookmark (name) and whether it exists
+func (m *ByteBuffer) Bookmark(name string) (int, bool) {
+	pos, ok := m.marks[name]
+	return pos, ok
+}
+
+// removes bookmark (name)
+func (m *ByteBuffer) RemoveBookmark(name string) {
+	delete(m.marks, name)
+}
+
+// moves position to bookmark (name)
+// errors:
+//	ErrBookmarkUnknown
+func (m *ByteBuffer) SeekToBookmark(name string) (int64, error) {
+	pos, ok := m.marks[name]
+	if !ok {
+		return -1, ErrBookmarkUnknown
+	}
+	m.pos = pos
+	return int64(pos), nil
+}
+
+// returns a checkpoint of current position, see Restore
+func (m *GapBuffer) Mark() Mark {
+	return Mark{pos: m.pos}
+}
+
+// moves position back to mk, never fails
+// NOTE:
+//	- marks are plain positions, use bookmarks to follow Insert and Delete
+//	- a mark past the end restores to the end
+func (m *GapBuffer) Restore(mk Mark) {
+	m.pos = min_int(mk.pos, m.len())
+}
+
+// runs decode and moves position back to where it was if decode fails
+// returns the error returned by decode
+func (m *GapBuffer) Try(decode func(*GapBuffer) error) error {
+	mk := m.Mark()
+	err := decode(m)
+	if err != nil {
+		m.Restore(mk)
+	}
+	return err
+}
+
+// sets bookmark (name) at current position, replacing any previous one
+// NOTE:
+//	- bookmarks follow Insert, Delete and DeleteBack, inserting right at a
+//		bookmark leaves it in front of the inserted bytes
+func (m *GapBuffer) SetBookmark(name string) {
+	if m.marks == nil {
+		m.marks = bookmarks{}
+	}
+	m.marks[name] = m.pos
+}
+
+// returns the position of bookmark (name) and whether it exists
+func (m *GapBuffer) Bookmark(name string) (int, bool) {
+	pos, ok := m.marks[name]
+	return pos, ok
+}
+
+// removes bookmark (name)
+func (m *GapBuffer) RemoveBookmark(name string) {
+	delete(m.marks, name)
+}
+
+// moves position to bookmark (name)
+// errors:
+//	ErrBookmarkUnknown
+func (m *GapBuffer) SeekToBookmark(name string) (int64, error) {
+	pos, ok := m.marks[name]
+	if !ok {
+		return -1, ErrBookmarkUnknown
+	}
+	m.pos = pos
+	return int64(pos), nil
+}
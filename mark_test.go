@@ -0,0 +1,183 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"errors"
+	"testing"
+)
+
+func newByteBufferFromString(s string) *ByteBuffer {
+	b := NewByteBuffer(0)
+	b.Write([]byte(s))
+	return b
+}
+
+func TestByteBufferMarkRestore(t *testing.T) {
+	tag := "ByteBuffer.Mark/Restore()"
+
+	b := newByteBufferFromString("0123456789")
+	b.SeekFromStart(3)
+	mk := b.Mark()
+	if mk.Pos() != 3 {
+		t.Fatalf(tag+" unexpected mark position, expected 3, found %v", mk.Pos())
+	}
+
+	b.Read(make([]byte, 4))
+	b.Restore(mk)
+	if b.Pos() != 3 {
+		t.Fatalf(tag+" unexpected position, expected 3, found %v", b.Pos())
+	}
+
+	// buffer shrank below the mark
+	b.SeekFromStart(8)
+	mk = b.Mark()
+	b.Truncate(5)
+	b.Restore(mk)
+	if b.Pos() != 5 {
+		t.Fatalf(tag+" unexpected position, expected 5, found %v", b.Pos())
+	}
+}
+
+func TestByteBufferTry(t *testing.T) {
+	tag := "ByteBuffer.Try()"
+
+	b := NewByteBuffer(0)
+	b.WriteUInt64Var(300)
+	b.WriteUInt64Var(7)
+	b.SeekToStart()
+
+	errBad := errors.New("bad")
+	var x uint64
+	err := b.Try(func(b *ByteBuffer) error {
+		x, _ = b.ReadUInt64Var()
+		return errBad
+	})
+	if err != errBad {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", errBad, errOrNilStr(err))
+	}
+	if b.Pos() != 0 {
+		t.Fatalf(tag+" position not restored, found %v", b.Pos())
+	}
+
+	err = b.Try(func(b *ByteBuffer) error {
+		x, err = b.ReadUInt64Var()
+		return err
+	})
+	if err != nil || x != 300 {
+		t.Fatalf(tag+" unexpected result %v, error [%v]", x, errOrNilStr(err))
+	}
+	if b.Pos() != 2 {
+		t.Fatalf(tag+" unexpected position, expected 2, found %v", b.Pos())
+	}
+}
+
+func TestByteBufferBookmark(t *testing.T) {
+	tag := "ByteBuffer.Bookmark()"
+
+	b := newByteBufferFromString("header|body")
+	b.SeekFromStart(7)
+	b.SetBookmark("body")
+	b.SeekToStart()
+
+	pos, ok := b.Bookmark("body")
+	if !ok || pos != 7 {
+		t.Fatalf(tag+" unexpected bookmark %v, %v", pos, ok)
+	}
+	n, err := b.SeekToBookmark("body")
+	if err != nil || n != 7 || b.Pos() != 7 {
+		t.Fatalf(tag+" unexpected seek %v, error [%v]", n, errOrNilStr(err))
+	}
+
+	b.Truncate(4)
+	pos, _ = b.Bookmark("body")
+	if pos != 4 {
+		t.Fatalf(tag+" bookmark not clamped, expected 4, found %v", pos)
+	}
+
+	b.RemoveBookmark("body")
+	_, err = b.SeekToBookmark("body")
+	if err != ErrBookmarkUnknown {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrBookmarkUnknown, errOrNilStr(err))
+	}
+}
+
+func TestGapBufferBookmark(t *testing.T) {
+	tag := "GapBuffer.Bookmark()"
+
+	b := NewGapBuffer(0)
+	b.Insert([]byte("hello world"))
+	b.SeekFromStart(6)
+	b.SetBookmark("world")
+	b.SeekToEnd()
+	b.SetBookmark("end")
+
+	// insert before the bookmark
+	b.SeekToStart()
+	b.Insert([]byte(">> "))
+	pos, _ := b.Bookmark("world")
+	if pos != 9 {
+		t.Fatalf(tag+" unexpected bookmark after insert, expected 9, found %v", pos)
+	}
+
+	// insert right at the bookmark, it stays in front
+	b.SeekToBookmark("world")
+	b.Insert([]byte("big "))
+	pos, _ = b.Bookmark("world")
+	if pos != 9 {
+		t.Fatalf(tag+" unexpected bookmark after insert, expected 9, found %v", pos)
+	}
+
+	// delete across the bookmark
+	b.SeekFromStart(7)
+	b.Delete(4)
+	pos, _ = b.Bookmark("world")
+	if pos != 7 {
+		t.Fatalf(tag+" unexpected bookmark after delete, expected 7, found %v", pos)
+	}
+	pos, _ = b.Bookmark("end")
+	if pos != b.len() {
+		t.Fatalf(tag+" unexpected end bookmark, expected %v, found %v", b.len(), pos)
+	}
+
+	// backspace in front of the bookmark
+	b.SeekFromStart(3)
+	b.DeleteBack(3)
+	pos, _ = b.Bookmark("world")
+	if pos != 4 {
+		t.Fatalf(tag+" unexpected bookmark after backspace, expected 4, found %v", pos)
+	}
+	b.SeekToBookmark("world")
+	mk := b.Mark()
+	err := b.Try(func(b *GapBuffer) error {
+		b.SeekToStart()
+		return ErrOffsetOverflow
+	})
+	if err != ErrOffsetOverflow || b.Pos() != mk.Pos() {
+		t.Fatalf(tag+" Try did not restore, position %v", b.Pos())
+	}
+}
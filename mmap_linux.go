@@ -0,0 +1,503 @@
+//go:build linux
+
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// how NewMappedByteBuffer opens and maps a file
+type MapMode int
+
+const (
+	// file must exist, writes return ErrReadOnly
+	KMAP_READ_ONLY MapMode = iota
+	// file is created if missing, writes go straight to the mapping
+	KMAP_READ_WRITE
+)
+
+// a ByteBuffer backed by a shared memory mapping of a file
+// the API mirrors ByteBuffer
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//	io.ByteReader
+//	io.ByteWriter
+//  io.Closer
+type MappedByteBuffer struct {
+	f *os.File
+	// the whole mapping, writes past the end of buff grow into it
+	mapped []byte
+	// logical contents, a prefix of mapped
+	buff []byte
+	// size of the file, at least len(buff), bytes of mapped past it are not
+	// backed by the file and must not be touched
+	fsize int
+	mode  MapMode
+	pos   int
+}
+
+// maps the file at path into memory, position is set to ZERO
+// NOTE:
+//	- the mapping is shared, writes are visible to other mappings of the same
+//		file right away and reach the file on Sync, Close or whenever the
+//		kernel decides to write back
+func NewMappedByteBuffer(path string, mode MapMode) (*MappedByteBuffer, error) {
+	flag := os.O_RDONLY
+	if mode == KMAP_READ_WRITE {
+		flag = os.O_RDWR | os.O_CREATE
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	m := &MappedByteBuffer{f: f, mode: mode}
+	err = m.mmap(int(fi.Size()))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	m.fsize = int(fi.Size())
+	m.buff = m.mapped
+	return m, nil
+}
+
+// maps (size) bytes of file in place of the current mapping, which is only
+// dropped once the new one is in place, a mapping of zero bytes is not allowed
+// by the kernel, an empty file gets an empty mapping instead
+func (m *MappedByteBuffer) mmap(size int) error {
+	if size == 0 {
+		return m.munmap()
+	}
+	prot := syscall.PROT_READ
+	if m.mode == KMAP_READ_WRITE {
+		prot |= syscall.PROT_WRITE
+	}
+	mapped, err := syscall.Mmap(int(m.f.Fd()), 0, size, prot, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	size = len(m.buff)
+	m.munmap()
+	m.mapped = mapped
+	m.buff = mapped[:size]
+	return nil
+}
+
+func (m *MappedByteBuffer) munmap() error {
+	mapped := m.mapped
+	m.mapped = nil
+	m.buff = m.buff[:0:0]
+	if len(mapped) == 0 {
+		return nil
+	}
+	return syscall.Munmap(mapped)
+}
+
+// makes the logical size (size) bytes, the file and the mapping grow
+// geometrically so appends don't remap every time
+// NOTE:
+//	- on error the buffer is left as it was
+func (m *MappedByteBuffer) grow(size int) error {
+	if size > m.fsize {
+		fsize := max_int(size, max_int(2*m.fsize, os.Getpagesize()))
+		err := m.f.Truncate(int64(fsize))
+		if err != nil {
+			return err
+		}
+		if fsize > len(m.mapped) {
+			err = m.mmap(fsize)
+			if err != nil {
+				// back to the old size, the old mapping is still in place
+				m.f.Truncate(int64(m.fsize))
+				return err
+			}
+		}
+		m.fsize = fsize
+	}
+	m.buff = m.mapped[:size]
+	return nil
+}
+
+func (m *MappedByteBuffer) check() error {
+	if m.f == nil {
+		return ErrClosed
+	}
+	return nil
+}
+
+// flushes changes made to the mapping back to the file (msync) and trims the
+// file to the size of the buffer, dropping room reserved by growth
+// errors:
+//	ErrClosed
+//	any error returned by msync or ftruncate
+func (m *MappedByteBuffer) Sync() error {
+	err := m.check()
+	if err != nil {
+		return err
+	}
+	if m.mode != KMAP_READ_WRITE {
+		return nil
+	}
+	if len(m.buff) > 0 {
+		_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+			uintptr(unsafe.Pointer(&m.buff[0])), uintptr(len(m.buff)), syscall.MS_SYNC)
+		if errno != 0 {
+			return errno
+		}
+	}
+	if m.fsize > len(m.buff) {
+		err = m.f.Truncate(int64(len(m.buff)))
+		if err != nil {
+			return err
+		}
+		m.fsize = len(m.buff)
+	}
+	return nil
+}
+
+// syncs, unmaps and closes the file
+// any further call returns ErrClosed
+func (m *MappedByteBuffer) Close() error {
+	err := m.check()
+	if err != nil {
+		return err
+	}
+	err = m.Sync()
+	if uerr := m.munmap(); err == nil {
+		err = uerr
+	}
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	m.f = nil
+	m.fsize = 0
+	m.pos = 0
+	return err
+}
+
+// returns true if the mapped file is empty
+func (m *MappedByteBuffer) Empty() bool {
+	return len(m.buff) == 0
+}
+
+// returns size in bytes of the mapped file
+func (m *MappedByteBuffer) Size() uint {
+	return uint(len(m.buff))
+}
+
+// returns internal buffer position
+func (m *MappedByteBuffer) Pos() int {
+	return m.pos
+}
+
+// returns a copy of the mapped file as a byte slice
+func (m *MappedByteBuffer) Bytes() []byte {
+	r := make([]byte, len(m.buff))
+	copy(r, m.buff)
+	return r
+}
+
+// check if p is overflowing buffer
+func (m *MappedByteBuffer) posOverflow(p int) bool {
+	return p >= len(m.buff)
+}
+
+// @MappedByteBuffer.Seek(offset, io.SeekStart)
+func (m *MappedByteBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @MappedByteBuffer.Seek(offset, io.SeekCurrent)
+func (m *MappedByteBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @MappedByteBuffer.Seek(offset, io.SeekEnd)
+func (m *MappedByteBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @MappedByteBuffer.Seek(0, io.SeekStart)
+func (m *MappedByteBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @MappedByteBuffer.Seek(0, io.SeekEnd)
+func (m *MappedByteBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation
+// returns offset position if err == nil
+// errors:
+//	ErrClosed
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *MappedByteBuffer) Seek(offset int64, whence int) (int64, error) {
+	err := m.check()
+	if err != nil {
+		return -1, err
+	}
+
+	pos, err := seek_abs(offset, whence, m.pos, len(m.buff))
+	if err != nil {
+		return -1, err
+	}
+
+	// check for overflow
+	if m.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+
+	// update position
+	m.pos = pos
+
+	return int64(pos), nil
+}
+
+func (m *MappedByteBuffer) readFromPos(p []byte, pos int, incPos bool) (n int, err error) {
+	l := len(p)
+
+	// number of available bytes to read from position
+	avail := len(m.buff) - pos
+	if avail > 0 {
+		n = copy(p, m.buff[pos:])
+
+		// increment position only if called by Read, ReadAt also calls this function
+		if incPos {
+			m.pos += n
+		}
+
+		// check if we've read less bytes than the size of p
+		if n < l {
+			err = io.EOF
+		}
+		return
+	}
+	return 0, io.EOF
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrClosed
+func (m *MappedByteBuffer) Read(p []byte) (n int, err error) {
+	err = m.check()
+	if err != nil {
+		return 0, err
+	}
+	return m.readFromPos(p, m.pos, true)
+}
+
+// io.ReaderAt implementation
+// reads up to len(p) from mapping at offset off
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrClosed
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+// NOTE:
+//	- ReadAt will NOT modify internal position
+func (m *MappedByteBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	err = m.check()
+	if err != nil {
+		return -1, err
+	}
+
+	pos := int(off)
+
+	// sanity checks
+	if pos < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return -1, ErrOffsetOverflow
+	}
+
+	return m.readFromPos(p, pos, false)
+}
+
+// writes p at pos, growing the file when writing past the end
+func (m *MappedByteBuffer) writeFromPos(p []byte, pos int) (appended int, written int, err error) {
+	err = m.check()
+	if err != nil {
+		return 0, 0, err
+	}
+	if m.mode != KMAP_READ_WRITE {
+		return 0, 0, ErrReadOnly
+	}
+
+	l := len(p)
+	end := pos + l
+	if end > len(m.buff) {
+		appended = end - len(m.buff)
+		err = m.grow(end)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	copy(m.buff[pos:], p)
+
+	return appended, l, nil
+}
+
+// io.Writer implementation
+// writes p to the mapping at current position
+// errors:
+//	ErrClosed
+//	ErrReadOnly
+// NOTE:
+//	- if current position is within the buffer, some or all of the bytes will be
+//		overwritten, same as ByteBuffer.Write
+//	- writing past the end grows the file (ftruncate) and the mapping
+//		geometrically, the file is trimmed back to the size of the buffer on
+//		Sync and Close
+func (m *MappedByteBuffer) Write(p []byte) (n int, err error) {
+	appended, written, err := m.writeFromPos(p, m.pos)
+	if err != nil {
+		return -1, err
+	}
+	m.pos += appended
+	return written, err
+}
+
+// io.WriteAt implementation
+// returns
+//	ErrClosed
+//	ErrReadOnly
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+func (m *MappedByteBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	// sanity checks
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+
+	appended, written, err := m.writeFromPos(p, int(off))
+	if err != nil {
+		return -1, err
+	}
+
+	// in case of overwrite + append, we want to move the position to the last
+	// appended byte in buffer
+	m.pos += appended
+
+	return written, err
+}
+
+// io.ByteReader implementation
+func (m *MappedByteBuffer) ReadByte() (byte, error) {
+	err := m.check()
+	if err != nil {
+		return 0, err
+	}
+	if m.posOverflow(m.pos) {
+		return 0, io.EOF
+	}
+	c := m.buff[m.pos]
+	m.pos++
+	return c, nil
+}
+
+// io.ByteWriter implementation, appends c to the file, same as ByteBuffer
+// errors:
+//	ErrClosed
+//	ErrReadOnly
+func (m *MappedByteBuffer) WriteByte(c byte) error {
+	_, _, err := m.writeFromPos([]byte{c}, len(m.buff))
+	if err != nil {
+		return err
+	}
+	m.pos = len(m.buff)
+	return nil
+}
+
+// returns a byte at a specific position in the mapping
+// much like indexing a byte slice
+func (m *MappedByteBuffer) ByteAt(pos int) (byte, error) {
+	err := m.check()
+	if err != nil {
+		return 0, err
+	}
+	if pos < 0 {
+		return 0, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return 0, ErrOffsetOverflow
+	}
+	return m.buff[pos], nil
+}
+
+// returns the number of bytes written or error
+func (m *MappedByteBuffer) WriteUInt64Var(x uint64) (int, error) {
+	buff := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buff, x)
+	return m.Write(buff[:n])
+}
+
+// reads and returns an uint64s or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, the varint is truncated
+//	ErrVarintOverflow
+//	ErrClosed
+// NOTE:
+//	- on error the position is left unchanged, @ByteBuffer.ReadUInt64Var
+func (m *MappedByteBuffer) ReadUInt64Var() (uint64, error) {
+	if err := m.check(); err != nil {
+		return 0, err
+	}
+	x, n, err := peek_uvarint(m.buff, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	return x, nil
+}
@@ -0,0 +1,191 @@
+//go:build linux
+
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedByteBufferReadWrite(t *testing.T) {
+	tag := "MappedByteBuffer.Read/Write()"
+
+	path := filepath.Join(t.TempDir(), "mapped")
+
+	b, err := NewMappedByteBuffer(path, KMAP_READ_WRITE)
+	if err != nil {
+		t.Fatalf(tag+" unexpected open error: %v", err.Error())
+	}
+	if !b.Empty() {
+		t.Fatalf(tag+" expected empty buffer, found %v bytes", b.Size())
+	}
+
+	s := "abracadabra"
+	n, err := b.Write([]byte(s))
+	if err != nil {
+		t.Fatalf(tag+" unexpected write error: %v", err.Error())
+	}
+	if n != len(s) || b.Pos() != len(s) {
+		t.Fatalf(tag+" unexpected write size/position %v/%v", n, b.Pos())
+	}
+
+	// overwrite and append, grows the file once more
+	_, err = b.WriteAt([]byte("ABRA!"), 7)
+	if err != nil {
+		t.Fatalf(tag+" unexpected write error: %v", err.Error())
+	}
+	err = b.WriteByte('?')
+	if err != nil {
+		t.Fatalf(tag+" unexpected write error: %v", err.Error())
+	}
+	expected := "abracadABRA!?"
+	if string(b.Bytes()) != expected {
+		t.Fatalf(tag+" unexpected contents [%v]", string(b.Bytes()))
+	}
+	n, err = b.Read(make([]byte, 4))
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+	b.SeekToStart()
+	rdata, err := io.ReadAll(b)
+	if err != nil || string(rdata) != expected {
+		t.Fatalf(tag+" unexpected io.ReadAll result [%v] %v", string(rdata), errOrNilStr(err))
+	}
+
+	err = b.Sync()
+	if err != nil {
+		t.Fatalf(tag+" unexpected sync error: %v", err.Error())
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != expected {
+		t.Fatalf(tag+" unexpected file contents [%v]", string(data))
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatalf(tag+" unexpected close error: %v", err.Error())
+	}
+	_, err = b.Write([]byte(s))
+	if err != ErrClosed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrClosed.Error(), errOrNilStr(err))
+	}
+}
+
+func TestMappedByteBufferReadOnly(t *testing.T) {
+	tag := "MappedByteBuffer(KMAP_READ_ONLY)"
+
+	path := filepath.Join(t.TempDir(), "mapped")
+	s := "abracadabra"
+	os.WriteFile(path, []byte(s), 0644)
+
+	b, err := NewMappedByteBuffer(path, KMAP_READ_ONLY)
+	if err != nil {
+		t.Fatalf(tag+" unexpected open error: %v", err.Error())
+	}
+	defer b.Close()
+
+	_, err = b.SeekFromEnd(-4)
+	if err != nil {
+		t.Fatalf(tag+" unexpected seek error: %v", err.Error())
+	}
+	rbuff := make([]byte, 8)
+	n, err := b.Read(rbuff)
+	if err != io.EOF || string(rbuff[:n]) != "abra" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+
+	n, err = b.ReadAt(rbuff[:3], 4)
+	if err != nil || string(rbuff[:n]) != "cad" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+
+	_, err = b.WriteAt([]byte("x"), 0)
+	if err != ErrReadOnly {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrReadOnly.Error(), errOrNilStr(err))
+	}
+
+	_, err = NewMappedByteBuffer(path+".missing", KMAP_READ_ONLY)
+	if err == nil {
+		t.Fatal(tag + " expected error opening a missing file")
+	}
+}
+
+func TestMappedByteBufferGrow(t *testing.T) {
+	tag := "MappedByteBuffer(grow)"
+
+	path := filepath.Join(t.TempDir(), "mapped")
+	b, err := NewMappedByteBuffer(path, KMAP_READ_WRITE)
+	if err != nil {
+		t.Fatalf(tag+" unexpected open error: %v", err.Error())
+	}
+
+	// byte by byte appends remap only a handful of times
+	expected := make([]byte, 100000)
+	remaps := 0
+	for i := range expected {
+		expected[i] = byte(i)
+		mapped := len(b.mapped)
+		err = b.WriteByte(byte(i))
+		if err != nil {
+			t.Fatalf(tag+" unexpected write error: %v", err.Error())
+		}
+		if len(b.mapped) != mapped {
+			remaps++
+		}
+	}
+	if remaps > 10 {
+		t.Fatalf(tag+" unexpected number of remaps, expected at most 10, found %v", remaps)
+	}
+	if b.Size() != uint(len(expected)) || b.Pos() != len(expected) {
+		t.Fatalf(tag+" unexpected size/position %v/%v", b.Size(), b.Pos())
+	}
+
+	// room reserved by growth is trimmed on Sync, growth works afterwards
+	err = b.Sync()
+	if err != nil {
+		t.Fatalf(tag+" unexpected sync error: %v", err.Error())
+	}
+	fi, _ := os.Stat(path)
+	if fi.Size() != int64(len(expected)) {
+		t.Fatalf(tag+" unexpected file size after Sync, expected %v, found %v", len(expected), fi.Size())
+	}
+	b.Write([]byte("abracadabra"))
+	expected = append(expected, "abracadabra"...)
+
+	err = b.Close()
+	if err != nil {
+		t.Fatalf(tag+" unexpected close error: %v", err.Error())
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != string(expected) {
+		t.Fatalf(tag+" unexpected file contents, size %v", len(data))
+	}
+}
@@ -0,0 +1,271 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/dorind/mbytes"
+)
+
+// BPS actions, stored in the low 2 bits of each action varint
+const (
+	KBPS_SOURCE_READ = 0
+	KBPS_TARGET_READ = 1
+	KBPS_SOURCE_COPY = 2
+	KBPS_TARGET_COPY = 3
+)
+
+// returns the metadata stored in BPS patch p, usually XML or empty
+// errors:
+//	ErrFormatUnknown
+//	ErrCorrupt
+//	ErrPatchChecksum
+func MetadataBPS(p *mbytes.ByteBuffer) ([]byte, error) {
+	body, _, err := splitBPS(p.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	pos := len(KBPS_MAGIC)
+	for i := 0; i < 2; i++ {
+		if _, err := readVarint(body, &pos); err != nil {
+			return nil, err
+		}
+	}
+	l, err := readVarint(body, &pos)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(len(body)-pos) {
+		return nil, ErrCorrupt
+	}
+	return append([]byte{}, body[pos:pos+int(l)]...), nil
+}
+
+// checks the magic and patch checksum of a BPS patch
+func splitBPS(p []byte) ([]byte, footer, error) {
+	if len(p) < len(KBPS_MAGIC) || string(p[:len(KBPS_MAGIC)]) != KBPS_MAGIC {
+		return nil, footer{}, ErrFormatUnknown
+	}
+	return splitFooter(p)
+}
+
+// applies BPS patch p to src and returns the target
+func applyBPS(src []byte, p []byte) ([]byte, error) {
+	body, foot, err := splitBPS(p)
+	if err != nil {
+		return nil, err
+	}
+
+	pos := len(KBPS_MAGIC)
+	srcSize, err := readVarint(body, &pos)
+	if err != nil {
+		return nil, err
+	}
+	dstSize, err := readVarint(body, &pos)
+	if err != nil {
+		return nil, err
+	}
+	metaSize, err := readVarint(body, &pos)
+	if err != nil {
+		return nil, err
+	}
+	if metaSize > uint64(len(body)-pos) {
+		return nil, ErrCorrupt
+	}
+	pos += int(metaSize)
+
+	if srcSize != uint64(len(src)) || crc32.ChecksumIEEE(src) != foot.src {
+		return nil, ErrSourceChecksum
+	}
+
+	// dstSize is not trusted for the allocation, the actions are
+	var out []byte
+	var srcRel, dstRel int64
+	for pos < len(body) {
+		d, err := readVarint(body, &pos)
+		if err != nil {
+			return nil, err
+		}
+		l := d>>2 + 1
+		if l > dstSize-uint64(len(out)) {
+			return nil, ErrCorrupt
+		}
+		n := int(l)
+
+		switch d & 3 {
+		case KBPS_SOURCE_READ:
+			if len(out)+n > len(src) {
+				return nil, ErrCorrupt
+			}
+			out = append(out, src[len(out):len(out)+n]...)
+		case KBPS_TARGET_READ:
+			if n > len(body)-pos {
+				return nil, ErrCorrupt
+			}
+			out = append(out, body[pos:pos+n]...)
+			pos += n
+		case KBPS_SOURCE_COPY:
+			if srcRel, err = bpsRelative(body, &pos, srcRel); err != nil {
+				return nil, err
+			}
+			if srcRel < 0 || srcRel+int64(n) > int64(len(src)) {
+				return nil, ErrCorrupt
+			}
+			out = append(out, src[srcRel:srcRel+int64(n)]...)
+			srcRel += int64(n)
+		case KBPS_TARGET_COPY:
+			if dstRel, err = bpsRelative(body, &pos, dstRel); err != nil {
+				return nil, err
+			}
+			if dstRel < 0 || dstRel >= int64(len(out)) {
+				return nil, ErrCorrupt
+			}
+			// byte by byte, source and destination may overlap
+			for i := 0; i < n; i++ {
+				out = append(out, out[dstRel])
+				dstRel++
+			}
+		}
+	}
+
+	if uint64(len(out)) != dstSize {
+		return nil, ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(out) != foot.dst {
+		return nil, ErrTargetChecksum
+	}
+	return out, nil
+}
+
+// reads a signed relative offset and returns it added to rel
+func bpsRelative(body []byte, pos *int, rel int64) (int64, error) {
+	d, err := readVarint(body, pos)
+	if err != nil {
+		return 0, err
+	}
+	if d>>1 > 1<<62 {
+		return 0, ErrCorrupt
+	}
+	if d&1 != 0 {
+		return rel - int64(d>>1), nil
+	}
+	return rel + int64(d>>1), nil
+}
+
+// applies BPS patch p to src and returns the target in a new buffer
+// errors:
+//	ErrFormatUnknown
+//	ErrCorrupt
+//	ErrPatchChecksum
+//	ErrSourceChecksum
+//	ErrTargetChecksum
+func ApplyBPS(src, p *mbytes.ByteBuffer) (*mbytes.ByteBuffer, error) {
+	out, err := applyBPS(src.Bytes(), p.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return newBuffer(out), nil
+}
+
+// @ApplyBPS, the target replaces the contents of b
+// NOTE:
+//	- BPS copies from anywhere in the source, so the target is built aside
+//		and verified before b is modified
+func ApplyBPSInPlace(b, p *mbytes.ByteBuffer) error {
+	out, err := applyBPS(b.Bytes(), p.Bytes())
+	if err != nil {
+		return err
+	}
+
+	mk := b.Mark()
+	defer b.Restore(mk)
+
+	if err := resize(b, uint(len(out))); err != nil {
+		return err
+	}
+	return writeAt(b, out, 0)
+}
+
+// returns a BPS patch turning src into dst
+// NOTE:
+//	- built out of mbytes.Diff, copies at the same offset become SourceRead
+//		actions, other copies SourceCopy and inserts TargetRead
+func CreateBPS(src, dst *mbytes.ByteBuffer) *mbytes.ByteBuffer {
+	return CreateBPSWithMetadata(src, dst, nil)
+}
+
+// @CreateBPS, meta is stored as is in the patch, see MetadataBPS
+func CreateBPSWithMetadata(src, dst *mbytes.ByteBuffer, meta []byte) *mbytes.ByteBuffer {
+	p := mbytes.NewByteBuffer(0)
+	p.Write([]byte(KBPS_MAGIC))
+	putVarint(p, uint64(src.Size()))
+	putVarint(p, uint64(dst.Size()))
+	putVarint(p, uint64(len(meta)))
+	p.Write(meta)
+
+	// walk the delta, its header was checked by Diff itself
+	d := mbytes.Diff(src, dst)
+	d.SeekFromStart(int64(len(mbytes.KDELTA_MAGIC)))
+	d.ReadUInt64Var()
+	d.ReadUInt64Var()
+	d.ReadUInt32(binary.BigEndian)
+
+	var outOff, srcRel uint64
+	for {
+		op, err := d.ReadByte()
+		if err != nil {
+			break
+		}
+		switch op {
+		case mbytes.KDELTA_COPY:
+			off, _ := d.ReadUInt64Var()
+			l, _ := d.ReadUInt64Var()
+			if off == outOff {
+				putVarint(p, (l-1)<<2|KBPS_SOURCE_READ)
+			} else {
+				putVarint(p, (l-1)<<2|KBPS_SOURCE_COPY)
+				if off >= srcRel {
+					putVarint(p, (off-srcRel)<<1)
+				} else {
+					putVarint(p, (srcRel-off)<<1|1)
+				}
+				srcRel = off + l
+			}
+			outOff += l
+		case mbytes.KDELTA_INSERT:
+			lit, _ := d.ReadBytesVar()
+			putVarint(p, uint64(len(lit)-1)<<2|KBPS_TARGET_READ)
+			p.Write(lit)
+			outOff += uint64(len(lit))
+		}
+	}
+
+	writeFooter(p, checksum(src), checksum(dst))
+	return p
+}
@@ -0,0 +1,122 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"hash/crc32"
+	"testing"
+
+	"github.com/dorind/mbytes"
+)
+
+// builds a BPS patch by hand, actions are appended by fn
+func buildBPS(src, dst []byte, fn func(p *mbytes.ByteBuffer)) *mbytes.ByteBuffer {
+	p := mbytes.NewByteBuffer(0)
+	p.Write([]byte(KBPS_MAGIC))
+	putVarint(p, uint64(len(src)))
+	putVarint(p, uint64(len(dst)))
+	putVarint(p, 0)
+	fn(p)
+	writeFooter(p, crc32.ChecksumIEEE(src), crc32.ChecksumIEEE(dst))
+	return p
+}
+
+func TestApplyBPS(t *testing.T) {
+	tag := "ApplyBPS()"
+
+	src := []byte("abcdef")
+	dst := []byte("abXYXYXYdef")
+	p := buildBPS(src, dst, func(p *mbytes.ByteBuffer) {
+		// "ab"
+		putVarint(p, (2-1)<<2|KBPS_SOURCE_READ)
+		// "XY"
+		putVarint(p, (2-1)<<2|KBPS_TARGET_READ)
+		p.Write([]byte("XY"))
+		// "XYXY", overlapping copy of the target starting at 2
+		putVarint(p, (4-1)<<2|KBPS_TARGET_COPY)
+		putVarint(p, 2<<1)
+		// "def" from source offset 3
+		putVarint(p, (3-1)<<2|KBPS_SOURCE_COPY)
+		putVarint(p, 3<<1)
+	})
+
+	out, err := ApplyBPS(newBuffer(src), p)
+	if err != nil {
+		t.Fatalf(tag+" unexpected error: %v", err)
+	}
+	if string(out.Bytes()) != string(dst) {
+		t.Fatalf(tag+" unexpected result [%v]", string(out.Bytes()))
+	}
+
+	// negative source copy offset, "fed" one byte at a time
+	p = buildBPS(src, []byte("fed"), func(p *mbytes.ByteBuffer) {
+		putVarint(p, KBPS_SOURCE_COPY)
+		putVarint(p, 5<<1)
+		putVarint(p, KBPS_SOURCE_COPY)
+		putVarint(p, 2<<1|1)
+		putVarint(p, KBPS_SOURCE_COPY)
+		putVarint(p, 2<<1|1)
+	})
+	out, err = ApplyBPS(newBuffer(src), p)
+	if err != nil || string(out.Bytes()) != "fed" {
+		t.Fatalf(tag+" unexpected result [%v], error [%v]", string(out.Bytes()), errOrNilStr(err))
+	}
+
+	// copy out of bounds
+	p = buildBPS(src, []byte("xxxx"), func(p *mbytes.ByteBuffer) {
+		putVarint(p, (4-1)<<2|KBPS_SOURCE_COPY)
+		putVarint(p, 4<<1)
+	})
+	_, err = ApplyBPS(newBuffer(src), p)
+	if err != ErrCorrupt {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrCorrupt, errOrNilStr(err))
+	}
+
+	// wrong source, buffer left alone
+	b := newBuffer([]byte("abcdeF"))
+	err = ApplyBPSInPlace(b, CreateBPS(newBuffer(src), newBuffer(dst)))
+	if err != ErrSourceChecksum || string(b.Bytes()) != "abcdeF" {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrSourceChecksum, errOrNilStr(err))
+	}
+}
+
+func TestMetadataBPS(t *testing.T) {
+	tag := "MetadataBPS()"
+
+	meta := []byte("<patch author=\"vendor\"/>")
+	src, dst := newBuffer([]byte("firmware v1")), newBuffer([]byte("firmware v2"))
+	p := CreateBPSWithMetadata(src, dst, meta)
+
+	m, err := MetadataBPS(p)
+	if err != nil || string(m) != string(meta) {
+		t.Fatalf(tag+" unexpected metadata [%v], error [%v]", string(m), errOrNilStr(err))
+	}
+	out, err := ApplyBPS(src, p)
+	if err != nil || out.CmpWith(dst) != 0 {
+		t.Fatalf(tag+" unexpected result [%v], error [%v]", string(out.Bytes()), errOrNilStr(err))
+	}
+}
@@ -0,0 +1,220 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"github.com/dorind/mbytes"
+)
+
+// IPS offsets are 24 bits, record sizes 16 bits
+const (
+	KIPS_MAX_OFFSET = 0xffffff
+	KIPS_MAX_RECORD = 0xffff
+)
+
+// end of records marker, also the one offset a record can't start at
+const kipsEOF = "EOF"
+const kipsEOFOffset = 0x454f46
+
+// a parsed IPS record, run is the byte repeated size times when rle is set
+type ipsRecord struct {
+	off  int
+	data []byte
+	rle  bool
+	size int
+	run  byte
+}
+
+// parses an IPS patch, returns its records and the truncation size, -1 when
+// the patch doesn't truncate
+func parseIPS(p []byte) ([]ipsRecord, int, error) {
+	if len(p) < len(KIPS_MAGIC) || string(p[:len(KIPS_MAGIC)]) != KIPS_MAGIC {
+		return nil, -1, ErrFormatUnknown
+	}
+
+	var recs []ipsRecord
+	pos := len(KIPS_MAGIC)
+	for {
+		if len(p)-pos < 3 {
+			return nil, -1, ErrCorrupt
+		}
+		if string(p[pos:pos+3]) == kipsEOF {
+			pos += 3
+			break
+		}
+		if len(p)-pos < 5 {
+			return nil, -1, ErrCorrupt
+		}
+		r := ipsRecord{
+			off:  be24(p[pos:]),
+			size: be16(p[pos+3:]),
+		}
+		pos += 5
+		if r.size == 0 {
+			// run length encoded record
+			if len(p)-pos < 3 {
+				return nil, -1, ErrCorrupt
+			}
+			r.rle = true
+			r.size = be16(p[pos:])
+			r.run = p[pos+2]
+			pos += 3
+		} else {
+			if len(p)-pos < r.size {
+				return nil, -1, ErrCorrupt
+			}
+			r.data = p[pos : pos+r.size]
+			pos += r.size
+		}
+		recs = append(recs, r)
+	}
+
+	// optional truncation extension
+	switch len(p) - pos {
+	case 0:
+		return recs, -1, nil
+	case 3:
+		return recs, be24(p[pos:]), nil
+	}
+	return nil, -1, ErrCorrupt
+}
+
+// @ApplyIPSInPlace into a clone of src, src is untouched
+func ApplyIPS(src, p *mbytes.ByteBuffer) (*mbytes.ByteBuffer, error) {
+	b := src.Clone()
+	if err := ApplyIPSInPlace(b, p); err != nil {
+		return nil, err
+	}
+	b.SeekToStart()
+	return b, nil
+}
+
+// applies IPS patch p to b, records past the end grow b
+// errors:
+//	ErrFormatUnknown
+//	ErrCorrupt
+// NOTE:
+//	- IPS has no checksums, any b of suitable size is patched
+//	- the whole patch is parsed before b is modified
+func ApplyIPSInPlace(b, p *mbytes.ByteBuffer) error {
+	recs, trunc, err := parseIPS(p.Bytes())
+	if err != nil {
+		return err
+	}
+
+	mk := b.Mark()
+	defer b.Restore(mk)
+
+	for _, r := range recs {
+		data := r.data
+		if r.rle {
+			data = make([]byte, r.size)
+			for i := range data {
+				data[i] = r.run
+			}
+		}
+		if err := writeAt(b, data, r.off); err != nil {
+			return err
+		}
+	}
+	if trunc >= 0 {
+		return resize(b, uint(trunc))
+	}
+	return nil
+}
+
+// returns an IPS patch turning src into dst
+// errors:
+//	ErrTooLarge, dst differs from src past 16M
+// NOTE:
+//	- runs of a single byte are run length encoded
+//	- dst shorter than src uses the truncation extension
+func CreateIPS(src, dst *mbytes.ByteBuffer) (*mbytes.ByteBuffer, error) {
+	a, b := src.Bytes(), dst.Bytes()
+
+	p := mbytes.NewByteBuffer(0)
+	p.Write([]byte(KIPS_MAGIC))
+
+	i := 0
+	for i < len(b) {
+		if i < len(a) && a[i] == b[i] {
+			i++
+			continue
+		}
+		start := i
+		if start == kipsEOFOffset {
+			// would read as the end marker, start one byte earlier
+			start--
+		}
+		for i < len(b) && i-start < KIPS_MAX_RECORD && (i >= len(a) || a[i] != b[i]) {
+			i++
+		}
+		if start > KIPS_MAX_OFFSET {
+			return nil, ErrTooLarge
+		}
+		writeIPSRecord(p, start, b[start:i])
+	}
+	p.Write([]byte(kipsEOF))
+
+	if len(b) < len(a) {
+		if len(b) > KIPS_MAX_OFFSET {
+			return nil, ErrTooLarge
+		}
+		p.Write(putBe24(len(b)))
+	}
+	p.SeekToStart()
+	return p, nil
+}
+
+// appends a record for data at off, run length encoded when data is a single
+// repeated byte
+func writeIPSRecord(p *mbytes.ByteBuffer, off int, data []byte) {
+	p.Write(putBe24(off))
+
+	rle := len(data) > 3
+	for i := 1; rle && i < len(data); i++ {
+		rle = data[i] == data[0]
+	}
+	if rle {
+		p.Write([]byte{0, 0, byte(len(data) >> 8), byte(len(data)), data[0]})
+		return
+	}
+	p.Write([]byte{byte(len(data) >> 8), byte(len(data))})
+	p.Write(data)
+}
+
+func be16(p []byte) int {
+	return int(p[0])<<8 | int(p[1])
+}
+
+func be24(p []byte) int {
+	return int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+}
+
+func putBe24(x int) []byte {
+	return []byte{byte(x >> 16), byte(x >> 8), byte(x)}
+}
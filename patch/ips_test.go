@@ -0,0 +1,109 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestApplyIPS(t *testing.T) {
+	tag := "ApplyIPS()"
+
+	p := []byte("PATCH")
+	// plain record at 2
+	p = append(p, 0, 0, 2, 0, 3, 'a', 'b', 'c')
+	// rle record at 8, 4 times 'z', grows the buffer
+	p = append(p, 0, 0, 8, 0, 0, 0, 4, 'z')
+	p = append(p, "EOF"...)
+
+	out, err := ApplyIPS(newBuffer([]byte("0123456789")), newBuffer(p))
+	if err != nil {
+		t.Fatalf(tag+" unexpected error: %v", err)
+	}
+	if string(out.Bytes()) != "01abc567zzzz" {
+		t.Fatalf(tag+" unexpected result [%v]", string(out.Bytes()))
+	}
+
+	// truncation extension
+	out, err = ApplyIPS(newBuffer([]byte("0123456789")), newBuffer(append(append([]byte{}, p...), 0, 0, 4)))
+	if err != nil {
+		t.Fatalf(tag+" unexpected error: %v", err)
+	}
+	if string(out.Bytes()) != "01ab" {
+		t.Fatalf(tag+" unexpected truncated result [%v]", string(out.Bytes()))
+	}
+
+	// every truncation of the patch is caught
+	for i := len("PATCH"); i < len(p); i++ {
+		_, err = ApplyIPS(newBuffer([]byte("0123456789")), newBuffer(p[:i]))
+		if err != ErrCorrupt {
+			t.Fatalf(tag+" truncated patch @%v, expected [%v], found [%v]", i, ErrCorrupt, errOrNilStr(err))
+		}
+	}
+	_, err = ApplyIPS(newBuffer(nil), newBuffer([]byte("UPS1")))
+	if err != ErrFormatUnknown {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrFormatUnknown, errOrNilStr(err))
+	}
+}
+
+func TestCreateIPS(t *testing.T) {
+	tag := "CreateIPS()"
+
+	// a change right at the offset spelling EOF must not end the patch early
+	src := make([]byte, kipsEOFOffset+16)
+	dst := append([]byte{}, src...)
+	dst[kipsEOFOffset] = 1
+	dst[kipsEOFOffset+1] = 2
+
+	p, err := CreateIPS(newBuffer(src), newBuffer(dst))
+	if err != nil {
+		t.Fatalf(tag+" unexpected error: %v", err)
+	}
+	out, err := ApplyIPS(newBuffer(src), p)
+	if err != nil {
+		t.Fatalf(tag+" unexpected apply error: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), dst) {
+		t.Fatal(tag + " result differs from target around the EOF offset")
+	}
+
+	// changes past 16M can't be expressed
+	big := make([]byte, KIPS_MAX_OFFSET+2)
+	changed := append([]byte{}, big...)
+	changed[KIPS_MAX_OFFSET+1] = 1
+	_, err = CreateIPS(newBuffer(big), newBuffer(changed))
+	if err != ErrTooLarge {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrTooLarge, errOrNilStr(err))
+	}
+
+	// a long run of one byte is run length encoded
+	p, _ = CreateIPS(newBuffer(make([]byte, 1000)), newBuffer(bytes.Repeat([]byte{7}, 1000)))
+	if p.Size() != uint(len("PATCH")+8+len("EOF")) {
+		t.Fatalf(tag+" run not encoded, patch size %v", p.Size())
+	}
+}
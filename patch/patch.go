@@ -0,0 +1,277 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/dorind/mbytes"
+)
+
+// returned when the patch format is not recognized
+var ErrFormatUnknown = errors.New("Unknown patch format")
+
+// returned when a patch is malformed or truncated
+var ErrCorrupt = errors.New("Corrupt patch")
+
+// returned when the patch checksum stored in the patch doesn't match the patch
+var ErrPatchChecksum = errors.New("Patch checksum mismatch")
+
+// returned when the source doesn't match the one the patch was made for
+var ErrSourceChecksum = errors.New("Source checksum mismatch")
+
+// returned when the patched result doesn't match the one the patch was made for
+var ErrTargetChecksum = errors.New("Target checksum mismatch")
+
+// returned when a format can't describe the change, e.g. IPS offsets past 16M
+var ErrTooLarge = errors.New("Change too large for patch format")
+
+type Format int
+
+const (
+	KFORMAT_UNKNOWN Format = iota
+	KFORMAT_IPS
+	KFORMAT_UPS
+	KFORMAT_BPS
+)
+
+// magic bytes of each format
+const (
+	KIPS_MAGIC = "PATCH"
+	KUPS_MAGIC = "UPS1"
+	KBPS_MAGIC = "BPS1"
+)
+
+func (f Format) String() string {
+	switch f {
+	case KFORMAT_IPS:
+		return "ips"
+	case KFORMAT_UPS:
+		return "ups"
+	case KFORMAT_BPS:
+		return "bps"
+	default:
+		return "unknown"
+	}
+}
+
+// returns the format of p judging by its magic bytes
+func Detect(p *mbytes.ByteBuffer) Format {
+	magic := make([]byte, len(KIPS_MAGIC))
+	n, _ := p.ReadAt(magic, 0)
+	switch {
+	case n >= len(KIPS_MAGIC) && string(magic) == KIPS_MAGIC:
+		return KFORMAT_IPS
+	case n >= len(KUPS_MAGIC) && string(magic[:len(KUPS_MAGIC)]) == KUPS_MAGIC:
+		return KFORMAT_UPS
+	case n >= len(KBPS_MAGIC) && string(magic[:len(KBPS_MAGIC)]) == KBPS_MAGIC:
+		return KFORMAT_BPS
+	}
+	return KFORMAT_UNKNOWN
+}
+
+// applies p to src and returns the result in a new buffer, src is untouched
+// errors:
+//	ErrFormatUnknown
+//	ErrCorrupt
+//	ErrPatchChecksum, UPS and BPS only
+//	ErrSourceChecksum, UPS and BPS only
+//	ErrTargetChecksum, UPS and BPS only
+func Apply(src, p *mbytes.ByteBuffer) (*mbytes.ByteBuffer, error) {
+	switch Detect(p) {
+	case KFORMAT_IPS:
+		return ApplyIPS(src, p)
+	case KFORMAT_UPS:
+		return ApplyUPS(src, p)
+	case KFORMAT_BPS:
+		return ApplyBPS(src, p)
+	}
+	return nil, ErrFormatUnknown
+}
+
+// applies p to b in place, growing or truncating it as needed
+// errors:
+//	@Apply
+// NOTE:
+//	- the patch and source are verified before b is modified, a target
+//		checksum error is reported after b has been patched
+//	- b keeps its position, clamped to the new size
+func ApplyInPlace(b, p *mbytes.ByteBuffer) error {
+	switch Detect(p) {
+	case KFORMAT_IPS:
+		return ApplyIPSInPlace(b, p)
+	case KFORMAT_UPS:
+		return ApplyUPSInPlace(b, p)
+	case KFORMAT_BPS:
+		return ApplyBPSInPlace(b, p)
+	}
+	return ErrFormatUnknown
+}
+
+// returns a patch in format f turning src into dst
+// errors:
+//	ErrFormatUnknown
+//	ErrTooLarge, IPS only
+func Create(f Format, src, dst *mbytes.ByteBuffer) (*mbytes.ByteBuffer, error) {
+	switch f {
+	case KFORMAT_IPS:
+		return CreateIPS(src, dst)
+	case KFORMAT_UPS:
+		return CreateUPS(src, dst), nil
+	case KFORMAT_BPS:
+		return CreateBPS(src, dst), nil
+	}
+	return nil, ErrFormatUnknown
+}
+
+// returns the crc32 (IEEE) of b without copying it
+func checksum(b *mbytes.ByteBuffer) uint32 {
+	h := crc32.NewIEEE()
+	io.Copy(h, b.NewCursor())
+	return h.Sum32()
+}
+
+// resizes b to size, new bytes are ZERO
+func resize(b *mbytes.ByteBuffer, size uint) error {
+	n := b.Size()
+	switch {
+	case size < n:
+		return b.Truncate(size)
+	case size == n:
+		return nil
+	case n == 0:
+		b.Reset(size)
+		return nil
+	}
+	// WriteAt must start inside the buffer, rewrite the last byte and append
+	last, err := b.ByteAt(int(n - 1))
+	if err != nil {
+		return err
+	}
+	p := make([]byte, size-n+1)
+	p[0] = last
+	_, err = b.WriteAt(p, int64(n-1))
+	return err
+}
+
+// writes p at off, growing b if needed
+func writeAt(b *mbytes.ByteBuffer, p []byte, off int) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if end := uint(off + len(p)); end > b.Size() {
+		if err := resize(b, end); err != nil {
+			return err
+		}
+	}
+	_, err := b.WriteAt(p, int64(off))
+	return err
+}
+
+// returns a new buffer holding p
+func newBuffer(p []byte) *mbytes.ByteBuffer {
+	b := mbytes.NewByteBuffer(0)
+	b.Write(p)
+	b.SeekToStart()
+	return b
+}
+
+// the footer of UPS and BPS patches, crc32 of source, target and patch
+type footer struct {
+	src, dst, patch uint32
+}
+
+// splits a UPS or BPS patch into its body and footer, verifying the patch
+// checksum
+func splitFooter(p []byte) ([]byte, footer, error) {
+	if len(p) < 12 {
+		return nil, footer{}, ErrCorrupt
+	}
+	body := p[:len(p)-12]
+	tail := p[len(p)-12:]
+	f := footer{
+		src:   le32(tail[0:]),
+		dst:   le32(tail[4:]),
+		patch: le32(tail[8:]),
+	}
+	if crc32.ChecksumIEEE(p[:len(p)-4]) != f.patch {
+		return nil, footer{}, ErrPatchChecksum
+	}
+	return body, f, nil
+}
+
+// appends the source and target checksums and the checksum of the patch
+func writeFooter(p *mbytes.ByteBuffer, src, dst uint32) {
+	put32 := func(x uint32) {
+		p.Write([]byte{byte(x), byte(x >> 8), byte(x >> 16), byte(x >> 24)})
+	}
+	put32(src)
+	put32(dst)
+	put32(checksum(p))
+	p.SeekToStart()
+}
+
+func le32(p []byte) uint32 {
+	return uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24
+}
+
+// UPS/BPS varint, every byte but the last has the high bit clear and the
+// encoding is bijective, i.e. each value has exactly one encoding
+func putVarint(p *mbytes.ByteBuffer, x uint64) {
+	for {
+		c := byte(x & 0x7f)
+		x >>= 7
+		if x == 0 {
+			p.WriteByte(0x80 | c)
+			return
+		}
+		p.WriteByte(c)
+		x--
+	}
+}
+
+// reads a UPS/BPS varint from p at *pos and moves *pos past it
+func readVarint(p []byte, pos *int) (uint64, error) {
+	var x uint64
+	shift := uint64(1)
+	for i := 0; i < 10; i++ {
+		if *pos >= len(p) {
+			return 0, ErrCorrupt
+		}
+		c := p[*pos]
+		*pos++
+		x += uint64(c&0x7f) * shift
+		if c&0x80 != 0 {
+			return x, nil
+		}
+		shift <<= 7
+		x += shift
+	}
+	return 0, ErrCorrupt
+}
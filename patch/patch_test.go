@@ -0,0 +1,210 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/dorind/mbytes"
+)
+
+func errOrNilStr(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "nil"
+}
+
+// returns src with a few random edits applied
+func mutate(r *rand.Rand, src []byte) []byte {
+	out := append([]byte{}, src...)
+	for i := r.Intn(6); i >= 0; i-- {
+		at := 0
+		if len(out) > 0 {
+			at = r.Intn(len(out))
+		}
+		switch r.Intn(4) {
+		case 0:
+			ins := make([]byte, r.Intn(40))
+			r.Read(ins)
+			out = append(out[:at], append(ins, out[at:]...)...)
+		case 1:
+			end := at + r.Intn(100)
+			if end > len(out) {
+				end = len(out)
+			}
+			out = append(out[:at], out[end:]...)
+		case 2:
+			for j := at; j < len(out) && j < at+r.Intn(300); j++ {
+				out[j] = 0x55
+			}
+		case 3:
+			tail := make([]byte, r.Intn(200))
+			r.Read(tail)
+			out = append(out, tail...)
+		}
+	}
+	return out
+}
+
+func TestDetect(t *testing.T) {
+	tag := "Detect()"
+
+	cases := map[string]Format{
+		"PATCHEOF":  KFORMAT_IPS,
+		"UPS1....":  KFORMAT_UPS,
+		"BPS1....":  KFORMAT_BPS,
+		"BPS":       KFORMAT_UNKNOWN,
+		"":          KFORMAT_UNKNOWN,
+		"something": KFORMAT_UNKNOWN,
+	}
+	for s, expected := range cases {
+		f := Detect(newBuffer([]byte(s)))
+		if f != expected {
+			t.Fatalf(tag+" unexpected format for [%v], expected %v, found %v", s, expected, f)
+		}
+	}
+
+	_, err := Apply(newBuffer(nil), newBuffer([]byte("nope")))
+	if err != ErrFormatUnknown {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrFormatUnknown, errOrNilStr(err))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tag := "Create/Apply(round trip)"
+
+	r := rand.New(rand.NewSource(46))
+	formats := []Format{KFORMAT_IPS, KFORMAT_UPS, KFORMAT_BPS}
+	for i := 0; i < 200; i++ {
+		src := make([]byte, r.Intn(4096))
+		r.Read(src)
+		dst := mutate(r, src)
+
+		for _, f := range formats {
+			p, err := Create(f, newBuffer(src), newBuffer(dst))
+			if err != nil {
+				t.Fatalf(tag+" %v unexpected create error @%v: %v", f, i, err)
+			}
+			if Detect(p) != f {
+				t.Fatalf(tag+" %v detected as %v @%v", f, Detect(p), i)
+			}
+
+			in := newBuffer(src)
+			out, err := Apply(in, p)
+			if err != nil {
+				t.Fatalf(tag+" %v unexpected apply error @%v: %v", f, i, err)
+			}
+			if !bytes.Equal(out.Bytes(), dst) {
+				t.Fatalf(tag+" %v result differs from target @%v", f, i)
+			}
+			if !bytes.Equal(in.Bytes(), src) {
+				t.Fatalf(tag+" %v Apply modified the source @%v", f, i)
+			}
+
+			in.SeekFromStart(int64(len(src) / 2))
+			err = ApplyInPlace(in, p)
+			if err != nil {
+				t.Fatalf(tag+" %v unexpected in place error @%v: %v", f, i, err)
+			}
+			if !bytes.Equal(in.Bytes(), dst) {
+				t.Fatalf(tag+" %v in place result differs from target @%v", f, i)
+			}
+			if expected := len(src) / 2; len(dst) > expected && in.Pos() != expected {
+				t.Fatalf(tag+" %v in place moved position @%v, expected %v, found %v", f, i, expected, in.Pos())
+			}
+		}
+	}
+}
+
+func TestVarint(t *testing.T) {
+	tag := "putVarint/readVarint()"
+
+	values := []uint64{0, 1, 127, 128, 129, 16383, 16384, 16511, 16512, 1 << 32, 1<<63 + 5}
+	b := mbytes.NewByteBuffer(0)
+	for _, v := range values {
+		putVarint(b, v)
+	}
+	p := b.Bytes()
+	pos := 0
+	for _, v := range values {
+		x, err := readVarint(p, &pos)
+		if err != nil || x != v {
+			t.Fatalf(tag+" unexpected value %v, expected %v, error [%v]", x, v, errOrNilStr(err))
+		}
+	}
+	if pos != len(p) {
+		t.Fatalf(tag+" unexpected position, expected %v, found %v", len(p), pos)
+	}
+
+	// 128 is the first two byte value
+	b = mbytes.NewByteBuffer(0)
+	putVarint(b, 128)
+	if !bytes.Equal(b.Bytes(), []byte{0x00, 0x80}) {
+		t.Fatalf(tag+" unexpected encoding of 128 %x", b.Bytes())
+	}
+
+	pos = 0
+	_, err := readVarint([]byte{0x00, 0x00}, &pos)
+	if err != ErrCorrupt {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrCorrupt, errOrNilStr(err))
+	}
+}
+
+func TestResize(t *testing.T) {
+	tag := "resize()"
+
+	b := mbytes.NewByteBuffer(0)
+	for _, size := range []uint{0, 5, 12, 3, 3, 0, 7} {
+		before := b.Bytes()
+		if err := resize(b, size); err != nil {
+			t.Fatalf(tag+" unexpected error: %v", err)
+		}
+		if b.Size() != size {
+			t.Fatalf(tag+" unexpected size, expected %v, found %v", size, b.Size())
+		}
+		after := b.Bytes()
+		n := len(before)
+		if n > len(after) {
+			n = len(after)
+		}
+		if !bytes.Equal(before[:n], after[:n]) {
+			t.Fatalf(tag+" contents changed [%x] -> [%x]", before, after)
+		}
+		for _, c := range after[n:] {
+			if c != 0 {
+				t.Fatalf(tag+" grown bytes not ZERO [%x]", after)
+			}
+		}
+		// mark the contents so growth is told apart from the old bytes
+		for i := range after {
+			b.WriteAt([]byte{byte(i + 1)}, int64(i))
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"github.com/dorind/mbytes"
+)
+
+// a parsed UPS hunk, xor applies at off
+type upsHunk struct {
+	off int
+	xor []byte
+}
+
+// a parsed UPS patch
+type upsPatch struct {
+	srcSize, dstSize uint64
+	foot             footer
+	hunks            []upsHunk
+}
+
+// parses and verifies the patch checksum of a UPS patch
+func parseUPS(p []byte) (*upsPatch, error) {
+	if len(p) < len(KUPS_MAGIC) || string(p[:len(KUPS_MAGIC)]) != KUPS_MAGIC {
+		return nil, ErrFormatUnknown
+	}
+	body, foot, err := splitFooter(p)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &upsPatch{foot: foot}
+	pos := len(KUPS_MAGIC)
+	if u.srcSize, err = readVarint(body, &pos); err != nil {
+		return nil, err
+	}
+	if u.dstSize, err = readVarint(body, &pos); err != nil {
+		return nil, err
+	}
+
+	// hunks can't reach past the larger of both sizes, the terminator may
+	// sit right at the end
+	limit := u.srcSize
+	if u.dstSize > limit {
+		limit = u.dstSize
+	}
+	off := uint64(0)
+	for pos < len(body) {
+		skip, err := readVarint(body, &pos)
+		if err != nil {
+			return nil, err
+		}
+		off += skip
+		end := pos
+		for end < len(body) && body[end] != 0 {
+			end++
+		}
+		if end == len(body) {
+			// missing terminator
+			return nil, ErrCorrupt
+		}
+		if off > limit || uint64(end-pos) > limit-off {
+			return nil, ErrCorrupt
+		}
+		u.hunks = append(u.hunks, upsHunk{off: int(off), xor: body[pos:end]})
+		off += uint64(end-pos) + 1
+		pos = end + 1
+	}
+	return u, nil
+}
+
+// @ApplyUPSInPlace into a clone of src, src is untouched
+func ApplyUPS(src, p *mbytes.ByteBuffer) (*mbytes.ByteBuffer, error) {
+	b := src.Clone()
+	if err := ApplyUPSInPlace(b, p); err != nil {
+		return nil, err
+	}
+	b.SeekToStart()
+	return b, nil
+}
+
+// applies UPS patch p to b, resizing b to the target size
+// errors:
+//	ErrFormatUnknown
+//	ErrCorrupt
+//	ErrPatchChecksum
+//	ErrSourceChecksum, b is neither the source nor the target of p
+//	ErrTargetChecksum
+// NOTE:
+//	- UPS patches are reversible, applying p to its own target restores the
+//		source
+//	- the patch and b are verified before b is modified
+func ApplyUPSInPlace(b, p *mbytes.ByteBuffer) error {
+	u, err := parseUPS(p.Bytes())
+	if err != nil {
+		return err
+	}
+
+	size, sum := uint64(b.Size()), checksum(b)
+	var outSize uint64
+	var outSum uint32
+	switch {
+	case size == u.srcSize && sum == u.foot.src:
+		outSize, outSum = u.dstSize, u.foot.dst
+	case size == u.dstSize && sum == u.foot.dst:
+		outSize, outSum = u.srcSize, u.foot.src
+	default:
+		return ErrSourceChecksum
+	}
+
+	mk := b.Mark()
+	defer b.Restore(mk)
+
+	// bytes past the end of b read as ZERO, grow first and shrink last
+	if outSize > size {
+		if err := resize(b, uint(outSize)); err != nil {
+			return err
+		}
+	}
+	for _, h := range u.hunks {
+		l := len(h.xor)
+		if h.off+l > int(b.Size()) {
+			l = int(b.Size()) - h.off
+		}
+		if l <= 0 {
+			continue
+		}
+		buf := make([]byte, l)
+		if _, err := b.ReadAt(buf, int64(h.off)); err != nil {
+			return err
+		}
+		for i := range buf {
+			buf[i] ^= h.xor[i]
+		}
+		if _, err := b.WriteAt(buf, int64(h.off)); err != nil {
+			return err
+		}
+	}
+	if err := resize(b, uint(outSize)); err != nil {
+		return err
+	}
+
+	if checksum(b) != outSum {
+		return ErrTargetChecksum
+	}
+	return nil
+}
+
+// returns a UPS patch turning src into dst
+// NOTE:
+//	- the patch is reversible, see ApplyUPSInPlace
+func CreateUPS(src, dst *mbytes.ByteBuffer) *mbytes.ByteBuffer {
+	a, b := src.Bytes(), dst.Bytes()
+
+	p := mbytes.NewByteBuffer(0)
+	p.Write([]byte(KUPS_MAGIC))
+	putVarint(p, uint64(len(a)))
+	putVarint(p, uint64(len(b)))
+
+	at := func(s []byte, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	off := 0
+	for i := 0; i < n; i++ {
+		if at(a, i) == at(b, i) {
+			continue
+		}
+		putVarint(p, uint64(i-off))
+		for ; i < n && at(a, i) != at(b, i); i++ {
+			p.WriteByte(at(a, i) ^ at(b, i))
+		}
+		// the terminator covers the next, unchanged, byte
+		p.WriteByte(0)
+		off = i + 1
+	}
+
+	writeFooter(p, checksum(src), checksum(dst))
+	return p
+}
@@ -0,0 +1,75 @@
+package patch
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"testing"
+)
+
+func TestApplyUPS(t *testing.T) {
+	tag := "ApplyUPS()"
+
+	src := newBuffer([]byte("the quick brown fox"))
+	dst := newBuffer([]byte("the quick red fox jumps"))
+	p := CreateUPS(src, dst)
+
+	out, err := ApplyUPS(src, p)
+	if err != nil || out.CmpWith(dst) != 0 {
+		t.Fatalf(tag+" unexpected result [%v], error [%v]", string(out.Bytes()), errOrNilStr(err))
+	}
+
+	// reversible, the target turns back into the source
+	back, err := ApplyUPS(dst, p)
+	if err != nil || back.CmpWith(src) != 0 {
+		t.Fatalf(tag+" unexpected reverse result [%v], error [%v]", string(back.Bytes()), errOrNilStr(err))
+	}
+
+	_, err = ApplyUPS(newBuffer([]byte("the quick brown cat")), p)
+	if err != ErrSourceChecksum {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrSourceChecksum, errOrNilStr(err))
+	}
+
+	// any flipped byte breaks the patch checksum
+	raw := p.Bytes()
+	for i := len(KUPS_MAGIC); i < len(raw); i++ {
+		bad := append([]byte{}, raw...)
+		bad[i] ^= 0x40
+		b := newBuffer(src.Bytes())
+		err = ApplyUPSInPlace(b, newBuffer(bad))
+		if err != ErrPatchChecksum {
+			t.Fatalf(tag+" flipped byte @%v, expected [%v], found [%v]", i, ErrPatchChecksum, errOrNilStr(err))
+		}
+		if b.CmpWith(src) != 0 {
+			t.Fatalf(tag+" buffer modified by a rejected patch @%v", i)
+		}
+	}
+
+	_, err = ApplyUPS(src, newBuffer([]byte("UPS1")))
+	if err != ErrCorrupt {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrCorrupt, errOrNilStr(err))
+	}
+}
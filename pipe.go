@@ -0,0 +1,297 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// default high-water mark of BufferedPipe
+const KPIPE_HIGH_WATER = 64 * 1024
+
+// an in-memory pipe with a buffer in between its ends, unlike io.Pipe writers
+// only block once the buffer reaches the high-water mark
+// readers block while the buffer is empty
+// safe for use by multiple goroutines
+// implemented interfaces
+//  io.Reader
+//  io.Writer
+//  io.Closer
+type BufferedPipe struct {
+	mu   sync.Mutex
+	buf  *QueueBuffer
+	high int
+	// returned to readers once the buffer is drained, set by CloseWithError
+	rerr error
+	// returned to writers, set by CloseWithError and CloseRead
+	werr error
+	// closed and replaced on every state change, wakes up all waiters
+	changed   chan struct{}
+	rdeadline time.Time
+	wdeadline time.Time
+}
+
+// create a new BufferedPipe buffering up to (highWater) bytes
+// NOTE:
+//	- passing ZERO for highWater selects KPIPE_HIGH_WATER
+func NewBufferedPipe(highWater uint) *BufferedPipe {
+	if highWater == 0 {
+		highWater = KPIPE_HIGH_WATER
+	}
+	return &BufferedPipe{
+		buf:     NewQueueBuffer(0),
+		high:    int(highWater),
+		changed: make(chan struct{}),
+	}
+}
+
+// wakes up all waiters, must be called with lock held
+func (m *BufferedPipe) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// waits for a state change, the deadline or ctx, whichever comes first
+// must be called with lock held, returns with lock held
+func (m *BufferedPipe) wait(ctx context.Context, deadline time.Time) error {
+	ch := m.changed
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	m.mu.Unlock()
+	defer m.mu.Lock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// returns the number of buffered bytes
+func (m *BufferedPipe) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.Len()
+}
+
+// @BufferedPipe.ReadContext(context.Background(), p)
+func (m *BufferedPipe) Read(p []byte) (int, error) {
+	return m.ReadContext(context.Background(), p)
+}
+
+// reads up to len(p) buffered bytes, blocks while the buffer is empty
+// returns number of read bytes
+// errors:
+//	io.EOF, after Close and once the buffer is drained
+//	io.ErrClosedPipe, after CloseRead
+//	os.ErrDeadlineExceeded
+//	ctx.Err()
+//	any error passed to CloseWithError, once the buffer is drained
+func (m *BufferedPipe) ReadContext(ctx context.Context, p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		if m.buf.Len() > 0 {
+			n, _ := m.buf.Read(p)
+			m.notify()
+			return n, nil
+		}
+		if m.rerr != nil {
+			return 0, m.rerr
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+		err := m.wait(ctx, m.rdeadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// @BufferedPipe.WriteContext(context.Background(), p)
+func (m *BufferedPipe) Write(p []byte) (int, error) {
+	return m.WriteContext(context.Background(), p)
+}
+
+// writes p to the buffer, blocks while the buffer is at the high-water mark
+// returns number of written bytes, which is less than len(p) only on error
+// errors:
+//	io.ErrClosedPipe, after Close, CloseWithError or CloseRead
+//	os.ErrDeadlineExceeded
+//	ctx.Err()
+func (m *BufferedPipe) WriteContext(ctx context.Context, p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	written := 0
+	for {
+		if m.werr != nil {
+			return written, m.werr
+		}
+		if room := m.high - m.buf.Len(); room > 0 {
+			n := min_int(room, len(p)-written)
+			m.buf.Write(p[written : written+n])
+			written += n
+			m.notify()
+		}
+		if written == len(p) {
+			return written, nil
+		}
+		err := m.wait(ctx, m.wdeadline)
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// returns the number of bytes written or error, @BufferedPipe.Write
+func (m *BufferedPipe) WriteUInt64Var(x uint64) (int, error) {
+	buff := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buff, x)
+	return m.Write(buff[:n])
+}
+
+// reads and returns an uint64s or error, blocks until the whole varint is
+// buffered
+// errors:
+//	io.ErrUnexpectedEOF, the pipe was closed in the middle of a varint
+//	io.ErrShortBuffer, the high-water mark is too low to hold the varint
+//	ErrVarintOverflow
+//	any other error returned by Read
+// NOTE:
+//	- on error nothing is consumed, after a deadline the next call reads the
+//		varint whole once the rest of it has been written
+func (m *BufferedPipe) ReadUInt64Var() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		x, err := m.buf.ReadUInt64Var()
+		if err == nil {
+			m.notify()
+			return x, nil
+		}
+		if err == ErrVarintOverflow {
+			return 0, err
+		}
+		if m.rerr != nil {
+			if m.rerr == io.EOF && m.buf.Len() > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, m.rerr
+		}
+		if m.buf.Len() >= m.high {
+			// writers wait for room that will never come
+			return 0, io.ErrShortBuffer
+		}
+		err = m.wait(context.Background(), m.rdeadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// @BufferedPipe.CloseWithError(nil)
+func (m *BufferedPipe) Close() error {
+	return m.CloseWithError(nil)
+}
+
+// closes the write end, readers drain the buffer then get err, or io.EOF if
+// err is nil, writers get io.ErrClosedPipe
+// NOTE:
+//	- only the first close is effective, later ones are ignored
+func (m *BufferedPipe) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rerr == nil {
+		m.rerr = err
+	}
+	if m.werr == nil {
+		m.werr = io.ErrClosedPipe
+	}
+	m.notify()
+	return nil
+}
+
+// closes the read end, buffered data is dropped, readers and writers get
+// io.ErrClosedPipe
+func (m *BufferedPipe) CloseRead() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buf.Reset()
+	m.rerr = io.ErrClosedPipe
+	m.werr = io.ErrClosedPipe
+	m.notify()
+	return nil
+}
+
+// sets the deadline for pending and future reads, a zero value means no deadline
+func (m *BufferedPipe) SetReadDeadline(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rdeadline = t
+	m.notify()
+	return nil
+}
+
+// sets the deadline for pending and future writes, a zero value means no deadline
+func (m *BufferedPipe) SetWriteDeadline(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.wdeadline = t
+	m.notify()
+	return nil
+}
+
+// sets both read and write deadlines
+func (m *BufferedPipe) SetDeadline(t time.Time) error {
+	m.SetReadDeadline(t)
+	return m.SetWriteDeadline(t)
+}
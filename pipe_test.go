@@ -0,0 +1,213 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBufferedPipeCopy(t *testing.T) {
+	tag := "BufferedPipe(copy)"
+
+	p := NewBufferedPipe(16)
+	src := make([]byte, 10000)
+	for i := range src {
+		src[i] = byte(i)
+	}
+
+	go func() {
+		// many small writes and a large one, writers block at 16 bytes
+		for i := 0; i < 100; i++ {
+			p.Write(src[i*10 : i*10+10])
+			if p.Len() > 16 {
+				t.Errorf(tag+" high-water mark exceeded, %v bytes buffered", p.Len())
+			}
+		}
+		p.Write(src[1000:])
+		p.Close()
+	}()
+
+	dst := NewByteBuffer(0)
+	n, err := io.Copy(dst, p)
+	if err != nil {
+		t.Fatalf(tag+" unexpected error in io.Copy: %v", err.Error())
+	}
+	if n != int64(len(src)) || bytes.Compare(src, dst.Bytes()) != 0 {
+		t.Fatalf(tag+" data mismatch, copied %v bytes", n)
+	}
+
+	_, err = p.Write([]byte{1})
+	if err != io.ErrClosedPipe {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrClosedPipe.Error(), errOrNilStr(err))
+	}
+}
+
+func TestBufferedPipeClose(t *testing.T) {
+	tag := "BufferedPipe.Close()"
+
+	p := NewBufferedPipe(0)
+	failure := errors.New("failure")
+	p.Write([]byte("abra"))
+	p.CloseWithError(failure)
+
+	// buffered data first, then the error
+	rbuff := make([]byte, 8)
+	n, err := p.Read(rbuff)
+	if err != nil || string(rbuff[:n]) != "abra" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+	_, err = p.Read(rbuff)
+	if err != failure {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", failure.Error(), errOrNilStr(err))
+	}
+
+	// a closed read end releases blocked writers
+	p = NewBufferedPipe(2)
+	done := make(chan error)
+	go func() {
+		_, err := p.Write([]byte("abracadabra"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.CloseRead()
+	err = <-done
+	if err != io.ErrClosedPipe {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrClosedPipe.Error(), errOrNilStr(err))
+	}
+}
+
+func TestBufferedPipeDeadline(t *testing.T) {
+	tag := "BufferedPipe(deadline)"
+
+	p := NewBufferedPipe(4)
+	rbuff := make([]byte, 8)
+
+	p.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := p.Read(rbuff)
+	if err != os.ErrDeadlineExceeded {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", os.ErrDeadlineExceeded.Error(), errOrNilStr(err))
+	}
+
+	p.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	n, err := p.Write([]byte("abracadabra"))
+	if err != os.ErrDeadlineExceeded {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", os.ErrDeadlineExceeded.Error(), errOrNilStr(err))
+	}
+	if n != 4 {
+		t.Fatalf(tag+" unexpected write size, expected 4, found %v", n)
+	}
+
+	// clearing the deadline lets a blocked reader wait for data
+	p.SetDeadline(time.Time{})
+	p.Read(rbuff)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Write([]byte("xyz"))
+	}()
+	n, err = p.Read(rbuff)
+	if err != nil || string(rbuff[:n]) != "xyz" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+}
+
+func TestBufferedPipeContext(t *testing.T) {
+	tag := "BufferedPipe(context)"
+
+	p := NewBufferedPipe(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := p.ReadContext(ctx, make([]byte, 1))
+	if err != context.Canceled {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", context.Canceled.Error(), errOrNilStr(err))
+	}
+
+	_, err = p.WriteContext(ctx, make([]byte, KPIPE_HIGH_WATER+1))
+	if err != context.Canceled {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", context.Canceled.Error(), errOrNilStr(err))
+	}
+}
+
+func TestBufferedPipeUInt64VarTruncated(t *testing.T) {
+	tag := "BufferedPipe.ReadUInt64Var(truncated)"
+
+	p := NewBufferedPipe(0)
+	p.Write([]byte{0x80, 0x80})
+
+	// a deadline in the middle of a varint consumes nothing
+	p.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := p.ReadUInt64Var()
+	if err != os.ErrDeadlineExceeded {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", os.ErrDeadlineExceeded.Error(), errOrNilStr(err))
+	}
+	if p.Len() != 2 {
+		t.Fatalf(tag+" unexpected length after failed read, expected 2, found %v", p.Len())
+	}
+
+	// a blocked reader gets the varint once the rest is written
+	p.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Write([]byte{0x01})
+	}()
+	x, err := p.ReadUInt64Var()
+	if err != nil || x != 1<<14 {
+		t.Fatalf(tag+" unexpected read value, expected %v, found %v %v", 1<<14, x, errOrNilStr(err))
+	}
+
+	// closing in the middle of a varint
+	p.WriteUInt64Var(1 << 40)
+	p.Write([]byte{0x80})
+	p.Close()
+	x, err = p.ReadUInt64Var()
+	if err != nil || x != 1<<40 {
+		t.Fatalf(tag+" unexpected read value, expected %v, found %v %v", uint64(1<<40), x, errOrNilStr(err))
+	}
+	_, err = p.ReadUInt64Var()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrUnexpectedEOF.Error(), errOrNilStr(err))
+	}
+	if p.Len() != 1 {
+		t.Fatalf(tag+" unexpected length after failed read, expected 1, found %v", p.Len())
+	}
+
+	// a varint larger than the high-water mark can never be read
+	p = NewBufferedPipe(2)
+	p.Write([]byte{0x80, 0x80})
+	_, err = p.ReadUInt64Var()
+	if err != io.ErrShortBuffer {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrShortBuffer.Error(), errOrNilStr(err))
+	}
+}
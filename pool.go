@@ -0,0 +1,215 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"math/bits"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// smallest size class of Pool, as a power of two (64 bytes)
+const KPOOL_MIN_CLASS = 6
+
+// default capacity above which Pool drops returned buffers instead of
+// retaining them
+const KPOOL_MAX_RETAIN = 1 << 20
+
+// Pool hit/miss statistics
+type PoolStats struct {
+	// Get calls served by a pooled buffer
+	Hits uint64
+	// Get calls which had to allocate
+	Misses uint64
+	// Put calls which retained the buffer
+	Puts uint64
+	// Put calls which dropped the buffer, too large or too small
+	Drops uint64
+}
+
+// a pool of ByteBuffers with power-of-two size classes, built on sync.Pool
+// safe for use by multiple goroutines
+type Pool struct {
+	classes   []sync.Pool
+	maxRetain int
+	stats     PoolStats
+
+	// debug mode, see NewDebugPool
+	debug       bool
+	mu          sync.Mutex
+	outstanding map[*ByteBuffer][]byte
+}
+
+// create a new Pool which retains buffers with a capacity of up to
+// (maxRetain) bytes
+// NOTE:
+//	- passing ZERO for maxRetain selects KPOOL_MAX_RETAIN
+func NewPool(maxRetain uint) *Pool {
+	if maxRetain == 0 {
+		maxRetain = KPOOL_MAX_RETAIN
+	}
+	// one class per power of two in between the smallest class and maxRetain
+	n := max_int(bits.Len(maxRetain)-KPOOL_MIN_CLASS, 1)
+	return &Pool{
+		classes:   make([]sync.Pool, n),
+		maxRetain: int(maxRetain),
+	}
+}
+
+// create a new Pool in debug mode, meant for tests
+// NOTE:
+//	- buffers are never reused, Get always allocates
+//	- Put poisons the buffer, any further Read/Write/Seek on it panics with the
+//		stack of the Put call that released it, so does a second Put
+//	- Leaks reports buffers obtained through Get and never returned
+func NewDebugPool(maxRetain uint) *Pool {
+	m := NewPool(maxRetain)
+	m.debug = true
+	m.outstanding = make(map[*ByteBuffer][]byte)
+	return m
+}
+
+// returns the allocation stacks of buffers obtained through Get and not yet
+// returned through Put, always empty unless in debug mode
+// NOTE:
+//	- usually called at the end of a test, a non-empty result is a leak
+func (m *Pool) Leaks() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := make([]string, 0, len(m.outstanding))
+	for _, stack := range m.outstanding {
+		r = append(r, string(stack))
+	}
+	return r
+}
+
+// panics if b was released by a debug Pool
+func (m *ByteBuffer) checkReleased() {
+	if m.released != nil {
+		panic("mbytes: use of ByteBuffer after Pool.Put, released at:\n" + string(m.released))
+	}
+}
+
+// drops everything tied to the current user of the buffer, so the next one
+// starts clean: open transactions, snapshots and bookmarks
+// NOTE:
+//	- live snapshots copy the pages they still share and keep working, the
+//		backing array is about to be reused
+func (m *ByteBuffer) dropUserState() {
+	if len(m.snaps) > 0 {
+		m.protect(0, cap(m.buff))
+		for _, s := range m.snaps {
+			s.detach()
+		}
+		m.snaps = nil
+	}
+	m.txs = nil
+	m.txlog = nil
+	m.marks = nil
+}
+
+// returns the index of the smallest class able to hold size bytes
+func poolClassFor(size int) int {
+	if size <= 1<<KPOOL_MIN_CLASS {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - KPOOL_MIN_CLASS
+}
+
+// returns an empty ByteBuffer with a capacity of at least (sizeHint) bytes
+// NOTE:
+//	- the buffer has ZERO size, it grows into its capacity on write
+func (m *Pool) Get(sizeHint uint) *ByteBuffer {
+	if m.debug {
+		atomic.AddUint64(&m.stats.Misses, 1)
+		b := &ByteBuffer{buff: make([]byte, 0, sizeHint)}
+		m.mu.Lock()
+		m.outstanding[b] = debug.Stack()
+		m.mu.Unlock()
+		return b
+	}
+
+	idx := poolClassFor(int(sizeHint))
+	if idx < len(m.classes) {
+		if v := m.classes[idx].Get(); v != nil {
+			atomic.AddUint64(&m.stats.Hits, 1)
+			return v.(*ByteBuffer).Reset(0)
+		}
+	}
+	atomic.AddUint64(&m.stats.Misses, 1)
+
+	// round up to the class size, so the buffer is reusable by the same class
+	size := int(sizeHint)
+	if idx < len(m.classes) {
+		size = 1 << (idx + KPOOL_MIN_CLASS)
+	}
+	return &ByteBuffer{buff: make([]byte, 0, size)}
+}
+
+// returns b to the pool, b must not be used afterwards
+// open transactions are abandoned, snapshots are detached and bookmarks are
+// dropped, a buffer handed out by Get carries none of them
+// buffers with a capacity larger than the retain limit are dropped and left
+// to the garbage collector
+func (m *Pool) Put(b *ByteBuffer) {
+	if m.debug {
+		b.checkReleased()
+		m.mu.Lock()
+		delete(m.outstanding, b)
+		m.mu.Unlock()
+		b.dropUserState()
+		b.buff = nil
+		b.pos = 0
+		b.released = debug.Stack()
+		atomic.AddUint64(&m.stats.Drops, 1)
+		return
+	}
+
+	b.dropUserState()
+	c := cap(b.buff)
+	if c > m.maxRetain || c < 1<<KPOOL_MIN_CLASS {
+		atomic.AddUint64(&m.stats.Drops, 1)
+		return
+	}
+
+	// a buffer serves the largest class it can fully hold
+	idx := min_int(bits.Len(uint(c))-1-KPOOL_MIN_CLASS, len(m.classes)-1)
+	b.Reset(0)
+	m.classes[idx].Put(b)
+	atomic.AddUint64(&m.stats.Puts, 1)
+}
+
+// returns a snapshot of pool statistics
+func (m *Pool) Stats() PoolStats {
+	return PoolStats{
+		Hits:   atomic.LoadUint64(&m.stats.Hits),
+		Misses: atomic.LoadUint64(&m.stats.Misses),
+		Puts:   atomic.LoadUint64(&m.stats.Puts),
+		Drops:  atomic.LoadUint64(&m.stats.Drops),
+	}
+}
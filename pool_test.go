@@ -0,0 +1,171 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPoolGetPut(t *testing.T) {
+	tag := "Pool.Get/Put()"
+
+	p := NewPool(4096)
+
+	test_sizes := []uint{0, 1, 63, 64, 65, 1000, 4096, 5000}
+	for i := 0; i < len(test_sizes); i++ {
+		hint := test_sizes[i]
+		b := p.Get(hint)
+		if b.Size() != 0 || b.Pos() != 0 {
+			t.Fatalf(tag+" expected empty buffer, found size %v, position %v", b.Size(), b.Pos())
+		}
+		if b.Cap() < hint {
+			t.Fatalf(tag+" capacity error, expected at least %v, found %v", hint, b.Cap())
+		}
+		b.Write(make([]byte, hint))
+		p.Put(b)
+	}
+
+	stats := p.Stats()
+	if stats.Hits+stats.Misses != uint64(len(test_sizes)) {
+		t.Fatalf(tag+" unexpected hits+misses, expected %v, found %+v", len(test_sizes), stats)
+	}
+	// 5000 rounds up past the retain limit
+	if stats.Drops != 1 {
+		t.Fatalf(tag+" unexpected drops, expected 1, found %+v", stats)
+	}
+	if stats.Puts != uint64(len(test_sizes))-1 {
+		t.Fatalf(tag+" unexpected puts, expected %v, found %+v", len(test_sizes)-1, stats)
+	}
+
+	// pooled buffers come back empty
+	for i := 0; i < 100; i++ {
+		b := p.Get(100)
+		if b.Size() != 0 || b.Cap() < 100 {
+			t.Fatalf(tag+" unexpected buffer, size %v, capacity %v", b.Size(), b.Cap())
+		}
+		b.Write([]byte("abracadabra"))
+		p.Put(b)
+	}
+	stats = p.Stats()
+	if stats.Hits == 0 {
+		t.Fatalf(tag+" expected pool hits, found %+v", stats)
+	}
+}
+
+func TestDebugPool(t *testing.T) {
+	tag := "Pool(debug)"
+
+	p := NewDebugPool(0)
+
+	leaked := p.Get(16)
+	leaked.Write([]byte("abracadabra"))
+
+	b := p.Get(16)
+	b.Write([]byte("abracadabra"))
+	p.Put(b)
+
+	leaks := p.Leaks()
+	if len(leaks) != 1 {
+		t.Fatalf(tag+" unexpected number of leaks, expected 1, found %v", len(leaks))
+	}
+	if !strings.Contains(leaks[0], "TestDebugPool") {
+		t.Fatalf(tag+" allocation stack does not mention the caller:\n%v", leaks[0])
+	}
+
+	// every access to a released buffer panics with the release stack
+	uses := []func(){
+		func() { b.Read(make([]byte, 1)) },
+		func() { b.Write([]byte{1}) },
+		func() { b.SeekToStart() },
+		func() { b.ByteAt(0) },
+		func() { p.Put(b) },
+	}
+	for i, use := range uses {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Fatalf(tag+" use #%v of released buffer did not panic", i)
+				}
+				if !strings.Contains(r.(string), "TestDebugPool") {
+					t.Fatalf(tag+" panic does not carry the release stack:\n%v", r)
+				}
+			}()
+			use()
+		}()
+	}
+
+	p.Put(leaked)
+	if len(p.Leaks()) != 0 {
+		t.Fatalf(tag+" unexpected leaks after Put: %v", p.Leaks())
+	}
+}
+
+func TestPoolPutState(t *testing.T) {
+	tag := "Pool.Put(state)"
+
+	// sync.Pool may hand out another buffer, so the returned one is checked,
+	// as the next user of it would see it
+	p := NewPool(4096)
+
+	// an open transaction does not survive Put
+	b := p.Get(100)
+	b.Write([]byte("abracadabra"))
+	tx := b.Begin()
+	b.WriteAt([]byte("x"), 0)
+	p.Put(b)
+	if b.TxDepth() != 0 || len(b.txlog) != 0 {
+		t.Fatalf(tag+" transaction leaked, depth %v, log %v", b.TxDepth(), len(b.txlog))
+	}
+	if tx.Open() {
+		t.Fatal(tag + " transaction still open")
+	}
+
+	// a snapshot keeps its contents once the array is reused
+	b = p.Get(100)
+	b.Write([]byte("abracadabra"))
+	s := b.Snapshot()
+	p.Put(b)
+	if len(b.snaps) != 0 {
+		t.Fatalf(tag+" snapshots leaked, %v tracked", len(b.snaps))
+	}
+	b.Reset(0).Write([]byte("hocuspocus!"))
+	if string(s.Bytes()) != "abracadabra" {
+		t.Fatalf(tag+" snapshot modified by the next user [%v]", string(s.Bytes()))
+	}
+	s.Release()
+
+	// bookmarks are dropped
+	b = p.Get(100)
+	b.Write([]byte("abracadabra"))
+	b.SetBookmark("here")
+	p.Put(b)
+	if _, ok := b.Bookmark("here"); ok {
+		t.Fatal(tag + " bookmark leaked")
+	}
+}
@@ -0,0 +1,222 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// default number of consumed bytes after which QueueBuffer considers compacting
+const KQUEUE_COMPACT_THRESHOLD = 4096
+
+// a byte buffer with queue semantics, much like bytes.Buffer
+// writes always append, reads consume, consumed bytes are dropped by an
+// amortized compaction so long-lived stream buffers don't grow forever
+// position is the start of unread data in the internal buffer
+// implemented interfaces
+//  io.Reader
+//  io.Writer
+//  io.WriterTo
+//	io.ByteReader
+//	io.ByteWriter
+type QueueBuffer struct {
+	buff      []byte
+	pos       int
+	threshold int
+}
+
+// create a new empty QueueBuffer, compaction kicks in once at least
+// (threshold) bytes have been consumed and they make up at least half of the
+// internal buffer
+// NOTE:
+//	- passing ZERO for threshold selects KQUEUE_COMPACT_THRESHOLD
+func NewQueueBuffer(threshold uint) *QueueBuffer {
+	if threshold == 0 {
+		threshold = KQUEUE_COMPACT_THRESHOLD
+	}
+	return &QueueBuffer{buff: []byte{}, threshold: int(threshold)}
+}
+
+// drops all data, the internal buffer is kept for reuse
+func (m *QueueBuffer) Reset() *QueueBuffer {
+	m.buff = m.buff[:0]
+	m.pos = 0
+	return m
+}
+
+// returns the number of unread bytes
+func (m *QueueBuffer) Len() int {
+	return len(m.buff) - m.pos
+}
+
+// returns the number of bytes that can be written without growing the
+// internal buffer
+func (m *QueueBuffer) Available() int {
+	return cap(m.buff) - len(m.buff)
+}
+
+// returns true if there's no unread data
+func (m *QueueBuffer) Empty() bool {
+	return m.Len() == 0
+}
+
+// returns position of unread data within the internal buffer, which is the
+// number of consumed bytes not yet dropped by compaction
+func (m *QueueBuffer) Pos() int {
+	return m.pos
+}
+
+// returns a copy of the unread data
+func (m *QueueBuffer) Bytes() []byte {
+	r := make([]byte, m.Len())
+	copy(r, m.buff[m.pos:])
+	return r
+}
+
+// drops consumed bytes right away, regardless of threshold
+func (m *QueueBuffer) Compact() {
+	if m.pos == 0 {
+		return
+	}
+	n := copy(m.buff, m.buff[m.pos:])
+	m.buff = m.buff[:n]
+	m.pos = 0
+}
+
+// called after every read, compaction cost is proportional to the unread
+// data, which is at most the amount consumed since the previous compaction
+func (m *QueueBuffer) consumed() {
+	if m.pos == len(m.buff) {
+		// everything read, start over for free
+		m.buff = m.buff[:0]
+		m.pos = 0
+		return
+	}
+	if m.pos >= m.threshold && m.pos >= m.Len() {
+		m.Compact()
+	}
+}
+
+// returns a slice holding the next n unread bytes, or all of them if there
+// are fewer, and consumes them
+// NOTE:
+//	- slice shares memory with the buffer and is valid only until the next
+//		read or write
+func (m *QueueBuffer) Next(n int) []byte {
+	n = min_int(max_int(n, 0), m.Len())
+	r := m.buff[m.pos : m.pos+n : m.pos+n]
+	// compacting now would overwrite r, leave it for the next read or write
+	m.pos += n
+	return r
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+func (m *QueueBuffer) Read(p []byte) (n int, err error) {
+	if m.Empty() {
+		m.consumed()
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n = copy(p, m.buff[m.pos:])
+	m.pos += n
+	m.consumed()
+	return n, nil
+}
+
+// io.ByteReader implementation
+func (m *QueueBuffer) ReadByte() (byte, error) {
+	if m.Empty() {
+		return 0, io.EOF
+	}
+	c := m.buff[m.pos]
+	m.pos++
+	m.consumed()
+	return c, nil
+}
+
+// io.Writer implementation, appends p to buffer
+// NOTE: this function will never return an error, in case we're out of memory
+// a panic will most likely occur
+func (m *QueueBuffer) Write(p []byte) (n int, err error) {
+	m.grow(len(p))
+	m.buff = append(m.buff, p...)
+	return len(p), nil
+}
+
+// io.ByteWriter implementation, appends c to buffer
+func (m *QueueBuffer) WriteByte(c byte) error {
+	m.grow(1)
+	m.buff = append(m.buff, c)
+	return nil
+}
+
+// compacts instead of reallocating when consumed bytes make room for n more
+func (m *QueueBuffer) grow(n int) {
+	if m.pos > 0 && len(m.buff)+n > cap(m.buff) && m.Len()+n <= cap(m.buff) {
+		m.Compact()
+	}
+}
+
+// io.WriterTo implementation, consumes all unread data
+func (m *QueueBuffer) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m.buff[m.pos:])
+	if n > 0 {
+		m.pos += n
+		m.consumed()
+	}
+	return int64(n), err
+}
+
+// returns the number of bytes written or error
+func (m *QueueBuffer) WriteUInt64Var(x uint64) (int, error) {
+	buff := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buff, x)
+	return m.Write(buff[:n])
+}
+
+// reads and returns an uint64s or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, the varint is truncated
+//	ErrVarintOverflow
+// NOTE:
+//	- on error nothing is consumed, @ByteBuffer.ReadUInt64Var
+func (m *QueueBuffer) ReadUInt64Var() (uint64, error) {
+	x, n, err := peek_uvarint(m.buff, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	m.consumed()
+	return x, nil
+}
@@ -0,0 +1,164 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"io"
+	"testing"
+)
+
+func TestQueueBufferReadWrite(t *testing.T) {
+	tag := "QueueBuffer.Read/Write()"
+
+	b := NewQueueBuffer(0)
+	s := "abracadabra"
+	b.Write([]byte(s))
+	if b.Len() != len(s) {
+		t.Fatalf(tag+" unexpected length, expected %v, found %v", len(s), b.Len())
+	}
+
+	rbuff := make([]byte, 4)
+	n, err := b.Read(rbuff)
+	if err != nil || string(rbuff[:n]) != "abra" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+	if b.Pos() != 4 || b.Len() != len(s)-4 {
+		t.Fatalf(tag+" unexpected position/length %v/%v", b.Pos(), b.Len())
+	}
+
+	c, err := b.ReadByte()
+	if err != nil || c != 'c' {
+		t.Fatalf(tag+" unexpected read byte %v %v", c, errOrNilStr(err))
+	}
+
+	next := b.Next(3)
+	if string(next) != "ada" {
+		t.Fatalf(tag+" unexpected Next() result [%v]", string(next))
+	}
+	if string(b.Bytes()) != "bra" {
+		t.Fatalf(tag+" unexpected unread data [%v]", string(b.Bytes()))
+	}
+
+	// reading everything starts over
+	dst := NewByteBuffer(0)
+	io.Copy(dst, b)
+	if string(dst.Bytes()) != "bra" {
+		t.Fatalf(tag+" unexpected io.Copy result [%v]", string(dst.Bytes()))
+	}
+	if b.Pos() != 0 || !b.Empty() {
+		t.Fatalf(tag+" expected empty buffer, position %v, length %v", b.Pos(), b.Len())
+	}
+	_, err = b.ReadByte()
+	if err != io.EOF {
+		t.Fatalf(tag+" expected io.EOF, found %v", errOrNilStr(err))
+	}
+}
+
+func TestQueueBufferCompact(t *testing.T) {
+	tag := "QueueBuffer.Compact()"
+
+	threshold := 64
+	b := NewQueueBuffer(uint(threshold))
+
+	// producer always stays a little ahead of the consumer, without
+	// compaction the internal buffer would grow with every write
+	x := byte(0)
+	y := byte(0)
+	for i := 0; i < 10000; i++ {
+		b.WriteByte(x)
+		x++
+		if i < 10 {
+			continue
+		}
+		c, err := b.ReadByte()
+		if err != nil {
+			t.Fatalf(tag+" unexpected read error: %v", err.Error())
+		}
+		if c != y {
+			t.Fatalf(tag+" unexpected read value @%v, expected %v, found %v", i, y, c)
+		}
+		y++
+		if b.Pos() > threshold*2 {
+			t.Fatalf(tag+" consumed bytes not dropped, position %v", b.Pos())
+		}
+	}
+	if cap(b.buff) > threshold*4 {
+		t.Fatalf(tag+" internal buffer grew to %v", cap(b.buff))
+	}
+	if b.Len() != 10 {
+		t.Fatalf(tag+" unexpected length, expected 10, found %v", b.Len())
+	}
+}
+
+func TestQueueBufferUInt64Var(t *testing.T) {
+	tag := "QueueBuffer.ReadWriteUInt64Var"
+
+	b := NewQueueBuffer(1)
+	for v := uint64(1); v < 1<<40; v *= 3 {
+		b.WriteUInt64Var(v)
+		x, err := b.ReadUInt64Var()
+		if err != nil {
+			t.Fatalf(tag+" unexpected read error: %v", err.Error())
+		}
+		if x != v {
+			t.Fatalf(tag+" unexpected read value, expected %v, found %v", v, x)
+		}
+	}
+}
+
+func TestQueueBufferUInt64VarTruncated(t *testing.T) {
+	tag := "QueueBuffer.ReadUInt64Var(truncated)"
+
+	b := NewQueueBuffer(1)
+	b.WriteByte('x')
+	b.ReadByte()
+	b.Write([]byte{0x80, 0x80})
+
+	// a truncated varint consumes nothing
+	_, err := b.ReadUInt64Var()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrUnexpectedEOF.Error(), errOrNilStr(err))
+	}
+	if b.Len() != 2 {
+		t.Fatalf(tag+" unexpected length after failed read, expected 2, found %v", b.Len())
+	}
+
+	// and is read whole once the rest arrives
+	b.WriteByte(0x01)
+	x, err := b.ReadUInt64Var()
+	if err != nil || x != 1<<14 {
+		t.Fatalf(tag+" unexpected read value, expected %v, found %v %v", 1<<14, x, errOrNilStr(err))
+	}
+	if !b.Empty() {
+		t.Fatalf(tag+" unexpected length, expected 0, found %v", b.Len())
+	}
+
+	_, err = b.ReadUInt64Var()
+	if err != io.EOF {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.EOF.Error(), errOrNilStr(err))
+	}
+}
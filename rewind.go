@@ -0,0 +1,300 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"errors"
+	"io"
+)
+
+// returned when RewindReader would need to record more than its retention limit
+var ErrRetainLimit = errors.New("Retention limit reached")
+
+// returned when seeking on a RewindReader after Release
+var ErrReleased = errors.New("Reader released")
+
+// number of reads in a row returning no data and no error after which
+// RewindReader gives up on its source with io.ErrNoProgress
+const KREWIND_MAX_EMPTY_READS = 100
+
+// RewindReader settings
+type RewindOptions struct {
+	// maximum number of bytes recorded, ZERO means unlimited
+	MaxRetain uint
+}
+
+// makes any io.Reader seekable by recording everything read from it into a
+// ByteBuffer, more data is pulled from the source on demand
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//	io.ByteReader
+type RewindReader struct {
+	r        io.Reader
+	rec      *ByteBuffer
+	pos      int
+	max      int
+	released bool
+	// sticky error returned by r
+	err error
+}
+
+// create a new RewindReader recording reads from r
+func NewRewindReader(r io.Reader, opts RewindOptions) *RewindReader {
+	return &RewindReader{
+		r:   r,
+		rec: NewByteBuffer(0),
+		max: int(opts.MaxRetain),
+	}
+}
+
+// returns the number of bytes recorded so far
+func (m *RewindReader) Recorded() int {
+	return len(m.rec.buff)
+}
+
+// returns reader position
+func (m *RewindReader) Pos() int {
+	return m.pos
+}
+
+// stops recording, reads return what's left of the recording past position
+// then go straight to the source
+// NOTE:
+//	- Seek and ReadAt return ErrReleased afterwards
+func (m *RewindReader) Release() {
+	m.released = true
+	m.drop()
+}
+
+// drops the recording once it's no longer reachable
+func (m *RewindReader) drop() {
+	if m.released && m.pos >= len(m.rec.buff) {
+		m.rec.buff = nil
+	}
+}
+
+// pulls from source with a single Read call, recording at most n more bytes,
+// reads returning nothing are retried up to KREWIND_MAX_EMPTY_READS times
+// returns the number of recorded bytes
+// errors:
+//	ErrRetainLimit
+//	io.ErrNoProgress
+//	any error returned by the source
+func (m *RewindReader) pull(n int) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	l := len(m.rec.buff)
+	if m.max > 0 {
+		n = min_int(n, m.max-l)
+		if n <= 0 {
+			return 0, ErrRetainLimit
+		}
+	}
+
+	// grow the recording in place, then let the source fill the new bytes
+	m.rec.buff = append(m.rec.buff, make([]byte, n)...)
+	var c int
+	var err error
+	for i := 0; i < KREWIND_MAX_EMPTY_READS && c == 0 && err == nil; i++ {
+		c, err = m.r.Read(m.rec.buff[l:])
+	}
+	c = max_int(c, 0)
+	m.rec.buff = m.rec.buff[:l+c]
+	if c == 0 && err == nil {
+		// not sticky, the source may still come around
+		return 0, io.ErrNoProgress
+	}
+	if err != nil {
+		m.err = err
+	}
+	return c, err
+}
+
+// pulls until at least n bytes are recorded
+func (m *RewindReader) fill(n int) error {
+	for len(m.rec.buff) < n {
+		_, err := m.pull(n - len(m.rec.buff))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	ErrRetainLimit
+//	io.ErrNoProgress, the source keeps returning no data and no error
+//	any error returned by the source, io.EOF included
+func (m *RewindReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// replay the recording first
+	if m.pos < len(m.rec.buff) {
+		n = copy(p, m.rec.buff[m.pos:])
+		m.pos += n
+		m.drop()
+		return n, nil
+	}
+
+	if m.released {
+		n, err = m.r.Read(p)
+		m.pos += max_int(n, 0)
+		return n, err
+	}
+
+	c, err := m.pull(len(p))
+	n = copy(p, m.rec.buff[m.pos:m.pos+c])
+	m.pos += n
+	return n, err
+}
+
+// io.ByteReader implementation
+func (m *RewindReader) ReadByte() (byte, error) {
+	p := make([]byte, 1)
+	_, err := io.ReadFull(m, p)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// io.ReaderAt implementation, pulls from source as needed
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrOffsetNegative
+//	ErrReleased
+//	ErrRetainLimit
+//	io.ErrNoProgress, the source keeps returning no data and no error
+//	any error returned by the source
+// NOTE:
+//	- ReadAt will NOT modify position
+func (m *RewindReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if m.released {
+		return 0, ErrReleased
+	}
+	pos := int(off)
+	if pos < 0 {
+		return 0, ErrOffsetNegative
+	}
+
+	err = m.fill(pos + len(p))
+	if pos < len(m.rec.buff) {
+		n = copy(p, m.rec.buff[pos:])
+	}
+	if n < len(p) {
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// @RewindReader.Seek(offset, io.SeekStart)
+func (m *RewindReader) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @RewindReader.Seek(offset, io.SeekCurrent)
+func (m *RewindReader) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @RewindReader.Seek(offset, io.SeekEnd)
+// NOTE:
+//	- the whole source is recorded, with a retention limit smaller than the
+//		source this returns ErrRetainLimit and leaves position unchanged
+func (m *RewindReader) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @RewindReader.Seek(0, io.SeekStart)
+func (m *RewindReader) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @RewindReader.Seek(0, io.SeekEnd), @RewindReader.SeekFromEnd
+func (m *RewindReader) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation
+// seeking forward pulls from source, seeking relative to the end pulls the
+// whole source
+// returns offset position if err == nil
+// errors:
+//	ErrReleased
+//	ErrRetainLimit
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+//	io.ErrNoProgress, the source keeps returning no data and no error
+//	any error returned by the source, other than io.EOF
+// NOTE:
+//	- seeking right past the last byte is allowed
+func (m *RewindReader) Seek(offset int64, whence int) (int64, error) {
+	if m.released {
+		return -1, ErrReleased
+	}
+
+	size := len(m.rec.buff)
+	if whence == io.SeekEnd {
+		// size is unknown until the source is exhausted
+		var err error
+		for err == nil {
+			_, err = m.pull(KCHUNK_SIZE)
+		}
+		if err != io.EOF {
+			return -1, err
+		}
+		size = len(m.rec.buff)
+	}
+
+	pos, err := seek_abs(offset, whence, m.pos, size)
+	if err != nil {
+		return -1, err
+	}
+
+	err = m.fill(pos)
+	if err == io.EOF {
+		return -1, ErrSeekOverflow
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	m.pos = pos
+	return int64(pos), nil
+}
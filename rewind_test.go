@@ -0,0 +1,207 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRewindReaderSniff(t *testing.T) {
+	tag := "RewindReader(sniff)"
+
+	s := "abracadabra, the quick brown fox jumps over the lazy dog"
+	// one byte per Read call, the worst kind of stream
+	r := NewRewindReader(iotest.OneByteReader(strings.NewReader(s)), RewindOptions{})
+
+	// sniff a header through ReadAt, then through Read
+	magic := make([]byte, 4)
+	n, err := r.ReadAt(magic, 7)
+	if err != nil || string(magic[:n]) != "abra" {
+		t.Fatalf(tag+" unexpected ReadAt [%v] %v", string(magic[:n]), errOrNilStr(err))
+	}
+	_, err = io.ReadFull(r, magic)
+	if err != nil || string(magic) != "abra" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(magic), errOrNilStr(err))
+	}
+
+	// rewind and hand over to the real consumer
+	pos, err := r.SeekToStart()
+	if err != nil || pos != 0 {
+		t.Fatalf(tag+" unexpected seek %v %v", pos, errOrNilStr(err))
+	}
+	r.Release()
+	if r.Recorded() != 11 {
+		t.Fatalf(tag+" unexpected recording size, expected 11, found %v", r.Recorded())
+	}
+
+	dst := NewByteBuffer(0)
+	_, err = io.Copy(dst, r)
+	if err != nil || string(dst.Bytes()) != s {
+		t.Fatalf(tag+" unexpected io.Copy result [%v] %v", string(dst.Bytes()), errOrNilStr(err))
+	}
+	if r.Recorded() != 0 {
+		t.Fatalf(tag+" recording not dropped after release, %v bytes", r.Recorded())
+	}
+
+	_, err = r.SeekToStart()
+	if err != ErrReleased {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrReleased.Error(), errOrNilStr(err))
+	}
+}
+
+func TestRewindReaderSeek(t *testing.T) {
+	tag := "RewindReader.Seek()"
+
+	s := "abracadabra"
+	r := NewRewindReader(strings.NewReader(s), RewindOptions{})
+
+	pos, err := r.Seek(-4, io.SeekEnd)
+	if err != nil || pos != 7 {
+		t.Fatalf(tag+" unexpected seek %v %v", pos, errOrNilStr(err))
+	}
+	c, _ := r.ReadByte()
+	if c != 'a' {
+		t.Fatalf(tag+" unexpected read byte %v", c)
+	}
+	_, err = r.SeekFromStart(12)
+	if err != ErrSeekOverflow {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrSeekOverflow.Error(), errOrNilStr(err))
+	}
+	rbuff := make([]byte, 4)
+	n, err := r.ReadAt(rbuff, 9)
+	if err != io.EOF || string(rbuff[:n]) != "ra" {
+		t.Fatalf(tag+" unexpected ReadAt [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+}
+
+func TestRewindReaderRetainLimit(t *testing.T) {
+	tag := "RewindReader(limit)"
+
+	r := NewRewindReader(strings.NewReader("abracadabra"), RewindOptions{MaxRetain: 4})
+
+	rbuff := make([]byte, 8)
+	n, err := io.ReadFull(r, rbuff)
+	if err != ErrRetainLimit || n != 4 {
+		t.Fatalf(tag+" unexpected read %v %v", n, errOrNilStr(err))
+	}
+	_, err = r.Seek(0, io.SeekEnd)
+	if err != ErrRetainLimit {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrRetainLimit.Error(), errOrNilStr(err))
+	}
+
+	// released readers are no longer limited
+	r.SeekToStart()
+	r.Release()
+	dst := NewByteBuffer(0)
+	io.Copy(dst, r)
+	if string(dst.Bytes()) != "abracadabra" {
+		t.Fatalf(tag+" unexpected io.Copy result [%v]", string(dst.Bytes()))
+	}
+}
+
+func TestRewindReaderSeekHelpers(t *testing.T) {
+	tag := "RewindReader.SeekFrom*()"
+
+	s := "abracadabra"
+	r := NewRewindReader(strings.NewReader(s), RewindOptions{})
+
+	pos, err := r.SeekFromCurrent(4)
+	if err != nil || pos != 4 {
+		t.Fatalf(tag+" unexpected seek %v %v", pos, errOrNilStr(err))
+	}
+	pos, err = r.SeekFromCurrent(-2)
+	if err != nil || pos != 2 {
+		t.Fatalf(tag+" unexpected seek %v %v", pos, errOrNilStr(err))
+	}
+	pos, err = r.SeekFromEnd(-4)
+	if err != nil || pos != 7 {
+		t.Fatalf(tag+" unexpected seek %v %v", pos, errOrNilStr(err))
+	}
+	pos, err = r.SeekToEnd()
+	if err != nil || pos != int64(len(s)) {
+		t.Fatalf(tag+" unexpected seek %v %v", pos, errOrNilStr(err))
+	}
+	n, err := r.Read(make([]byte, 4))
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+
+	// the end is out of reach when the source doesn't fit the retention limit
+	r = NewRewindReader(strings.NewReader(s), RewindOptions{MaxRetain: 4})
+	r.SeekFromCurrent(2)
+	_, err = r.SeekToEnd()
+	if err != ErrRetainLimit {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrRetainLimit.Error(), errOrNilStr(err))
+	}
+	if r.Pos() != 2 {
+		t.Fatalf(tag+" position moved by a failed seek, found %v", r.Pos())
+	}
+	_, err = r.SeekFromCurrent(-3)
+	if err != ErrSeekNegative {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrSeekNegative.Error(), errOrNilStr(err))
+	}
+}
+
+// returns no data and no error, forever
+type rewindStuckReader struct {
+	reads int
+}
+
+func (m *rewindStuckReader) Read(p []byte) (int, error) {
+	m.reads++
+	return 0, nil
+}
+
+func TestRewindReaderNoProgress(t *testing.T) {
+	tag := "RewindReader(no progress)"
+
+	src := &rewindStuckReader{}
+	r := NewRewindReader(src, RewindOptions{})
+
+	_, err := r.Read(make([]byte, 4))
+	if err != io.ErrNoProgress {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrNoProgress.Error(), errOrNilStr(err))
+	}
+	if src.reads != KREWIND_MAX_EMPTY_READS {
+		t.Fatalf(tag+" unexpected number of reads, expected %v, found %v", KREWIND_MAX_EMPTY_READS, src.reads)
+	}
+	_, err = r.ReadAt(make([]byte, 4), 2)
+	if err != io.ErrNoProgress {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrNoProgress.Error(), errOrNilStr(err))
+	}
+	_, err = r.SeekFromStart(2)
+	if err != io.ErrNoProgress {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrNoProgress.Error(), errOrNilStr(err))
+	}
+	_, err = r.SeekToEnd()
+	if err != io.ErrNoProgress {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", io.ErrNoProgress.Error(), errOrNilStr(err))
+	}
+}
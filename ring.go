@@ -0,0 +1,309 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// returned when writing to a full RingBuffer with KRING_FULL_ERROR policy
+var ErrFull = errors.New("Buffer full")
+
+// returned when writing to a closed buffer
+var ErrClosed = errors.New("Buffer closed")
+
+// what RingBuffer.Write does when there's no room left
+type RingFullPolicy int
+
+const (
+	// write as much as fits and return ErrFull
+	KRING_FULL_ERROR RingFullPolicy = iota
+	// wait until a reader makes room or the buffer is closed
+	KRING_FULL_BLOCK
+	// drop the oldest unread bytes to make room
+	KRING_FULL_OVERWRITE
+)
+
+// a fixed capacity circular buffer with separate read and write cursors
+// safe for use by multiple goroutines
+// implemented interfaces
+//  io.Reader
+//  io.Writer
+//  io.WriterTo
+//	io.ByteReader
+//	io.ByteWriter
+//  io.Closer
+// NOTE:
+//	- reads never block, reading from an empty buffer returns io.EOF
+type RingBuffer struct {
+	buff   []byte
+	r      int
+	n      int
+	policy RingFullPolicy
+	closed bool
+	mu     sync.Mutex
+	cond   *sync.Cond
+}
+
+// create a new RingBuffer able to hold (capacity) bytes
+func NewRingBuffer(capacity uint, policy RingFullPolicy) *RingBuffer {
+	m := &RingBuffer{
+		buff:   make([]byte, capacity),
+		policy: policy,
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// drops all unread data
+func (m *RingBuffer) Reset() *RingBuffer {
+	m.mu.Lock()
+	m.r = 0
+	m.n = 0
+	m.mu.Unlock()
+	m.cond.Broadcast()
+	return m
+}
+
+// returns the number of unread bytes
+func (m *RingBuffer) Size() uint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return uint(m.n)
+}
+
+// returns the fixed capacity of buffer
+func (m *RingBuffer) Capacity() uint {
+	return uint(len(m.buff))
+}
+
+// returns the number of bytes that can be written without hitting the full policy
+func (m *RingBuffer) Available() uint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return uint(len(m.buff) - m.n)
+}
+
+// returns true if there's no unread data
+func (m *RingBuffer) Empty() bool {
+	return m.Size() == 0
+}
+
+// returns true if buffer is at capacity
+func (m *RingBuffer) Full() bool {
+	return m.Available() == 0
+}
+
+// closes the buffer for writing, blocked writers return ErrClosed
+// unread data can still be read
+func (m *RingBuffer) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.cond.Broadcast()
+	return nil
+}
+
+// returns the unread data as at most two contiguous segments, second one is
+// empty unless data wraps around the end of the internal buffer
+// NOTE:
+//	- segments share memory with the buffer, use Discard once done with them
+//	- segments are only valid until the next write
+func (m *RingBuffer) Segments() ([]byte, []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.segments()
+}
+
+func (m *RingBuffer) segments() ([]byte, []byte) {
+	end := m.r + m.n
+	if end <= len(m.buff) {
+		return m.buff[m.r:end], nil
+	}
+	return m.buff[m.r:], m.buff[:end-len(m.buff)]
+}
+
+// drops up to n unread bytes, returns the number of dropped bytes
+func (m *RingBuffer) Discard(n int) int {
+	m.mu.Lock()
+	n = m.discard(n)
+	m.mu.Unlock()
+	if n > 0 {
+		m.cond.Broadcast()
+	}
+	return n
+}
+
+func (m *RingBuffer) discard(n int) int {
+	n = min_int(max_int(n, 0), m.n)
+	m.r = (m.r + n) % max_int(len(m.buff), 1)
+	m.n -= n
+	if m.n == 0 {
+		// start over, keeps data contiguous for as long as possible
+		m.r = 0
+	}
+	return n
+}
+
+// copies unread bytes into p without consuming them
+// errors:
+//	io.EOF
+func (m *RingBuffer) Peek(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peek(p)
+}
+
+func (m *RingBuffer) peek(p []byte) (int, error) {
+	if m.n == 0 {
+		return 0, io.EOF
+	}
+	a, b := m.segments()
+	n := copy(p, a)
+	n += copy(p[n:], b)
+	return n, nil
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+func (m *RingBuffer) Read(p []byte) (int, error) {
+	m.mu.Lock()
+	n, err := m.peek(p)
+	m.discard(n)
+	m.mu.Unlock()
+	if n > 0 {
+		m.cond.Broadcast()
+	}
+	return n, err
+}
+
+// io.ByteReader implementation
+func (m *RingBuffer) ReadByte() (byte, error) {
+	p := make([]byte, 1)
+	_, err := m.Read(p)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// io.WriterTo implementation
+// writes the unread segments to w without copying, written bytes are consumed
+func (m *RingBuffer) WriteTo(w io.Writer) (int64, error) {
+	total := int64(0)
+	for {
+		a, _ := m.Segments()
+		if len(a) == 0 {
+			return total, nil
+		}
+		n, err := w.Write(a)
+		total += int64(n)
+		m.Discard(n)
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// io.Writer implementation
+// returns number of written bytes
+// errors:
+//	ErrFull
+//	ErrClosed
+// NOTE:
+//	- KRING_FULL_ERROR writes as much as fits before returning ErrFull
+//	- KRING_FULL_BLOCK waits for readers, returns ErrClosed if the buffer is
+//		closed in the meantime
+//	- KRING_FULL_OVERWRITE never fails, if p is larger than the capacity only
+//		its last bytes are kept
+func (m *RingBuffer) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	written := 0
+	for {
+		if m.closed {
+			return written, ErrClosed
+		}
+
+		if m.policy == KRING_FULL_OVERWRITE {
+			// only the tail of p can survive
+			q := p[written:]
+			if len(q) > len(m.buff) {
+				q = q[len(q)-len(m.buff):]
+			}
+			m.discard(len(q) - (len(m.buff) - m.n))
+			m.write(q)
+			return len(p), nil
+		}
+
+		written += m.write(p[written:])
+		if written == len(p) {
+			if written > 0 {
+				m.cond.Broadcast()
+			}
+			return written, nil
+		}
+
+		if m.policy != KRING_FULL_BLOCK {
+			return written, ErrFull
+		}
+
+		// wait for readers to make room
+		m.cond.Wait()
+	}
+}
+
+// copies as much of p as fits in the free space, returns the number of copied bytes
+func (m *RingBuffer) write(p []byte) int {
+	c := 0
+	for c < len(p) && m.n < len(m.buff) {
+		w := (m.r + m.n) % len(m.buff)
+		// free space up to the end of internal buffer or up to the read cursor
+		end := len(m.buff)
+		if w < m.r {
+			end = m.r
+		}
+		k := copy(m.buff[w:end], p[c:])
+		c += k
+		m.n += k
+	}
+	return c
+}
+
+// io.ByteWriter implementation
+// errors:
+//	ErrFull
+//	ErrClosed
+func (m *RingBuffer) WriteByte(c byte) error {
+	_, err := m.Write([]byte{c})
+	return err
+}
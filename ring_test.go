@@ -0,0 +1,157 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRingBufferError(t *testing.T) {
+	tag := "RingBuffer(KRING_FULL_ERROR)"
+
+	b := NewRingBuffer(8, KRING_FULL_ERROR)
+	n, err := b.Write([]byte("abracadabra"))
+	if err != ErrFull {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrFull.Error(), errOrNilStr(err))
+	}
+	if n != 8 {
+		t.Fatalf(tag+" unexpected write size, expected 8, found %v", n)
+	}
+	if !b.Full() {
+		t.Fatalf(tag+" expected full buffer, available %v", b.Available())
+	}
+
+	// consume a few bytes and wrap around
+	rbuff := make([]byte, 5)
+	n, err = b.Read(rbuff)
+	if err != nil || string(rbuff[:n]) != "abrac" {
+		t.Fatalf(tag+" unexpected read [%v] %v", string(rbuff[:n]), errOrNilStr(err))
+	}
+	n, err = b.Write([]byte("xyz"))
+	if err != nil || n != 3 {
+		t.Fatalf(tag+" unexpected write %v %v", n, errOrNilStr(err))
+	}
+
+	a, c := b.Segments()
+	if string(a) != "ada" || string(c) != "xyz" {
+		t.Fatalf(tag+" unexpected segments [%v] [%v]", string(a), string(c))
+	}
+
+	peek := make([]byte, 16)
+	n, _ = b.Peek(peek)
+	if string(peek[:n]) != "adaxyz" || b.Size() != 6 {
+		t.Fatalf(tag+" unexpected peek [%v], size %v", string(peek[:n]), b.Size())
+	}
+
+	dst := NewByteBuffer(0)
+	w, err := b.WriteTo(dst)
+	if err != nil || w != 6 || string(dst.Bytes()) != "adaxyz" {
+		t.Fatalf(tag+" unexpected WriteTo result %v [%v] %v", w, string(dst.Bytes()), errOrNilStr(err))
+	}
+
+	_, err = b.ReadByte()
+	if err != io.EOF {
+		t.Fatalf(tag+" expected io.EOF, found %v", errOrNilStr(err))
+	}
+}
+
+func TestRingBufferOverwrite(t *testing.T) {
+	tag := "RingBuffer(KRING_FULL_OVERWRITE)"
+
+	b := NewRingBuffer(4, KRING_FULL_OVERWRITE)
+	for _, c := range []byte("abcdef") {
+		err := b.WriteByte(c)
+		if err != nil {
+			t.Fatalf(tag+" unexpected write error: %v", err.Error())
+		}
+	}
+	rbuff := make([]byte, 8)
+	n, _ := b.Peek(rbuff)
+	if string(rbuff[:n]) != "cdef" {
+		t.Fatalf(tag+" unexpected contents, expected [cdef], found [%v]", string(rbuff[:n]))
+	}
+
+	n, err := b.Write([]byte("0123456789"))
+	if err != nil || n != 10 {
+		t.Fatalf(tag+" unexpected write %v %v", n, errOrNilStr(err))
+	}
+	n, _ = b.Read(rbuff)
+	if string(rbuff[:n]) != "6789" {
+		t.Fatalf(tag+" unexpected contents, expected [6789], found [%v]", string(rbuff[:n]))
+	}
+}
+
+func TestRingBufferBlock(t *testing.T) {
+	tag := "RingBuffer(KRING_FULL_BLOCK)"
+
+	b := NewRingBuffer(16, KRING_FULL_BLOCK)
+	src := make([]byte, 1000)
+	for i := range src {
+		src[i] = byte(i)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := b.Write(src)
+		b.Close()
+		done <- err
+	}()
+
+	dst := NewByteBuffer(0)
+	rbuff := make([]byte, 7)
+	for dst.Size() < uint(len(src)) {
+		n, err := b.Read(rbuff)
+		if err == io.EOF {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		dst.Write(rbuff[:n])
+	}
+	err := <-done
+	if err != nil {
+		t.Fatalf(tag+" unexpected write error: %v", err.Error())
+	}
+	if bytes.Compare(src, dst.Bytes()) != 0 {
+		t.Fatal(tag + " data mismatch")
+	}
+
+	// a closed buffer releases blocked writers
+	b = NewRingBuffer(2, KRING_FULL_BLOCK)
+	go func() {
+		_, err := b.Write([]byte("abc"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Close()
+	err = <-done
+	if err != ErrClosed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrClosed.Error(), errOrNilStr(err))
+	}
+}
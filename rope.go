@@ -0,0 +1,423 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"io"
+)
+
+// maximum number of bytes held by a single rope leaf, adjacent leaves smaller
+// than this are merged on concatenation
+const KROPE_LEAF_SIZE = 4096
+
+// immutable rope node, either a leaf holding data or an inner node with
+// exactly two children, nodes are never modified once created which is what
+// allows snapshots to share them
+type ropeNode struct {
+	left   *ropeNode
+	right  *ropeNode
+	leaf   []byte
+	size   int
+	height int
+}
+
+func ropeHeight(n *ropeNode) int {
+	if n == nil {
+		return -1
+	}
+	return n.height
+}
+
+func ropeSize(n *ropeNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func newRopeLeaf(p []byte) *ropeNode {
+	if len(p) == 0 {
+		return nil
+	}
+	return &ropeNode{leaf: p, size: len(p)}
+}
+
+// creates an inner node, no balancing
+func newRopeNode(left, right *ropeNode) *ropeNode {
+	return &ropeNode{
+		left:   left,
+		right:  right,
+		size:   left.size + right.size,
+		height: max_int(left.height, right.height) + 1,
+	}
+}
+
+// creates an inner node from two subtrees whose heights differ by at most 2,
+// rotating as needed to restore the AVL invariant
+func ropeBalance(left, right *ropeNode) *ropeNode {
+	hl, hr := ropeHeight(left), ropeHeight(right)
+	if hl > hr+1 {
+		if ropeHeight(left.left) >= ropeHeight(left.right) {
+			return newRopeNode(left.left, newRopeNode(left.right, right))
+		}
+		return newRopeNode(
+			newRopeNode(left.left, left.right.left),
+			newRopeNode(left.right.right, right))
+	}
+	if hr > hl+1 {
+		if ropeHeight(right.right) >= ropeHeight(right.left) {
+			return newRopeNode(newRopeNode(left, right.left), right.right)
+		}
+		return newRopeNode(
+			newRopeNode(left, right.left.left),
+			newRopeNode(right.left.right, right.right))
+	}
+	return newRopeNode(left, right)
+}
+
+// concatenates two ropes in O(|height(left) - height(right)|)
+func ropeJoin(left, right *ropeNode) *ropeNode {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+
+	// merge small leaves, keeps the number of nodes low on byte-by-byte edits
+	if left.leaf != nil && right.leaf != nil && left.size+right.size <= KROPE_LEAF_SIZE {
+		p := make([]byte, left.size+right.size)
+		copy(p, left.leaf)
+		copy(p[left.size:], right.leaf)
+		return newRopeLeaf(p)
+	}
+
+	hl, hr := left.height, right.height
+	if hl > hr+1 {
+		return ropeBalance(left.left, ropeJoin(left.right, right))
+	}
+	if hr > hl+1 {
+		return ropeBalance(ropeJoin(left, right.left), right.right)
+	}
+	return newRopeNode(left, right)
+}
+
+// splits n at offset i, the left result holds the first i bytes
+func ropeSplit(n *ropeNode, i int) (*ropeNode, *ropeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if i <= 0 {
+		return nil, n
+	}
+	if i >= n.size {
+		return n, nil
+	}
+	if n.leaf != nil {
+		// leaf data is immutable, both halves can share it
+		return newRopeLeaf(n.leaf[:i:i]), newRopeLeaf(n.leaf[i:])
+	}
+	ls := n.left.size
+	if i < ls {
+		ll, lr := ropeSplit(n.left, i)
+		return ll, ropeJoin(lr, n.right)
+	}
+	if i > ls {
+		rl, rr := ropeSplit(n.right, i-ls)
+		return ropeJoin(n.left, rl), rr
+	}
+	return n.left, n.right
+}
+
+// builds a balanced rope from p, p must not be modified afterwards
+func ropeBuild(p []byte) *ropeNode {
+	if len(p) <= KROPE_LEAF_SIZE {
+		return newRopeLeaf(p)
+	}
+	// split on a leaf boundary so that all leaves but the last are full
+	nleaves := (len(p) + KROPE_LEAF_SIZE - 1) / KROPE_LEAF_SIZE
+	mid := (nleaves / 2) * KROPE_LEAF_SIZE
+	return newRopeNode(ropeBuild(p[:mid]), ropeBuild(p[mid:]))
+}
+
+// copies bytes from offset off into p, returns the number of copied bytes
+func ropeCopy(n *ropeNode, p []byte, off int) int {
+	c := 0
+	for n != nil && c < len(p) && off < n.size {
+		if n.leaf != nil {
+			return c + copy(p[c:], n.leaf[off:])
+		}
+		ls := n.left.size
+		if off < ls {
+			c += ropeCopy(n.left, p[c:], off)
+			off = ls
+		}
+		// continue with the right subtree, no recursion needed
+		off -= ls
+		n = n.right
+	}
+	return c
+}
+
+// a persistent byte sequence for very large documents
+// Insert, Delete and ReadAt cost O(log n), Snapshot costs O(1) since ropes
+// never modify their nodes, edits create new nodes and share the rest
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//	io.ByteReader
+type Rope struct {
+	root *ropeNode
+	pos  int
+}
+
+// create a new empty Rope
+func NewRope() *Rope {
+	return &Rope{}
+}
+
+// create a new Rope holding a copy of p
+func NewRopeFromBytes(p []byte) *Rope {
+	c := make([]byte, len(p))
+	copy(c, p)
+	return &Rope{root: ropeBuild(c)}
+}
+
+// create a new Rope holding a copy of the contents of b
+// position is set to ZERO
+func NewRopeFromByteBuffer(b *ByteBuffer) *Rope {
+	// Bytes already returns a copy
+	return &Rope{root: ropeBuild(b.Bytes())}
+}
+
+// returns an independent copy of this rope as it is right now, O(1)
+// both ropes share nodes, which are never modified, so the copy accepts
+// Insert, Delete and Append like any other rope and edits on either rope are
+// not visible to the other
+// position in the copy is set to ZERO
+func (m *Rope) Snapshot() *Rope {
+	return &Rope{root: m.root}
+}
+
+// returns size in bytes of rope
+func (m *Rope) Size() uint {
+	return uint(ropeSize(m.root))
+}
+
+// returns true if the rope holds no data
+func (m *Rope) Empty() bool {
+	return m.root == nil
+}
+
+// returns rope position
+func (m *Rope) Pos() int {
+	return m.pos
+}
+
+// returns the contents of rope as a byte slice
+func (m *Rope) Bytes() []byte {
+	r := make([]byte, ropeSize(m.root))
+	ropeCopy(m.root, r, 0)
+	return r
+}
+
+// returns a new ByteBuffer holding the contents of rope
+// position in the ByteBuffer is set to ZERO
+func (m *Rope) ByteBuffer() *ByteBuffer {
+	r := NewByteBuffer(0)
+	r.buff = m.Bytes()
+	return r
+}
+
+// inserts p at offset off, off may be equal to Size() in order to append
+// position is unchanged
+// errors:
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+func (m *Rope) Insert(off int, p []byte) error {
+	if off < 0 {
+		return ErrOffsetNegative
+	}
+	if off > ropeSize(m.root) {
+		return ErrOffsetOverflow
+	}
+	if len(p) == 0 {
+		return nil
+	}
+
+	c := make([]byte, len(p))
+	copy(c, p)
+
+	l, r := ropeSplit(m.root, off)
+	m.root = ropeJoin(ropeJoin(l, ropeBuild(c)), r)
+	return nil
+}
+
+// deletes up to n bytes starting at offset off, returns the number of deleted
+// bytes
+// NOTE:
+//	- position is clamped to the new size
+// errors:
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+func (m *Rope) Delete(off int, n int) (int, error) {
+	if off < 0 {
+		return 0, ErrOffsetNegative
+	}
+	if off > ropeSize(m.root) {
+		return 0, ErrOffsetOverflow
+	}
+	n = min_int(max_int(n, 0), ropeSize(m.root)-off)
+	if n == 0 {
+		return 0, nil
+	}
+
+	l, rest := ropeSplit(m.root, off)
+	_, r := ropeSplit(rest, n)
+	m.root = ropeJoin(l, r)
+	m.pos = min_int(m.pos, ropeSize(m.root))
+	return n, nil
+}
+
+// appends p to rope, returns the number of appended bytes
+func (m *Rope) Append(p []byte) int {
+	m.Insert(ropeSize(m.root), p)
+	return len(p)
+}
+
+// @Rope.Seek(offset, io.SeekStart)
+func (m *Rope) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @Rope.Seek(offset, io.SeekCurrent)
+func (m *Rope) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @Rope.Seek(offset, io.SeekEnd)
+func (m *Rope) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @Rope.Seek(0, io.SeekStart)
+func (m *Rope) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @Rope.Seek(0, io.SeekEnd)
+func (m *Rope) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation
+// returns offset position if err == nil
+// errors:
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+// NOTE:
+//	- seeking right past the last byte is allowed
+func (m *Rope) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seek_abs(offset, whence, m.pos, ropeSize(m.root))
+	if err != nil {
+		return -1, err
+	}
+	if pos > ropeSize(m.root) {
+		return -1, ErrSeekOverflow
+	}
+	m.pos = pos
+	return int64(pos), nil
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+func (m *Rope) Read(p []byte) (n int, err error) {
+	if m.pos >= ropeSize(m.root) {
+		return 0, io.EOF
+	}
+	n = ropeCopy(m.root, p, m.pos)
+	m.pos += n
+	return n, nil
+}
+
+// io.ReaderAt implementation
+// reads up to len(p) from rope at offset off
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+// NOTE:
+//	- ReadAt will NOT modify position
+func (m *Rope) ReadAt(p []byte, off int64) (n int, err error) {
+	pos := int(off)
+	if pos < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if pos >= ropeSize(m.root) {
+		return -1, ErrOffsetOverflow
+	}
+	n = ropeCopy(m.root, p, pos)
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// io.ByteReader implementation
+func (m *Rope) ReadByte() (byte, error) {
+	c, err := m.ByteAt(m.pos)
+	if err != nil {
+		return 0, io.EOF
+	}
+	m.pos++
+	return c, nil
+}
+
+// returns a byte at a specific position in rope
+func (m *Rope) ByteAt(pos int) (byte, error) {
+	if pos < 0 {
+		return 0, ErrOffsetNegative
+	}
+	n := m.root
+	if pos >= ropeSize(n) {
+		return 0, ErrOffsetOverflow
+	}
+	for n.leaf == nil {
+		if pos < n.left.size {
+			n = n.left
+		} else {
+			pos -= n.left.size
+			n = n.right
+		}
+	}
+	return n.leaf[pos], nil
+}
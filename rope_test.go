@@ -0,0 +1,162 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestRopeInsertDelete(t *testing.T) {
+	tag := "Rope.Insert/Delete()"
+
+	r := NewRope()
+	expected := []byte{}
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		off := rnd.Intn(len(expected) + 1)
+		if i%3 == 2 {
+			n := rnd.Intn(64)
+			d, err := r.Delete(off, n)
+			if err != nil {
+				t.Fatalf(tag+" unexpected delete error: %v", err.Error())
+			}
+			expected = append(expected[:off], expected[off+d:]...)
+			continue
+		}
+		p := make([]byte, rnd.Intn(KROPE_LEAF_SIZE*2))
+		rnd.Read(p)
+		err := r.Insert(off, p)
+		if err != nil {
+			t.Fatalf(tag+" unexpected insert error: %v", err.Error())
+		}
+		expected = append(expected[:off], append(p, expected[off:]...)...)
+	}
+
+	if r.Size() != uint(len(expected)) {
+		t.Fatalf(tag+" size error, expected %v, found %v", len(expected), r.Size())
+	}
+	if bytes.Compare(expected, r.Bytes()) != 0 {
+		t.Fatal(tag + " data mismatch")
+	}
+
+	// AVL trees are never taller than 1.45*log2(n)+2
+	log2 := 1
+	for l := ropeSize(r.root); l > 0; l >>= 1 {
+		log2++
+	}
+	if r.root.height > 2*log2 {
+		t.Fatalf(tag+" rope is not balanced, height %v", r.root.height)
+	}
+
+	err := r.Insert(-1, []byte{1})
+	if err != ErrOffsetNegative {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrOffsetNegative.Error(), errOrNilStr(err))
+	}
+	err = r.Insert(len(expected)+1, []byte{1})
+	if err != ErrOffsetOverflow {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrOffsetOverflow.Error(), errOrNilStr(err))
+	}
+}
+
+func TestRopeSnapshot(t *testing.T) {
+	tag := "Rope.Snapshot()"
+
+	r := NewRopeFromBytes([]byte("abracadabra"))
+	snap := r.Snapshot()
+
+	r.Insert(4, []byte("--"))
+	r.Delete(0, 1)
+	r.Append([]byte("!"))
+
+	if string(snap.Bytes()) != "abracadabra" {
+		t.Fatalf(tag+" snapshot modified, found [%v]", string(snap.Bytes()))
+	}
+	if string(r.Bytes()) != "bra--cadabra!" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(r.Bytes()))
+	}
+
+	snap.Insert(0, []byte(">"))
+	if string(r.Bytes()) != "bra--cadabra!" {
+		t.Fatalf(tag+" original modified by snapshot, found [%v]", string(r.Bytes()))
+	}
+}
+
+func TestRopeRead(t *testing.T) {
+	tag := "Rope.Read()"
+
+	src := make([]byte, KROPE_LEAF_SIZE*5+17)
+	rand.New(rand.NewSource(2)).Read(src)
+	b := NewByteBuffer(0)
+	b.Write(src)
+
+	r := NewRopeFromByteBuffer(b)
+	if r.Size() != b.Size() {
+		t.Fatalf(tag+" size error, expected %v, found %v", b.Size(), r.Size())
+	}
+
+	// read across leaf boundaries
+	rbuff := make([]byte, 100)
+	off := int64(KROPE_LEAF_SIZE - 50)
+	n, err := r.ReadAt(rbuff, off)
+	if err != nil {
+		t.Fatalf(tag+" unexpected read error: %v", err.Error())
+	}
+	if n != len(rbuff) || bytes.Compare(src[off:off+100], rbuff) != 0 {
+		t.Fatal(tag + " ReadAt data mismatch")
+	}
+	n, err = r.ReadAt(rbuff, int64(len(src)-10))
+	if err != io.EOF || n != 10 {
+		t.Fatalf(tag+" unexpected ReadAt result, expected 10/io.EOF, found %v/%v", n, errOrNilStr(err))
+	}
+
+	_, err = r.SeekFromEnd(-1)
+	if err != nil {
+		t.Fatalf(tag+" unexpected seek error: %v", err.Error())
+	}
+	c, err := r.ReadByte()
+	if err != nil || c != src[len(src)-1] {
+		t.Fatalf(tag+" unexpected ReadByte result %v/%v", c, errOrNilStr(err))
+	}
+	_, err = r.ReadByte()
+	if err != io.EOF {
+		t.Fatalf(tag+" expected io.EOF, found %v", errOrNilStr(err))
+	}
+
+	r.SeekToStart()
+	dst := NewByteBuffer(0)
+	_, err = io.Copy(dst, r)
+	if err != nil {
+		t.Fatalf(tag+" unexpected error in io.Copy: %v", err.Error())
+	}
+	if dst.CmpWith(b) != 0 || r.ByteBuffer().CmpWith(b) != 0 {
+		t.Fatal(tag + " data mismatch")
+	}
+}
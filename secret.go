@@ -0,0 +1,473 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// returned when memory locking is not available on this platform
+var ErrMlockUnsupported = errors.New("Memory locking not supported")
+
+// what SecretBuffer prints instead of its contents
+const KSECRET_REDACTED = "[REDACTED]"
+
+// a byte buffer for key material and other secrets
+// old backing arrays are zeroed whenever they are dropped, on growth, Reset,
+// Clear and Close, and growth never goes through append so no stray copies
+// are left behind
+// NOTE:
+//	- Close must be called once the secret is no longer needed
+//	- fmt prints KSECRET_REDACTED for every verb, contents only come out
+//		through reads, Bytes and WithBytes
+//	- same API as ByteBuffer, minus CmpWith, use Equal
+//	- create with NewSecretBuffer or NewSecretBufferFrom, the zero value is
+//		not usable
+//	- state lives behind a pointer, so a copied or embedded SecretBuffer
+//		prints as an address at worst, never as the bytes
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//  io.Closer
+//	io.ByteReader
+//	io.ByteWriter
+//	fmt.Formatter
+//	fmt.Stringer
+//	fmt.GoStringer
+type SecretBuffer struct {
+	s *secretState
+}
+
+type secretState struct {
+	buff   []byte
+	pos    int
+	locked bool
+	closed bool
+}
+
+// create a new SecretBuffer of (size) ZERO bytes
+func NewSecretBuffer(size uint) *SecretBuffer {
+	return (&SecretBuffer{s: &secretState{}}).Reset(size)
+}
+
+// create a new SecretBuffer holding a copy of p, position is ZERO
+// NOTE:
+//	- p is left untouched, zero it if it was the only other copy
+func NewSecretBufferFrom(p []byte) *SecretBuffer {
+	m := NewSecretBuffer(uint(len(p)))
+	copy(m.s.buff, p)
+	return m
+}
+
+// zeroes and drops the backing array, the new one of (size) bytes, (capacity)
+// in total, is locked if the old one was
+// errors:
+//	syscall errors, if the new array can't be locked, the buffer is left as it
+//		was and the new array is dropped
+func (m *SecretBuffer) replaceArray(size int, capacity int) ([]byte, error) {
+	buff := make([]byte, size, capacity)
+	if m.s.locked && capacity > 0 {
+		if err := mlock(buff[:capacity]); err != nil {
+			return nil, err
+		}
+	}
+	old := m.s.buff[:cap(m.s.buff)]
+	m.s.buff = buff
+	return old, nil
+}
+
+// zeroes p and unlocks it if the buffer was locked
+func (m *SecretBuffer) wipe(p []byte, wasLocked bool) {
+	zero_bytes(p)
+	if wasLocked && len(p) > 0 {
+		munlock(p)
+	}
+}
+
+// resizes the internal buffer to (size) ZERO bytes, position is reset to ZERO
+// NOTE:
+//	- the old contents are zeroed, the backing array is kept if it can hold
+//		(size) bytes
+//	- resets a closed buffer too, making it usable again
+//	- if a locked buffer needs a new array and it can't be locked, the buffer
+//		is unlocked, see Locked
+func (m *SecretBuffer) Reset(size uint) *SecretBuffer {
+	m.s.closed = false
+	if uint(cap(m.s.buff)) >= size && m.s.buff != nil {
+		zero_bytes(m.s.buff[:cap(m.s.buff)])
+		m.s.buff = m.s.buff[:size]
+	} else {
+		old, err := m.replaceArray(int(size), int(size))
+		if err != nil {
+			// can't keep the lock, the new array stays unlocked
+			m.wipe(m.s.buff[:cap(m.s.buff)], m.s.locked)
+			m.s.locked = false
+			old, _ = m.replaceArray(int(size), int(size))
+		}
+		m.wipe(old, m.s.locked)
+	}
+	m.s.pos = 0
+	return m
+}
+
+// @SecretBuffer.Reset(0)
+func (m *SecretBuffer) Clear() *SecretBuffer {
+	return m.Reset(0)
+}
+
+// io.Closer implementation, zeroes and unlocks the backing array and drops
+// it, further calls return ErrClosed until the next Reset
+func (m *SecretBuffer) Close() error {
+	m.wipe(m.s.buff[:cap(m.s.buff)], m.s.locked)
+	m.s.buff = nil
+	m.s.pos = 0
+	m.s.locked = false
+	m.s.closed = true
+	return nil
+}
+
+// locks the backing array in memory, so it is never swapped out, arrays
+// allocated by growth are locked too, or the growing write fails
+// errors:
+//	ErrClosed
+//	ErrMlockUnsupported, not on Linux
+//	syscall errors, usually ENOMEM or EPERM once RLIMIT_MEMLOCK is reached
+func (m *SecretBuffer) Lock() error {
+	if m.s.closed {
+		return ErrClosed
+	}
+	if m.s.locked {
+		return nil
+	}
+	if cap(m.s.buff) > 0 {
+		if err := mlock(m.s.buff[:cap(m.s.buff)]); err != nil {
+			return err
+		}
+	} else if err := mlock(nil); err == ErrMlockUnsupported {
+		return err
+	}
+	m.s.locked = true
+	return nil
+}
+
+// undoes Lock
+func (m *SecretBuffer) Unlock() error {
+	if !m.s.locked {
+		return nil
+	}
+	m.s.locked = false
+	if cap(m.s.buff) > 0 {
+		return munlock(m.s.buff[:cap(m.s.buff)])
+	}
+	return nil
+}
+
+// returns true if the backing array is locked in memory
+func (m *SecretBuffer) Locked() bool {
+	return m.s.locked
+}
+
+// returns true if the buffer was closed
+func (m *SecretBuffer) Closed() bool {
+	return m.s.closed
+}
+
+// returns true if the size of internal buffer is ZERO
+func (m *SecretBuffer) Empty() bool {
+	return len(m.s.buff) == 0
+}
+
+// constant time comparison of the contents of this and other
+// NOTE:
+//	- only the sizes may leak through timing
+func (m *SecretBuffer) Equal(other *SecretBuffer) bool {
+	return subtle.ConstantTimeCompare(m.s.buff, other.s.buff) == 1
+}
+
+// @SecretBuffer.Equal against p
+func (m *SecretBuffer) EqualBytes(p []byte) bool {
+	return subtle.ConstantTimeCompare(m.s.buff, p) == 1
+}
+
+// returns size in bytes of internal buffer
+func (m *SecretBuffer) Size() uint {
+	return uint(len(m.s.buff))
+}
+
+// returns capacity in bytes of internal buffer
+func (m *SecretBuffer) Cap() uint {
+	return uint(cap(m.s.buff))
+}
+
+// returns internal buffer position
+func (m *SecretBuffer) Pos() int {
+	return m.s.pos
+}
+
+// returns a copy of internal buffer as a byte slice
+// NOTE:
+//	- the copy is not tracked, zero it when done or use WithBytes instead
+func (m *SecretBuffer) Bytes() []byte {
+	r := make([]byte, len(m.s.buff))
+	copy(r, m.s.buff)
+	return r
+}
+
+// calls fn with the internal buffer, no copy is made
+// NOTE:
+//	- p must not be retained after fn returns
+func (m *SecretBuffer) WithBytes(fn func(p []byte)) {
+	fn(m.s.buff)
+}
+
+// fmt.Stringer implementation, never shows the contents
+// NOTE:
+//	- value receivers, so a dereferenced SecretBuffer is redacted too
+func (m SecretBuffer) String() string {
+	return KSECRET_REDACTED
+}
+
+// fmt.GoStringer implementation, never shows the contents
+func (m SecretBuffer) GoString() string {
+	return KSECRET_REDACTED
+}
+
+// fmt.Formatter implementation, every verb prints KSECRET_REDACTED
+func (m SecretBuffer) Format(f fmt.State, verb rune) {
+	io.WriteString(f, KSECRET_REDACTED)
+}
+
+// check if p is overflowing buffer
+func (m *SecretBuffer) posOverflow(p int) bool {
+	return p >= len(m.s.buff)
+}
+
+// @SecretBuffer.Seek(offset, io.SeekStart)
+func (m *SecretBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @SecretBuffer.Seek(offset, io.SeekCurrent)
+func (m *SecretBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @SecretBuffer.Seek(offset, io.SeekEnd)
+func (m *SecretBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @SecretBuffer.Seek(0, io.SeekStart)
+func (m *SecretBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @SecretBuffer.Seek(0, io.SeekEnd)
+func (m *SecretBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation, same rules as ByteBuffer.Seek
+// errors:
+//	ErrClosed
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *SecretBuffer) Seek(offset int64, whence int) (int64, error) {
+	if m.s.closed {
+		return -1, ErrClosed
+	}
+	pos, err := seek_abs(offset, whence, m.s.pos, len(m.s.buff))
+	if err != nil {
+		return -1, err
+	}
+	if m.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+	m.s.pos = pos
+	return int64(pos), nil
+}
+
+func (m *SecretBuffer) readFromPos(p []byte, pos int) (n int, err error) {
+	avail := len(m.s.buff) - pos
+	if avail <= 0 {
+		return 0, io.EOF
+	}
+	n = copy(p, m.s.buff[pos:])
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// io.Reader implementation, same rules as ByteBuffer.Read
+// errors:
+//	ErrClosed
+//	io.EOF
+func (m *SecretBuffer) Read(p []byte) (n int, err error) {
+	if m.s.closed {
+		return 0, ErrClosed
+	}
+	n, err = m.readFromPos(p, m.s.pos)
+	if n > 0 {
+		m.s.pos += n
+	}
+	return n, err
+}
+
+// io.ReaderAt implementation, same rules as ByteBuffer.ReadAt
+// errors:
+//	ErrClosed
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+//	io.EOF
+func (m *SecretBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	if m.s.closed {
+		return -1, ErrClosed
+	}
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+	return m.readFromPos(p, int(off))
+}
+
+// io.ByteReader implementation
+func (m *SecretBuffer) ReadByte() (byte, error) {
+	p := make([]byte, 1)
+	n, err := m.Read(p)
+	if err != nil {
+		return 0, err
+	}
+	if n != 1 {
+		return 0, ErrByteRead
+	}
+	return p[0], nil
+}
+
+// returns a byte at a specific position in buffer
+func (m *SecretBuffer) ByteAt(pos int) (byte, error) {
+	p := make([]byte, 1)
+	n, err := m.ReadAt(p, int64(pos))
+	if err != nil {
+		return 0, err
+	}
+	if n != 1 {
+		return 0, ErrByteRead
+	}
+	return p[0], nil
+}
+
+// makes room for (size) bytes, growing moves the contents to a new array and
+// zeroes the old one
+// errors:
+//	syscall errors, if the buffer is locked and the new array can't be
+func (m *SecretBuffer) grow(size int) error {
+	if size <= cap(m.s.buff) {
+		m.s.buff = m.s.buff[:size]
+		return nil
+	}
+	wasLocked := m.s.locked
+	l := len(m.s.buff)
+	old, err := m.replaceArray(size, max_int(size, 2*cap(m.s.buff)))
+	if err != nil {
+		return err
+	}
+	copy(m.s.buff, old[:l])
+	m.wipe(old, wasLocked)
+	return nil
+}
+
+func (m *SecretBuffer) writeFromPos(p []byte, pos int) (appended int, written int, err error) {
+	end := pos + len(p)
+	if end > len(m.s.buff) {
+		appended = end - len(m.s.buff)
+		if err := m.grow(end); err != nil {
+			return 0, 0, err
+		}
+	}
+	copy(m.s.buff[pos:], p)
+	return appended, len(p), nil
+}
+
+// io.Writer implementation, same rules as ByteBuffer.Write
+// errors:
+//	ErrClosed
+//	syscall errors, if the buffer is locked and growing it fails to lock the
+//		new array, nothing is written
+func (m *SecretBuffer) Write(p []byte) (n int, err error) {
+	if m.s.closed {
+		return -1, ErrClosed
+	}
+	appended, written, err := m.writeFromPos(p, m.s.pos)
+	m.s.pos += appended
+	return written, err
+}
+
+// io.WriteAt implementation, same rules as ByteBuffer.WriteAt
+// errors:
+//	ErrClosed
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+//	syscall errors, same as Write
+func (m *SecretBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	if m.s.closed {
+		return -1, ErrClosed
+	}
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) {
+		return -1, ErrOffsetOverflow
+	}
+	appended, written, err := m.writeFromPos(p, int(off))
+	m.s.pos += appended
+	return written, err
+}
+
+// io.ByteWriter implementation, always appends
+// errors:
+//	ErrClosed
+//	syscall errors, same as Write
+func (m *SecretBuffer) WriteByte(c byte) error {
+	if m.s.closed {
+		return ErrClosed
+	}
+	if _, _, err := m.writeFromPos([]byte{c}, len(m.s.buff)); err != nil {
+		return err
+	}
+	m.s.pos = len(m.s.buff)
+	return nil
+}
@@ -0,0 +1,108 @@
+//go:build linux
+
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// mlock works on whole pages and small arrays share pages, so pages are
+// reference counted and only unlocked once no locked array uses them
+var (
+	lockedPagesMu sync.Mutex
+	// number of locked arrays on each page, keyed by page address
+	lockedPages = map[uintptr]int{}
+)
+
+// returns the addresses of the first and one past the last page of p
+func page_range(p []byte) (from uintptr, to uintptr, size uintptr) {
+	size = uintptr(os.Getpagesize())
+	start := uintptr(unsafe.Pointer(&p[0]))
+	from = start &^ (size - 1)
+	to = (start + uintptr(len(p)) + size - 1) &^ (size - 1)
+	return from, to, size
+}
+
+// locks p in memory, nil only checks for support
+func mlock(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	lockedPagesMu.Lock()
+	defer lockedPagesMu.Unlock()
+
+	from, to, size := page_range(p)
+	for pg := from; pg < to; pg += size {
+		if lockedPages[pg] == 0 {
+			if _, _, e := syscall.Syscall(syscall.SYS_MLOCK, pg, size, 0); e != 0 {
+				// pages locked so far are given back
+				unlock_pages(from, pg, size)
+				return e
+			}
+		}
+		lockedPages[pg]++
+	}
+	return nil
+}
+
+// undoes mlock
+func munlock(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	lockedPagesMu.Lock()
+	defer lockedPagesMu.Unlock()
+
+	from, to, size := page_range(p)
+	return unlock_pages(from, to, size)
+}
+
+// drops a reference to each page in [from, to), pages no longer referenced
+// are unlocked, lockedPagesMu must be held
+func unlock_pages(from, to, size uintptr) error {
+	var err error
+	for pg := from; pg < to; pg += size {
+		n := lockedPages[pg]
+		if n == 0 {
+			continue
+		}
+		if n > 1 {
+			lockedPages[pg] = n - 1
+			continue
+		}
+		delete(lockedPages, pg)
+		if _, _, e := syscall.Syscall(syscall.SYS_MUNLOCK, pg, size, 0); e != 0 && err == nil {
+			err = e
+		}
+	}
+	return err
+}
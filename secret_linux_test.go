@@ -0,0 +1,78 @@
+//go:build linux
+
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"testing"
+)
+
+func TestSecretBufferSharedPages(t *testing.T) {
+	tag := "SecretBuffer(shared pages)"
+
+	// two small buffers most likely sharing a page
+	a := NewSecretBuffer(16)
+	b := NewSecretBuffer(16)
+	if err := a.Lock(); err != nil {
+		t.Skipf(tag+" mlock not permitted here: %v", err)
+	}
+	if err := b.Lock(); err != nil {
+		t.Skipf(tag+" mlock not permitted here: %v", err)
+	}
+	pa, _, _ := page_range(a.s.buff[:cap(a.s.buff)])
+	pb, _, _ := page_range(b.s.buff[:cap(b.s.buff)])
+
+	// closing b must not unlock a page a still uses
+	b.Close()
+	if lockedPages[pa] == 0 {
+		t.Fatalf(tag+" page of a unlocked by b, shared %v", pa == pb)
+	}
+	a.Close()
+	if lockedPages[pa] != 0 || lockedPages[pb] != 0 {
+		t.Fatal(tag + " pages still referenced after Close")
+	}
+
+	// two halves of one array always share a page
+	p := make([]byte, 32)
+	page, _, _ := page_range(p)
+	if err := mlock(p[:16]); err != nil {
+		t.Fatalf(tag+" unexpected mlock error [%v]", err)
+	}
+	mlock(p[16:])
+	if lockedPages[page] != 2 {
+		t.Fatalf(tag+" unexpected page count, expected 2, found %v", lockedPages[page])
+	}
+	munlock(p[:16])
+	if lockedPages[page] != 1 {
+		t.Fatalf(tag+" unexpected page count, expected 1, found %v", lockedPages[page])
+	}
+	munlock(p[16:])
+	if _, ok := lockedPages[page]; ok {
+		t.Fatal(tag + " page not released")
+	}
+}
@@ -0,0 +1,191 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// returns true if p is all ZERO bytes
+func allZero(p []byte) bool {
+	for _, c := range p {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSecretBufferZeroes(t *testing.T) {
+	tag := "SecretBuffer(zeroes)"
+
+	key := []byte("correct horse battery staple")
+	b := NewSecretBufferFrom(key)
+	if !b.EqualBytes(key) || b.Pos() != 0 {
+		t.Fatal(tag + " unexpected contents")
+	}
+
+	// growth moves the contents and zeroes the old array
+	old := b.s.buff[:cap(b.s.buff)]
+	for _, c := range []byte(" and more!") {
+		b.WriteByte(c)
+	}
+	if !allZero(old) {
+		t.Fatalf(tag+" old array not zeroed on growth [%x]", old)
+	}
+	if !b.EqualBytes([]byte("correct horse battery staple and more!")) {
+		t.Fatalf(tag+" unexpected contents after growth, size %v", b.Size())
+	}
+	if n, err := b.Read(make([]byte, 4)); n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+
+	// Reset keeps a large enough array but zeroes all of it
+	arr := b.s.buff[:cap(b.s.buff)]
+	b.Reset(4)
+	if &arr[0] != &b.s.buff[0] || !allZero(arr) {
+		t.Fatal(tag + " Reset did not zero the kept array")
+	}
+
+	// Reset to a larger size drops the array
+	b.Write([]byte("abcd"))
+	arr = b.s.buff[:cap(b.s.buff)]
+	b.Reset(uint(cap(arr)) + 1)
+	if !allZero(arr) || !allZero(b.s.buff) {
+		t.Fatal(tag + " Reset did not zero the dropped array")
+	}
+
+	b.Write([]byte("secret"))
+	arr = b.s.buff[:cap(b.s.buff)]
+	b.Clear()
+	if !allZero(arr) || b.Size() != 0 {
+		t.Fatal(tag + " Clear did not zero the array")
+	}
+
+	b.Write([]byte("secret"))
+	arr = b.s.buff[:cap(b.s.buff)]
+	b.Close()
+	if !allZero(arr) || !b.Closed() {
+		t.Fatal(tag + " Close did not zero the array")
+	}
+	if _, err := b.Write([]byte("x")); err != ErrClosed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrClosed, errOrNilStr(err))
+	}
+	if pos, err := b.SeekToStart(); pos != -1 || err != ErrClosed {
+		t.Fatalf(tag+" unexpected seek on a closed buffer %v [%v]", pos, errOrNilStr(err))
+	}
+	if n, err := b.Read(make([]byte, 1)); n != 0 || err != ErrClosed {
+		t.Fatalf(tag+" unexpected read %v, expected [%v], found [%v]", n, ErrClosed, errOrNilStr(err))
+	}
+	b.Reset(2)
+	if b.Closed() || b.Size() != 2 {
+		t.Fatal(tag + " Reset did not reopen the buffer")
+	}
+}
+
+func TestSecretBufferEqual(t *testing.T) {
+	tag := "SecretBuffer.Equal()"
+
+	a := NewSecretBufferFrom([]byte("token-1234"))
+	b := NewSecretBufferFrom([]byte("token-1234"))
+	c := NewSecretBufferFrom([]byte("token-1235"))
+	d := NewSecretBufferFrom([]byte("token-123"))
+
+	if !a.Equal(b) || a.Equal(c) || a.Equal(d) {
+		t.Fatal(tag + " unexpected comparison result")
+	}
+	if !NewSecretBuffer(0).Equal(NewSecretBuffer(0)) {
+		t.Fatal(tag + " empty buffers differ")
+	}
+}
+
+func TestSecretBufferFormat(t *testing.T) {
+	tag := "SecretBuffer(fmt)"
+
+	b := NewSecretBufferFrom([]byte("hunter2"))
+	outputs := []string{
+		b.String(),
+		fmt.Sprint(b),
+		fmt.Sprintf("%v %+v %#v %s %q %x %X %d", b, b, b, b, b, b, b, b),
+		fmt.Sprintf("%v", struct{ Key *SecretBuffer }{b}),
+		// dereferenced values must not print the contents either
+		fmt.Sprint(*b),
+		fmt.Sprintf("%v %+v %#v %s", *b, *b, *b, *b),
+		fmt.Sprintf("%v %+v %#v", struct{ Key SecretBuffer }{*b}, struct{ Key SecretBuffer }{*b}, struct{ Key SecretBuffer }{*b}),
+	}
+	for _, s := range outputs {
+		if bytes.Contains([]byte(s), []byte("hunter2")) || bytes.Contains([]byte(s), []byte(fmt.Sprintf("%x", "hunter2"))) {
+			t.Fatalf(tag+" contents printed [%v]", s)
+		}
+		if !bytes.Contains([]byte(s), []byte(KSECRET_REDACTED)) {
+			t.Fatalf(tag+" unexpected output [%v]", s)
+		}
+	}
+
+	// contents still come out through WithBytes
+	b.WithBytes(func(p []byte) {
+		if string(p) != "hunter2" {
+			t.Fatalf(tag+" unexpected contents [%v]", string(p))
+		}
+	})
+}
+
+func TestSecretBufferLock(t *testing.T) {
+	tag := "SecretBuffer.Lock()"
+
+	b := NewSecretBuffer(64)
+	err := b.Lock()
+	if err == ErrMlockUnsupported {
+		t.Skip(tag + " not supported on this platform")
+	}
+	if err != nil {
+		t.Skipf(tag+" mlock not permitted here: %v", err)
+	}
+	if !b.Locked() {
+		t.Fatal(tag + " not locked")
+	}
+
+	// growth keeps the lock on the new array
+	b.Write(make([]byte, 4096))
+	if !b.Locked() {
+		t.Fatal(tag + " lock lost on growth")
+	}
+	if err = b.Unlock(); err != nil || b.Locked() {
+		t.Fatalf(tag+" unexpected unlock error [%v]", errOrNilStr(err))
+	}
+	b.Lock()
+	b.Close()
+	if b.Locked() {
+		t.Fatal(tag + " still locked after Close")
+	}
+	if err = b.Lock(); err != ErrClosed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrClosed, errOrNilStr(err))
+	}
+}
@@ -0,0 +1,392 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// granularity of copy-on-write in between a ByteBuffer and its snapshots
+const KSNAPSHOT_PAGE_SIZE = 4096
+
+// a read-only, point in time view of a ByteBuffer, see ByteBuffer.Snapshot
+// pages are shared with the buffer, a page is copied into the snapshot right
+// before the buffer writes to it, so only touched pages are ever copied
+// the API mirrors ByteBuffer, writes return ErrReadOnly
+// Reset and Clear are left out, they can't report ErrReadOnly, Release drops
+// the contents instead
+// NOTE:
+//	- ReadAt, ByteAt, Bytes, Clone and CmpWith may run on any number of
+//		goroutines while the owner keeps writing, the owner copies a page under
+//		the snapshot lock before it writes to it
+//	- Read, ReadByte and Seek move the snapshot position, like ByteBuffer they
+//		are for one goroutine at a time
+//	- Release must not run concurrently with the owner writes nor with other
+//		calls on the snapshot, it edits the owner's list of snapshots
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//	io.ByteReader
+//	io.ByteWriter
+type ByteSnapshot struct {
+	owner *ByteBuffer
+	// guards the page table, readers hold it while copying out of pages
+	mu    sync.RWMutex
+	pages [][]byte
+	// pages still sharing memory with owner
+	shared  []bool
+	nshared int
+	copied  int
+	size    int
+	pos     int
+}
+
+// returns a read-only snapshot of this buffer as it is right now, no data is
+// copied until this buffer writes over it
+// position in the snapshot is set to ZERO
+// NOTE:
+//	- call ByteSnapshot.Release once done, a buffer with live snapshots checks
+//		them on every write
+func (m *ByteBuffer) Snapshot() *ByteSnapshot {
+	m.checkReleased()
+
+	size := len(m.buff)
+	n := (size + KSNAPSHOT_PAGE_SIZE - 1) / KSNAPSHOT_PAGE_SIZE
+	s := &ByteSnapshot{
+		owner:   m,
+		pages:   make([][]byte, n),
+		shared:  make([]bool, n),
+		nshared: n,
+		size:    size,
+	}
+	for i := 0; i < n; i++ {
+		end := min_int((i+1)*KSNAPSHOT_PAGE_SIZE, size)
+		s.pages[i] = m.buff[i*KSNAPSHOT_PAGE_SIZE : end : end]
+		s.shared[i] = true
+	}
+	if n > 0 {
+		m.snaps = append(m.snaps, s)
+	}
+	return s
+}
+
+// called right before buff[from:to] is written to, to may go past the
+// capacity of buff in which case buff is about to be reallocated
+func (m *ByteBuffer) protect(from, to int) {
+	if len(m.snaps) == 0 {
+		return
+	}
+
+	// a new backing array is never seen by snapshots, they keep the old one
+	if to > cap(m.buff) {
+		for _, s := range m.snaps {
+			s.detach()
+		}
+		m.snaps = nil
+		return
+	}
+
+	live := m.snaps[:0]
+	for _, s := range m.snaps {
+		s.unshare(from, to)
+		if s.nshared > 0 {
+			live = append(live, s)
+		} else {
+			s.owner = nil
+		}
+	}
+	for i := len(live); i < len(m.snaps); i++ {
+		m.snaps[i] = nil
+	}
+	m.snaps = live
+}
+
+// copies shared pages overlapping [from, to)
+func (m *ByteSnapshot) unshare(from, to int) {
+	if to <= from {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	first := from / KSNAPSHOT_PAGE_SIZE
+	last := min_int((to-1)/KSNAPSHOT_PAGE_SIZE, len(m.pages)-1)
+	for i := first; i <= last; i++ {
+		if !m.shared[i] {
+			continue
+		}
+		page := make([]byte, len(m.pages[i]))
+		copy(page, m.pages[i])
+		m.pages[i] = page
+		m.shared[i] = false
+		m.nshared--
+		m.copied++
+	}
+}
+
+// stops tracking the owner, pages left shared are no longer written by it
+func (m *ByteSnapshot) detach() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.owner = nil
+	for i := range m.shared {
+		m.shared[i] = false
+	}
+	m.nshared = 0
+}
+
+// drops the snapshot, it reads as empty afterwards
+// the owner stops checking it on writes
+func (m *ByteSnapshot) Release() {
+	if m.owner != nil {
+		snaps := m.owner.snaps
+		for i, s := range snaps {
+			if s == m {
+				m.owner.snaps = append(snaps[:i], snaps[i+1:]...)
+				snaps[len(snaps)-1] = nil
+				break
+			}
+		}
+	}
+	m.detach()
+	m.mu.Lock()
+	m.pages = nil
+	m.shared = nil
+	m.size = 0
+	m.pos = 0
+	m.mu.Unlock()
+}
+
+// returns the number of pages copied so far, because the owner wrote to them
+func (m *ByteSnapshot) CopiedPages() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.copied
+}
+
+// returns true if the snapshot holds no data
+func (m *ByteSnapshot) Empty() bool {
+	return m.size == 0
+}
+
+// compare contents of this and other
+func (m *ByteSnapshot) CmpWith(other *ByteBuffer) int {
+	return bytes.Compare(m.Bytes(), other.buff)
+}
+
+// returns a new writable ByteBuffer holding the contents of the snapshot
+// position in the clone is set to ZERO
+func (m *ByteSnapshot) Clone() *ByteBuffer {
+	r := NewByteBuffer(0)
+	r.buff = m.Bytes()
+	return r
+}
+
+// returns size in bytes of the snapshot
+func (m *ByteSnapshot) Size() uint {
+	return uint(m.size)
+}
+
+// returns snapshot position
+func (m *ByteSnapshot) Pos() int {
+	return m.pos
+}
+
+// returns a copy of the snapshot as a byte slice
+func (m *ByteSnapshot) Bytes() []byte {
+	r := make([]byte, m.size)
+	m.readFromPos(r, 0, false)
+	return r
+}
+
+// check if p is overflowing snapshot
+func (m *ByteSnapshot) posOverflow(p int) bool {
+	return p >= m.size
+}
+
+// @ByteSnapshot.Seek(offset, io.SeekStart)
+func (m *ByteSnapshot) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @ByteSnapshot.Seek(offset, io.SeekCurrent)
+func (m *ByteSnapshot) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @ByteSnapshot.Seek(offset, io.SeekEnd)
+func (m *ByteSnapshot) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @ByteSnapshot.Seek(0, io.SeekStart)
+func (m *ByteSnapshot) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @ByteSnapshot.Seek(0, io.SeekEnd)
+func (m *ByteSnapshot) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation, same rules as ByteBuffer.Seek
+// returns offset position if err == nil
+// errors:
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *ByteSnapshot) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seek_abs(offset, whence, m.pos, m.size)
+	if err != nil {
+		return -1, err
+	}
+
+	// check for overflow
+	if m.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+
+	m.pos = pos
+
+	return int64(pos), nil
+}
+
+func (m *ByteSnapshot) readFromPos(p []byte, pos int, incPos bool) (n int, err error) {
+	l := len(p)
+
+	// number of available bytes to read from position
+	avail := m.size - pos
+	if avail > 0 {
+		// read the minimum amount of bytes
+		n = min_int(avail, l)
+
+		// copy page by page, the owner waits before writing to a shared page
+		m.mu.RLock()
+		for c := 0; c < n; {
+			i, off := (pos+c)/KSNAPSHOT_PAGE_SIZE, (pos+c)%KSNAPSHOT_PAGE_SIZE
+			c += copy(p[c:n], m.pages[i][off:])
+		}
+		m.mu.RUnlock()
+
+		// increment position only if called by Read, ReadAt also calls this function
+		if incPos {
+			m.pos += n
+		}
+
+		// check if we've read less bytes than the size of p
+		if n < l {
+			err = io.EOF
+		}
+		return
+	}
+	return 0, io.EOF
+}
+
+// io.Reader implementation, @ByteBuffer.Read
+func (m *ByteSnapshot) Read(p []byte) (n int, err error) {
+	return m.readFromPos(p, m.pos, true)
+}
+
+// io.ReaderAt implementation, @ByteBuffer.ReadAt
+func (m *ByteSnapshot) ReadAt(p []byte, off int64) (n int, err error) {
+	pos := int(off)
+
+	// sanity checks
+	if pos < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return -1, ErrOffsetOverflow
+	}
+
+	return m.readFromPos(p, pos, false)
+}
+
+// io.ByteReader implementation
+func (m *ByteSnapshot) ReadByte() (byte, error) {
+	c, err := m.ByteAt(m.pos)
+	if err != nil {
+		return 0, io.EOF
+	}
+	m.pos++
+	return c, nil
+}
+
+// returns a byte at a specific position in snapshot
+func (m *ByteSnapshot) ByteAt(pos int) (byte, error) {
+	if pos < 0 {
+		return 0, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) {
+		return 0, ErrOffsetOverflow
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pages[pos/KSNAPSHOT_PAGE_SIZE][pos%KSNAPSHOT_PAGE_SIZE], nil
+}
+
+// reads and returns an uint64s or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, the varint is truncated
+//	ErrVarintOverflow
+// NOTE:
+//	- on error the position is left unchanged, @ByteBuffer.ReadUInt64Var
+func (m *ByteSnapshot) ReadUInt64Var() (uint64, error) {
+	x, n, err := read_uvarint_at(m.readFromPos, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	return x, nil
+}
+
+// io.Writer implementation, always returns ErrReadOnly
+func (m *ByteSnapshot) Write(p []byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// io.WriteAt implementation, always returns ErrReadOnly
+func (m *ByteSnapshot) WriteAt(p []byte, off int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// io.ByteWriter implementation, always returns ErrReadOnly
+func (m *ByteSnapshot) WriteByte(c byte) error {
+	return ErrReadOnly
+}
+
+// always returns ErrReadOnly
+func (m *ByteSnapshot) WriteUInt64Var(x uint64) (int, error) {
+	return 0, ErrReadOnly
+}
@@ -0,0 +1,209 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestByteSnapshotIsolation(t *testing.T) {
+	tag := "ByteBuffer.Snapshot(isolation)"
+
+	rnd := rand.New(rand.NewSource(3))
+	b := NewByteBuffer(0)
+	data := make([]byte, KSNAPSHOT_PAGE_SIZE*8+100)
+	rnd.Read(data)
+	b.Write(data)
+
+	snaps := []*ByteSnapshot{}
+	expected := [][]byte{}
+
+	// interleave snapshots with every kind of write, no write may leak
+	for i := 0; i < 300; i++ {
+		if i%20 == 0 {
+			snaps = append(snaps, b.Snapshot())
+			expected = append(expected, b.Bytes())
+		}
+		p := make([]byte, rnd.Intn(KSNAPSHOT_PAGE_SIZE))
+		rnd.Read(p)
+		switch rnd.Intn(10) {
+		case 0:
+			b.WriteByte(byte(i))
+		case 1:
+			b.Reset(uint(rnd.Intn(len(data))))
+		case 2:
+			b.Truncate(uint(rnd.Intn(int(b.Size()) + 1)))
+		case 3:
+			// writes, shrinking and growing undone by a rollback
+			tx := b.Begin()
+			if b.Size() > 0 {
+				b.WriteAt(p, int64(rnd.Intn(int(b.Size()))))
+			}
+			b.Truncate(b.Size() / 2)
+			b.WriteByte(byte(i))
+			tx.Rollback()
+		default:
+			if b.Size() > 0 {
+				b.WriteAt(p, int64(rnd.Intn(int(b.Size()))))
+			} else {
+				b.Write(p)
+			}
+		}
+	}
+
+	for i, s := range snaps {
+		if bytes.Compare(expected[i], s.Bytes()) != 0 {
+			t.Fatalf(tag+" snapshot #%v modified by writes", i)
+		}
+		if s.Size() != uint(len(expected[i])) {
+			t.Fatalf(tag+" snapshot #%v size error, expected %v, found %v", i, len(expected[i]), s.Size())
+		}
+	}
+}
+
+func TestByteSnapshotCopiedPages(t *testing.T) {
+	tag := "ByteBuffer.Snapshot(pages)"
+
+	b := NewByteBuffer(KSNAPSHOT_PAGE_SIZE * 4)
+	s := b.Snapshot()
+
+	// touch a single page, twice
+	b.WriteAt([]byte("abracadabra"), KSNAPSHOT_PAGE_SIZE+10)
+	b.WriteAt([]byte("abracadabra"), KSNAPSHOT_PAGE_SIZE+100)
+	if s.CopiedPages() != 1 {
+		t.Fatalf(tag+" unexpected number of copied pages, expected 1, found %v", s.CopiedPages())
+	}
+
+	// a write spanning two pages
+	b.WriteAt([]byte("abracadabra"), KSNAPSHOT_PAGE_SIZE*3-5)
+	if s.CopiedPages() != 3 {
+		t.Fatalf(tag+" unexpected number of copied pages, expected 3, found %v", s.CopiedPages())
+	}
+
+	rbuff := make([]byte, 11)
+	s.ReadAt(rbuff, KSNAPSHOT_PAGE_SIZE+10)
+	if bytes.Compare(rbuff, make([]byte, 11)) != 0 {
+		t.Fatalf(tag+" write leaked into snapshot [%v]", rbuff)
+	}
+	b.ReadAt(rbuff, KSNAPSHOT_PAGE_SIZE+10)
+	if string(rbuff) != "abracadabra" {
+		t.Fatalf(tag+" write lost [%v]", string(rbuff))
+	}
+
+	s.Release()
+	if len(b.snaps) != 0 || !s.Empty() {
+		t.Fatalf(tag+" snapshot not released, %v tracked", len(b.snaps))
+	}
+}
+
+func TestByteSnapshotReadOnly(t *testing.T) {
+	tag := "ByteSnapshot(read-only)"
+
+	b := NewByteBuffer(0)
+	b.Write([]byte("abracadabra"))
+	s := b.Snapshot()
+
+	_, err := s.Write([]byte("x"))
+	if err != ErrReadOnly {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrReadOnly.Error(), errOrNilStr(err))
+	}
+	err = s.WriteByte('x')
+	if err != ErrReadOnly {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrReadOnly.Error(), errOrNilStr(err))
+	}
+
+	s.SeekFromEnd(-4)
+	dst := NewByteBuffer(0)
+	io.Copy(dst, s)
+	if string(dst.Bytes()) != "abra" {
+		t.Fatalf(tag+" unexpected io.Copy result [%v]", string(dst.Bytes()))
+	}
+	n, err := s.Read(make([]byte, 4))
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+	s.SeekToStart()
+	data, err := io.ReadAll(s)
+	if err != nil || string(data) != "abracadabra" {
+		t.Fatalf(tag+" unexpected io.ReadAll result [%v] %v", string(data), errOrNilStr(err))
+	}
+	if s.CmpWith(b) != 0 || s.Clone().CmpWith(b) != 0 {
+		t.Fatal(tag + " data mismatch")
+	}
+}
+
+func TestByteSnapshotConcurrentReaders(t *testing.T) {
+	tag := "ByteSnapshot(concurrent readers)"
+
+	data := make([]byte, KSNAPSHOT_PAGE_SIZE*4)
+	for i := range data {
+		data[i] = byte(i / KSNAPSHOT_PAGE_SIZE)
+	}
+	b := NewByteBuffer(0)
+	b.Write(data)
+	s := b.Snapshot()
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			p := make([]byte, KSNAPSHOT_PAGE_SIZE)
+			for i := 0; i < 200; i++ {
+				off := (i + r) % 4 * KSNAPSHOT_PAGE_SIZE
+				s.ReadAt(p, int64(off))
+				for _, c := range p {
+					if c != byte(off/KSNAPSHOT_PAGE_SIZE) {
+						t.Errorf(tag+" torn page @%v, found %v", off, c)
+						return
+					}
+				}
+			}
+		}(r)
+	}
+
+	// the owner writes all over the shared pages meanwhile
+	p := make([]byte, 100)
+	for i := range p {
+		p[i] = 0xff
+	}
+	for i := 0; i < 200; i++ {
+		b.WriteAt(p, int64(i*97%(len(data)-len(p))))
+	}
+	tx := b.Begin()
+	b.Truncate(10)
+	tx.Rollback()
+	wg.Wait()
+
+	if !bytes.Equal(s.Bytes(), data) {
+		t.Fatal(tag + " snapshot modified by writes")
+	}
+}
@@ -0,0 +1,476 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// where SpillBuffer currently keeps its data
+type SpillMode int
+
+const (
+	// data is held in memory
+	KSPILL_MEMORY SpillMode = iota
+	// data was moved to a temporary file
+	KSPILL_FILE
+)
+
+// a byte buffer that keeps data in memory until it grows past a threshold,
+// then moves it to a temporary file and carries on from there
+// the API mirrors ByteBuffer, cursor and overflow rules are the same in both modes
+// Clone also returns an error, the temporary files may fail
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//  io.WriterTo
+//	io.ByteReader
+//	io.ByteWriter
+//  io.Closer
+type SpillBuffer struct {
+	mem       *ByteBuffer
+	f         *os.File
+	dir       string
+	threshold int
+	size      int
+	pos       int
+	closed    bool
+}
+
+// create a new empty SpillBuffer which moves to a temporary file in (dir)
+// once it holds more than (threshold) bytes
+// NOTE:
+//	- passing an empty dir selects os.TempDir()
+//	- call Close once done in order to remove the temporary file
+func NewSpillBuffer(threshold uint, dir string) *SpillBuffer {
+	return &SpillBuffer{
+		mem:       NewByteBuffer(0),
+		dir:       dir,
+		threshold: int(threshold),
+	}
+}
+
+// returns KSPILL_MEMORY or KSPILL_FILE
+func (m *SpillBuffer) Mode() SpillMode {
+	if m.f != nil {
+		return KSPILL_FILE
+	}
+	return KSPILL_MEMORY
+}
+
+// returns the name of the temporary file, empty while data is in memory
+func (m *SpillBuffer) Name() string {
+	if m.f == nil {
+		return ""
+	}
+	return m.f.Name()
+}
+
+// releases memory and removes the temporary file, if any
+// any further read or write returns ErrClosed
+func (m *SpillBuffer) Close() error {
+	if m.closed {
+		return ErrClosed
+	}
+	m.closed = true
+	m.mem = nil
+	m.size = 0
+	m.pos = 0
+	return m.dropFile()
+}
+
+// closes and removes the temporary file, if any
+func (m *SpillBuffer) dropFile() error {
+	if m.f == nil {
+		return nil
+	}
+	err := m.f.Close()
+	if rerr := os.Remove(m.f.Name()); err == nil {
+		err = rerr
+	}
+	m.f = nil
+	return err
+}
+
+// resizes the buffer to (size) ZERO bytes held in memory, position is reset
+// to ZERO, the temporary file is removed
+// NOTE:
+//	- resets a closed buffer too, making it usable again
+//	- a size past the threshold spills on the next write
+func (m *SpillBuffer) Reset(size uint) *SpillBuffer {
+	m.dropFile()
+	m.mem = NewByteBuffer(size)
+	m.size = int(size)
+	m.pos = 0
+	m.closed = false
+	return m
+}
+
+// @SpillBuffer.Reset(0)
+func (m *SpillBuffer) Clear() *SpillBuffer {
+	return m.Reset(0)
+}
+
+// returns a new clone of this, with the same threshold and directory
+// position in the clone is set to ZERO
+// errors:
+//	ErrClosed
+//	any error returned by the temporary files
+func (m *SpillBuffer) Clone() (*SpillBuffer, error) {
+	r := NewSpillBuffer(uint(m.threshold), m.dir)
+	_, err := m.WriteTo(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.pos = 0
+	return r, nil
+}
+
+// returns a copy of the contents as a byte slice, read back from the file
+// once spilled
+// NOTE:
+//	- returns nil on a closed buffer or if the file can't be read, WriteTo
+//		reports the error
+func (m *SpillBuffer) Bytes() []byte {
+	if m.closed {
+		return nil
+	}
+	r := NewByteBuffer(0)
+	if _, err := m.WriteTo(r); err != nil {
+		return nil
+	}
+	return r.buff
+}
+
+// returns true if the buffer holds no data
+func (m *SpillBuffer) Empty() bool {
+	return m.size == 0
+}
+
+// returns size in bytes of the data held in buffer
+func (m *SpillBuffer) Size() uint {
+	return uint(m.size)
+}
+
+// returns internal buffer position
+func (m *SpillBuffer) Pos() int {
+	return m.pos
+}
+
+// moves data held in memory to a new temporary file
+func (m *SpillBuffer) spill() error {
+	f, err := os.CreateTemp(m.dir, "mbytes-spill-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(m.mem.buff)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	m.f = f
+	m.mem = nil
+	return nil
+}
+
+// check if p is overflowing buffer
+func (m *SpillBuffer) posOverflow(p int) bool {
+	return p >= m.size
+}
+
+// @SpillBuffer.Seek(offset, io.SeekStart)
+func (m *SpillBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @SpillBuffer.Seek(offset, io.SeekCurrent)
+func (m *SpillBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @SpillBuffer.Seek(offset, io.SeekEnd)
+func (m *SpillBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @SpillBuffer.Seek(0, io.SeekStart)
+func (m *SpillBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @SpillBuffer.Seek(0, io.SeekEnd)
+func (m *SpillBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// io.Seeker implementation
+// returns offset position if err == nil
+// errors:
+//	ErrClosed
+//	ErrSeekNegative
+//	ErrSeekOverflow
+//	ErrWhenceUnknown
+func (m *SpillBuffer) Seek(offset int64, whence int) (int64, error) {
+	if m.closed {
+		return -1, ErrClosed
+	}
+
+	pos, err := seek_abs(offset, whence, m.pos, m.size)
+	if err != nil {
+		return -1, err
+	}
+
+	// check for overflow
+	if m.posOverflow(pos) {
+		return -1, ErrSeekOverflow
+	}
+
+	// update position
+	m.pos = pos
+
+	return int64(pos), nil
+}
+
+func (m *SpillBuffer) readFromPos(p []byte, pos int, incPos bool) (n int, err error) {
+	if m.closed {
+		return 0, ErrClosed
+	}
+
+	l := len(p)
+
+	// number of available bytes to read from position
+	avail := m.size - pos
+	if avail <= 0 {
+		return 0, io.EOF
+	}
+
+	// read the minimum amount of bytes
+	n = min_int(avail, l)
+	if m.f == nil {
+		copy(p, m.mem.buff[pos:pos+n])
+	} else {
+		n, err = m.f.ReadAt(p[:n], int64(pos))
+		if err != nil {
+			return n, err
+		}
+	}
+
+	// increment position only if called by Read, ReadAt also calls this function
+	if incPos {
+		m.pos += n
+	}
+
+	// check if we've read less bytes than the size of p
+	if n < l {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// io.Reader implementation
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrClosed
+//	any error returned by the temporary file
+// NOTE:
+//	- will return io.EOF error if the number of bytes read is less than the
+//		size of p, however, p will contain the first n bytes from buffer
+func (m *SpillBuffer) Read(p []byte) (n int, err error) {
+	return m.readFromPos(p, m.pos, true)
+}
+
+// io.ReaderAt implementation
+// reads up to len(p) from buffer at offset off
+// returns number of read bytes
+// errors:
+//	io.EOF
+//	ErrClosed
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+//	any error returned by the temporary file
+// NOTE:
+//	- ReadAt will NOT modify internal position
+func (m *SpillBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	pos := int(off)
+
+	// sanity checks
+	if pos < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(pos) && !m.closed {
+		return -1, ErrOffsetOverflow
+	}
+
+	return m.readFromPos(p, pos, false)
+}
+
+// writes p at pos, spilling to a temporary file first if the write would take
+// the buffer past threshold
+func (m *SpillBuffer) writeFromPos(p []byte, pos int) (appended int, written int, err error) {
+	if m.closed {
+		return 0, 0, ErrClosed
+	}
+
+	l := len(p)
+	if m.f == nil && pos+l > m.threshold {
+		err = m.spill()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if m.f == nil {
+		m.mem.writeFromPos(p, pos)
+	} else {
+		l, err = m.f.WriteAt(p, int64(pos))
+	}
+
+	// account for whatever made it, even on error
+	if end := pos + l; end > m.size {
+		appended = end - m.size
+		m.size = end
+	}
+
+	return appended, l, err
+}
+
+// io.Writer implementation
+// writes p to buffer at current position
+// errors:
+//	ErrClosed
+//	any error returned by the temporary file
+// NOTE:
+//	- if current position is within the buffer, some or all of the bytes will be
+//		overwritten, same as ByteBuffer.Write
+func (m *SpillBuffer) Write(p []byte) (n int, err error) {
+	appended, written, err := m.writeFromPos(p, m.pos)
+	m.pos += appended
+	return written, err
+}
+
+// io.WriteAt implementation
+// returns
+//	ErrClosed
+//	ErrOffsetNegative
+//	ErrOffsetOverflow
+//	any error returned by the temporary file
+func (m *SpillBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	// sanity checks
+	if off < 0 {
+		return -1, ErrOffsetNegative
+	}
+	if m.posOverflow(int(off)) && !m.closed {
+		return -1, ErrOffsetOverflow
+	}
+
+	appended, written, err := m.writeFromPos(p, int(off))
+
+	// in case of overwrite + append, we want to move the position to the last
+	// appended byte in buffer
+	m.pos += appended
+
+	return written, err
+}
+
+// io.WriterTo implementation
+// copies the whole buffer to w
+// NOTE:
+//	- position is NOT modified
+func (m *SpillBuffer) WriteTo(w io.Writer) (int64, error) {
+	if m.closed {
+		return 0, ErrClosed
+	}
+	if m.f == nil {
+		n, err := w.Write(m.mem.buff)
+		return int64(n), err
+	}
+	return io.Copy(w, io.NewSectionReader(m.f, 0, int64(m.size)))
+}
+
+// io.ByteReader implementation
+func (m *SpillBuffer) ReadByte() (byte, error) {
+	p := make([]byte, 1)
+	_, err := m.Read(p)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// io.ByteWriter implementation, appends c to buffer, same as ByteBuffer
+func (m *SpillBuffer) WriteByte(c byte) error {
+	_, _, err := m.writeFromPos([]byte{c}, m.size)
+	if err != nil {
+		return err
+	}
+	m.pos = m.size
+	return nil
+}
+
+// returns a byte at a specific position in buffer
+// much like indexing a byte slice
+func (m *SpillBuffer) ByteAt(pos int) (byte, error) {
+	p := make([]byte, 1)
+	_, err := m.ReadAt(p, int64(pos))
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// returns the number of bytes written or error
+func (m *SpillBuffer) WriteUInt64Var(x uint64) (int, error) {
+	buff := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buff, x)
+	return m.Write(buff[:n])
+}
+
+// reads and returns an uint64s or error
+// errors:
+//	io.EOF, no bytes left
+//	io.ErrUnexpectedEOF, the varint is truncated
+//	ErrVarintOverflow
+//	ErrClosed
+//	any error returned by the temporary file
+// NOTE:
+//	- on error the position is left unchanged, @ByteBuffer.ReadUInt64Var
+func (m *SpillBuffer) ReadUInt64Var() (uint64, error) {
+	x, n, err := read_uvarint_at(m.readFromPos, m.pos)
+	if err != nil {
+		return 0, err
+	}
+	m.pos += n
+	return x, nil
+}
@@ -0,0 +1,186 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestSpillBufferSpill(t *testing.T) {
+	tag := "SpillBuffer.Write(spill)"
+
+	b := NewSpillBuffer(16, t.TempDir())
+	expected := NewByteBuffer(0)
+	buff := []byte{'a', 'b', 'c', 'd', 'e', 'f'}
+
+	// same sequence of writes on both buffers, third write spills
+	for i := 0; i < 2; i++ {
+		b.Write(buff)
+		expected.Write(buff)
+	}
+	if b.Mode() != KSPILL_MEMORY || b.Name() != "" {
+		t.Fatalf(tag+" unexpected mode %v before threshold", b.Mode())
+	}
+
+	b.SeekFromStart(11)
+	expected.SeekFromStart(11)
+	b.Write(buff)
+	expected.Write(buff)
+	if b.Mode() != KSPILL_FILE {
+		t.Fatalf(tag+" unexpected mode %v past threshold", b.Mode())
+	}
+
+	off := int64(expected.Size() - 2)
+	b.WriteAt(buff, off)
+	expected.WriteAt(buff, off)
+	b.WriteByte('x')
+	expected.WriteByte('x')
+
+	if b.Size() != expected.Size() || b.Pos() != expected.Pos() {
+		t.Fatalf(tag+" unexpected size/position %v/%v, expected %v/%v", b.Size(), b.Pos(), expected.Size(), expected.Pos())
+	}
+
+	dst := NewByteBuffer(0)
+	b.WriteTo(dst)
+	if dst.CmpWith(expected) != 0 {
+		t.Fatalf(tag+" data mismatch\nexpected [%v]\nfound [%v]", expected.Bytes(), dst.Bytes())
+	}
+
+	// reads go to the file now
+	rbuff := make([]byte, 4)
+	n, err := b.ReadAt(rbuff, 10)
+	if err != nil || bytes.Compare(rbuff[:n], expected.Bytes()[10:14]) != 0 {
+		t.Fatalf(tag+" unexpected read [%v] %v", rbuff[:n], errOrNilStr(err))
+	}
+	b.SeekFromEnd(-1)
+	c, err := b.ReadByte()
+	if err != nil || c != 'x' {
+		t.Fatalf(tag+" unexpected read byte %v %v", c, errOrNilStr(err))
+	}
+	n, err = b.Read(rbuff)
+	if n != 0 || err != io.EOF {
+		t.Fatalf(tag+" unexpected read at the end %v [%v]", n, errOrNilStr(err))
+	}
+	b.SeekToStart()
+	data, err := io.ReadAll(b)
+	if err != nil || !bytes.Equal(data, expected.Bytes()) {
+		t.Fatalf(tag+" unexpected io.ReadAll result [%v] %v", data, errOrNilStr(err))
+	}
+
+	name := b.Name()
+	err = b.Close()
+	if err != nil {
+		t.Fatalf(tag+" unexpected close error: %v", err.Error())
+	}
+	_, err = os.Stat(name)
+	if !os.IsNotExist(err) {
+		t.Fatalf(tag+" temporary file not removed: %v", errOrNilStr(err))
+	}
+	_, err = b.Write(buff)
+	if err != ErrClosed {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrClosed.Error(), errOrNilStr(err))
+	}
+	n, err = b.Read(rbuff)
+	if n != 0 || err != ErrClosed {
+		t.Fatalf(tag+" unexpected read %v, expected [%v], found [%v]", n, ErrClosed.Error(), errOrNilStr(err))
+	}
+}
+
+func TestSpillBufferMemory(t *testing.T) {
+	tag := "SpillBuffer(memory)"
+
+	b := NewSpillBuffer(1024, t.TempDir())
+	for v := uint64(1); v < 1<<40; v *= 3 {
+		b.WriteUInt64Var(v)
+	}
+	b.SeekToStart()
+	for v := uint64(1); v < 1<<40; v *= 3 {
+		x, err := b.ReadUInt64Var()
+		if err != nil {
+			t.Fatalf(tag+" unexpected read error: %v", err.Error())
+		}
+		if x != v {
+			t.Fatalf(tag+" unexpected read value, expected %v, found %v", v, x)
+		}
+	}
+	if b.Mode() != KSPILL_MEMORY {
+		t.Fatalf(tag+" unexpected mode %v", b.Mode())
+	}
+	_, err := b.SeekFromStart(int64(b.Size()))
+	if err != ErrSeekOverflow {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrSeekOverflow.Error(), errOrNilStr(err))
+	}
+	b.Close()
+}
+
+func TestSpillBufferResetClone(t *testing.T) {
+	tag := "SpillBuffer.Reset/Clone()"
+
+	b := NewSpillBuffer(8, t.TempDir())
+	s := "abracadabra"
+	b.Write([]byte(s))
+	if b.Mode() != KSPILL_FILE || string(b.Bytes()) != s {
+		t.Fatalf(tag+" unexpected mode %v, contents [%v]", b.Mode(), string(b.Bytes()))
+	}
+
+	c, err := b.Clone()
+	if err != nil {
+		t.Fatalf(tag+" unexpected clone error: %v", err.Error())
+	}
+	if c.Mode() != KSPILL_FILE || c.Name() == b.Name() || c.Pos() != 0 || string(c.Bytes()) != s {
+		t.Fatalf(tag+" unexpected clone, mode %v, position %v, contents [%v]", c.Mode(), c.Pos(), string(c.Bytes()))
+	}
+	c.Close()
+
+	// back to memory, the file is gone
+	name := b.Name()
+	b.Reset(4)
+	if b.Mode() != KSPILL_MEMORY || b.Size() != 4 || b.Pos() != 0 || !bytes.Equal(b.Bytes(), make([]byte, 4)) {
+		t.Fatalf(tag+" unexpected buffer after Reset, mode %v, size %v", b.Mode(), b.Size())
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf(tag+" temporary file not removed: %v", errOrNilStr(err))
+	}
+
+	// a closed buffer comes back to life
+	b.Close()
+	if b.Bytes() != nil {
+		t.Fatal(tag + " contents of a closed buffer")
+	}
+	if pos, err := b.SeekToStart(); pos != -1 || err != ErrClosed {
+		t.Fatalf(tag+" unexpected seek on a closed buffer %v [%v]", pos, errOrNilStr(err))
+	}
+	b.Clear()
+	b.Write([]byte(s))
+	if b.Mode() != KSPILL_FILE || string(b.Bytes()) != s {
+		t.Fatalf(tag+" unexpected buffer after Clear, mode %v, contents [%v]", b.Mode(), string(b.Bytes()))
+	}
+	b.Close()
+}
@@ -0,0 +1,303 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// a ByteBuffer safe for use by multiple goroutines
+// ReadAt, ByteAt and other calls that leave position alone take a shared
+// lock, calls that move position or modify data take an exclusive lock
+// implemented interfaces
+//	io.Seeker
+//  io.Reader
+//  io.ReaderAt
+//  io.Writer
+//  io.WriteAt
+//	io.ByteReader
+//	io.ByteWriter
+type SyncByteBuffer struct {
+	mu sync.RWMutex
+	b  *ByteBuffer
+}
+
+// create a new SyncByteBuffer with of (size) bytes
+func NewSyncByteBuffer(size uint) *SyncByteBuffer {
+	return &SyncByteBuffer{b: NewByteBuffer(size)}
+}
+
+// create a new SyncByteBuffer wrapping b
+// NOTE:
+//	- b must not be used directly afterwards, use WithLock instead
+func NewSyncByteBufferFrom(b *ByteBuffer) *SyncByteBuffer {
+	return &SyncByteBuffer{b: b}
+}
+
+// runs f with exclusive access to the wrapped buffer, for compound operations
+// NOTE:
+//	- f must not keep a reference to the buffer after returning
+//	- f must not call methods of this SyncByteBuffer, that would deadlock
+func (m *SyncByteBuffer) WithLock(f func(*ByteBuffer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(m.b)
+}
+
+// runs f with shared access to the wrapped buffer, f must only read from it
+// and must not move position, ReadAt and ByteAt are fine
+func (m *SyncByteBuffer) WithRLock(f func(*ByteBuffer)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f(m.b)
+}
+
+// @ByteBuffer.Reset
+func (m *SyncByteBuffer) Reset(size uint) *SyncByteBuffer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.b.Reset(size)
+	return m
+}
+
+// @ByteBuffer.Truncate
+func (m *SyncByteBuffer) Truncate(size uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.Truncate(size)
+}
+
+// @ByteBuffer.Clear
+func (m *SyncByteBuffer) Clear() *SyncByteBuffer {
+	return m.Reset(0)
+}
+
+// @ByteBuffer.Empty
+func (m *SyncByteBuffer) Empty() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.Empty()
+}
+
+// compare contents of this and other
+func (m *SyncByteBuffer) CmpWith(other *SyncByteBuffer) int {
+	// never hold both locks, two goroutines comparing in opposite directions
+	// would deadlock
+	o := other.Bytes()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return bytes.Compare(m.b.buff, o)
+}
+
+// @ByteBuffer.Clone
+func (m *SyncByteBuffer) Clone() *SyncByteBuffer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return NewSyncByteBufferFrom(m.b.Clone())
+}
+
+// @ByteBuffer.Size
+func (m *SyncByteBuffer) Size() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.Size()
+}
+
+// @ByteBuffer.Cap
+func (m *SyncByteBuffer) Cap() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.Cap()
+}
+
+// @ByteBuffer.Pos
+func (m *SyncByteBuffer) Pos() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.Pos()
+}
+
+// @ByteBuffer.Bytes
+func (m *SyncByteBuffer) Bytes() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.Bytes()
+}
+
+// @SyncByteBuffer.Seek(offset, io.SeekStart)
+func (m *SyncByteBuffer) SeekFromStart(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekStart)
+}
+
+// @SyncByteBuffer.Seek(offset, io.SeekCurrent)
+func (m *SyncByteBuffer) SeekFromCurrent(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekCurrent)
+}
+
+// @SyncByteBuffer.Seek(offset, io.SeekEnd)
+func (m *SyncByteBuffer) SeekFromEnd(offset int64) (int64, error) {
+	return m.Seek(offset, io.SeekEnd)
+}
+
+// @SyncByteBuffer.Seek(0, io.SeekStart)
+func (m *SyncByteBuffer) SeekToStart() (int64, error) {
+	return m.Seek(0, io.SeekStart)
+}
+
+// @SyncByteBuffer.Seek(0, io.SeekEnd)
+func (m *SyncByteBuffer) SeekToEnd() (int64, error) {
+	return m.Seek(0, io.SeekEnd)
+}
+
+// @ByteBuffer.Seek
+func (m *SyncByteBuffer) Seek(offset int64, whence int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.Seek(offset, whence)
+}
+
+// @ByteBuffer.Read
+func (m *SyncByteBuffer) Read(p []byte) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.Read(p)
+}
+
+// @ByteBuffer.ReadAt, takes a shared lock
+func (m *SyncByteBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.ReadAt(p, off)
+}
+
+// @ByteBuffer.Write
+func (m *SyncByteBuffer) Write(p []byte) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.Write(p)
+}
+
+// @ByteBuffer.WriteAt
+func (m *SyncByteBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.WriteAt(p, off)
+}
+
+// @ByteBuffer.ReadByte
+func (m *SyncByteBuffer) ReadByte() (byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.ReadByte()
+}
+
+// @ByteBuffer.WriteByte
+func (m *SyncByteBuffer) WriteByte(c byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.WriteByte(c)
+}
+
+// @ByteBuffer.ByteAt, takes a shared lock
+func (m *SyncByteBuffer) ByteAt(pos int) (byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.b.ByteAt(pos)
+}
+
+// @ByteBuffer.WriteUInt64Var
+func (m *SyncByteBuffer) WriteUInt64Var(x uint64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.WriteUInt64Var(x)
+}
+
+// @ByteBuffer.ReadUInt64Var, the whole varint is read under one lock
+func (m *SyncByteBuffer) ReadUInt64Var() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.ReadUInt64Var()
+}
+
+// @ByteBuffer.WriteUInt16
+func (m *SyncByteBuffer) WriteUInt16(x uint16, order binary.ByteOrder) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.WriteUInt16(x, order)
+}
+
+// @ByteBuffer.WriteUInt32
+func (m *SyncByteBuffer) WriteUInt32(x uint32, order binary.ByteOrder) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.WriteUInt32(x, order)
+}
+
+// @ByteBuffer.WriteUInt64
+func (m *SyncByteBuffer) WriteUInt64(x uint64, order binary.ByteOrder) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.WriteUInt64(x, order)
+}
+
+// @ByteBuffer.ReadUInt16
+func (m *SyncByteBuffer) ReadUInt16(order binary.ByteOrder) (uint16, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.ReadUInt16(order)
+}
+
+// @ByteBuffer.ReadUInt32
+func (m *SyncByteBuffer) ReadUInt32(order binary.ByteOrder) (uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.ReadUInt32(order)
+}
+
+// @ByteBuffer.ReadUInt64
+func (m *SyncByteBuffer) ReadUInt64(order binary.ByteOrder) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.ReadUInt64(order)
+}
+
+// @ByteBuffer.WriteBytesVar, prefix and payload are written under one lock
+func (m *SyncByteBuffer) WriteBytesVar(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.WriteBytesVar(p)
+}
+
+// @ByteBuffer.ReadBytesVar
+func (m *SyncByteBuffer) ReadBytesVar() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.b.ReadBytesVar()
+}
@@ -0,0 +1,123 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"sync"
+	"testing"
+)
+
+// meant to run with -race
+func TestSyncByteBufferConcurrent(t *testing.T) {
+	tag := "SyncByteBuffer(concurrent)"
+
+	b := NewSyncByteBuffer(0)
+	record := []byte("abracadabra")
+
+	writers := 4
+	times := 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < times; i++ {
+				// write and verify the record under one lock
+				b.WithLock(func(u *ByteBuffer) {
+					off := int64(u.Size())
+					u.Write(record)
+					rbuff := make([]byte, len(record))
+					u.ReadAt(rbuff, off)
+					if string(rbuff) != string(record) {
+						t.Errorf(tag+" record @%v overwritten [%v]", off, string(rbuff))
+					}
+				})
+			}
+		}()
+	}
+
+	// readers only take shared locks
+	for r := 0; r < writers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rbuff := make([]byte, len(record))
+			for i := 0; i < times; i++ {
+				size := b.Size()
+				if size < uint(len(record)) {
+					continue
+				}
+				off := int64(size)/int64(len(record))*int64(len(record)) - int64(len(record))
+				n, err := b.ReadAt(rbuff, off)
+				if err != nil || string(rbuff[:n]) != string(record) {
+					t.Errorf(tag+" unexpected read @%v [%v] %v", off, string(rbuff[:n]), errOrNilStr(err))
+					return
+				}
+				b.ByteAt(int(off))
+			}
+		}()
+	}
+	wg.Wait()
+
+	expected := uint(writers * times * len(record))
+	if b.Size() != expected {
+		t.Fatalf(tag+" size error, expected %v, found %v", expected, b.Size())
+	}
+	if b.Pos() != int(expected) {
+		t.Fatalf(tag+" unexpected position, expected %v, found %v", expected, b.Pos())
+	}
+}
+
+func TestSyncByteBufferReadWrite(t *testing.T) {
+	tag := "SyncByteBuffer.Read/Write()"
+
+	b := NewSyncByteBuffer(0)
+	for v := uint64(1); v < 1<<40; v *= 3 {
+		b.WriteUInt64Var(v)
+	}
+	clone := b.Clone()
+	if clone.CmpWith(b) != 0 {
+		t.Fatal(tag + " clone data mismatch")
+	}
+
+	b.SeekToStart()
+	for v := uint64(1); v < 1<<40; v *= 3 {
+		x, err := b.ReadUInt64Var()
+		if err != nil {
+			t.Fatalf(tag+" unexpected read error: %v", err.Error())
+		}
+		if x != v {
+			t.Fatalf(tag+" unexpected read value, expected %v, found %v", v, x)
+		}
+	}
+
+	b.Clear()
+	if !b.Empty() {
+		t.Fatalf(tag+" expected empty buffer, found %v bytes", b.Size())
+	}
+}
@@ -0,0 +1,177 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// returned when committing or rolling back a transaction that is already
+// finished, either directly or by an outer one
+var ErrTxDone = errors.New("Transaction already finished")
+
+// state of a ByteBuffer at Begin
+type txSavepoint struct {
+	id   uint64
+	size int
+	pos  int
+	// undo log length at Begin
+	logLen int
+	// largest size of this and all outer savepoints, bytes past it are never
+	// needed by a rollback
+	maxSize int
+}
+
+// original bytes of buff[off:off+len(data)], saved right before they changed
+type txEntry struct {
+	off  int
+	data []byte
+}
+
+// a transaction on a ByteBuffer, see ByteBuffer.Begin
+type Tx struct {
+	b  *ByteBuffer
+	id uint64
+	// index of the savepoint in b.txs
+	level int
+}
+
+// used in order to tell apart savepoints reusing the same level
+var txIds uint64
+
+// starts a transaction, every Write, WriteAt, WriteByte, Truncate and Reset
+// from now on can be undone with Tx.Rollback, which also restores position
+// transactions nest, a Begin while another transaction is open creates a
+// savepoint within it
+// NOTE:
+//	- appends cost nothing extra, only bytes that existed at Begin and are
+//		overwritten or truncated are saved
+//	- finish inner transactions before outer ones, finishing an outer
+//		transaction finishes all inner ones too
+func (m *ByteBuffer) Begin() *Tx {
+	m.checkReleased()
+
+	sp := txSavepoint{
+		id:      atomic.AddUint64(&txIds, 1),
+		size:    len(m.buff),
+		pos:     m.pos,
+		logLen:  len(m.txlog),
+		maxSize: len(m.buff),
+	}
+	if n := len(m.txs); n > 0 {
+		sp.maxSize = max_int(sp.maxSize, m.txs[n-1].maxSize)
+	}
+	m.txs = append(m.txs, sp)
+	return &Tx{b: m, id: sp.id, level: len(m.txs) - 1}
+}
+
+// returns the number of open transactions
+func (m *ByteBuffer) TxDepth() int {
+	return len(m.txs)
+}
+
+// called right before buff[from:to] is written to
+func (m *ByteBuffer) beforeWrite(from, to int) {
+	m.journal(from, to)
+	m.protect(from, to)
+}
+
+// saves buff[from:to] to the undo log, if an open transaction needs it
+func (m *ByteBuffer) journal(from, to int) {
+	n := len(m.txs)
+	if n == 0 {
+		return
+	}
+	to = min_int(to, min_int(len(m.buff), m.txs[n-1].maxSize))
+	if to <= from {
+		return
+	}
+	data := make([]byte, to-from)
+	copy(data, m.buff[from:to])
+	m.txlog = append(m.txlog, txEntry{off: from, data: data})
+}
+
+// returns true if this transaction is still open
+func (m *Tx) Open() bool {
+	return m.level < len(m.b.txs) && m.b.txs[m.level].id == m.id
+}
+
+// makes changes done since Begin permanent, as far as this transaction is
+// concerned, an outer transaction may still roll them back
+// errors:
+//	ErrTxDone
+func (m *Tx) Commit() error {
+	if !m.Open() {
+		return ErrTxDone
+	}
+	b := m.b
+	b.txs = b.txs[:m.level]
+	if len(b.txs) == 0 {
+		b.txlog = nil
+	}
+	return nil
+}
+
+// undoes all changes done since Begin and restores position
+// errors:
+//	ErrTxDone
+func (m *Tx) Rollback() error {
+	if !m.Open() {
+		return ErrTxDone
+	}
+	b := m.b
+	sp := b.txs[m.level]
+
+	// back to the size at Begin, bytes past the current end come back from
+	// the undo log, which saved them when they were truncated
+	if len(b.buff) > sp.size {
+		b.buff = b.buff[:sp.size]
+	} else if len(b.buff) < sp.size {
+		b.protect(len(b.buff), sp.size)
+		b.buff = append(b.buff, make([]byte, sp.size-len(b.buff))...)
+	}
+
+	// oldest entry last, it holds the value at Begin
+	for i := len(b.txlog) - 1; i >= sp.logLen; i-- {
+		e := b.txlog[i]
+		if e.off >= len(b.buff) {
+			continue
+		}
+		end := min_int(e.off+len(e.data), len(b.buff))
+		b.protect(e.off, end)
+		copy(b.buff[e.off:end], e.data)
+	}
+
+	b.txlog = b.txlog[:sp.logLen]
+	b.txs = b.txs[:m.level]
+	if len(b.txs) == 0 {
+		b.txlog = nil
+	}
+	b.pos = sp.pos
+	return nil
+}
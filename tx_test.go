@@ -0,0 +1,143 @@
+package mbytes
+
+// Copyright(c) Dorin Duminica. All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//   1. Redistributions of source code must retain the above copyright notice,
+// 	 this list of conditions and the following disclaimer.
+//
+//   2. Redistributions in binary form must reproduce the above copyright notice,
+// 	 this list of conditions and the following disclaimer in the documentation
+// 	 and/or other materials provided with the distribution.
+//
+//   3. Neither the name of the copyright holder nor the names of its
+// 	 contributors may be used to endorse or promote products derived from this
+// 	 software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestByteBufferTxRollback(t *testing.T) {
+	tag := "ByteBuffer.Begin/Rollback()"
+
+	b := NewByteBuffer(0)
+	b.Write([]byte("abracadabra"))
+	b.SeekFromStart(4)
+
+	tx := b.Begin()
+	b.Write([]byte("XYZ"))
+	b.WriteAt([]byte("0123456789"), 8)
+	b.WriteByte('!')
+	b.Truncate(2)
+	b.Write([]byte("--"))
+
+	err := tx.Rollback()
+	if err != nil {
+		t.Fatalf(tag+" unexpected rollback error: %v", err.Error())
+	}
+	if string(b.Bytes()) != "abracadabra" {
+		t.Fatalf(tag+" unexpected contents after rollback [%v]", string(b.Bytes()))
+	}
+	if b.Pos() != 4 {
+		t.Fatalf(tag+" unexpected position, expected 4, found %v", b.Pos())
+	}
+
+	err = tx.Commit()
+	if err != ErrTxDone {
+		t.Fatalf(tag+" unexpected error, expected [%v], found [%v]", ErrTxDone.Error(), errOrNilStr(err))
+	}
+}
+
+func TestByteBufferTxNested(t *testing.T) {
+	tag := "ByteBuffer.Begin(nested)"
+
+	b := NewByteBuffer(0)
+	outer := b.Begin()
+	b.Write([]byte("abra"))
+
+	inner := b.Begin()
+	b.Write([]byte("cadabra"))
+	inner.Commit()
+
+	inner = b.Begin()
+	b.WriteAt([]byte("ABRA"), 0)
+	inner.Rollback()
+
+	if string(b.Bytes()) != "abracadabra" {
+		t.Fatalf(tag+" unexpected contents [%v]", string(b.Bytes()))
+	}
+	if b.TxDepth() != 1 {
+		t.Fatalf(tag+" unexpected depth, expected 1, found %v", b.TxDepth())
+	}
+
+	// rolling back the outer transaction drops committed inner changes too
+	inner = b.Begin()
+	outer.Rollback()
+	if !b.Empty() || b.TxDepth() != 0 {
+		t.Fatalf(tag+" unexpected contents [%v], depth %v", string(b.Bytes()), b.TxDepth())
+	}
+	if inner.Open() || inner.Rollback() != ErrTxDone {
+		t.Fatal(tag + " inner transaction still open")
+	}
+}
+
+func TestByteBufferTxRandom(t *testing.T) {
+	tag := "ByteBuffer.Begin(random)"
+
+	rnd := rand.New(rand.NewSource(4))
+	b := NewByteBuffer(0)
+	b.Write(make([]byte, 100))
+
+	txs := []*Tx{}
+	states := [][]byte{}
+	positions := []int{}
+
+	for i := 0; i < 2000; i++ {
+		switch op := rnd.Intn(12); {
+		case op == 0:
+			states = append(states, b.Bytes())
+			positions = append(positions, b.Pos())
+			txs = append(txs, b.Begin())
+		case op == 1 && len(txs) > 0:
+			n := len(txs) - 1
+			txs[n].Rollback()
+			if bytes.Compare(states[n], b.Bytes()) != 0 || positions[n] != b.Pos() {
+				t.Fatalf(tag+" rollback #%v did not restore state", i)
+			}
+			txs, states, positions = txs[:n], states[:n], positions[:n]
+		case op == 2 && len(txs) > 0:
+			n := len(txs) - 1
+			txs[n].Commit()
+			txs, states, positions = txs[:n], states[:n], positions[:n]
+		case op == 3:
+			b.Truncate(uint(rnd.Intn(int(b.Size()) + 1)))
+		case op == 4:
+			b.WriteByte(byte(i))
+		case op == 5:
+			b.Reset(uint(rnd.Intn(200)))
+		default:
+			p := make([]byte, rnd.Intn(50))
+			rnd.Read(p)
+			if b.Size() > 0 {
+				b.WriteAt(p, int64(rnd.Intn(int(b.Size()))))
+			} else {
+				b.Write(p)
+			}
+		}
+	}
+}
@@ -27,6 +27,7 @@ package mbytes
 // OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 import (
+	"encoding/binary"
 	"io"
 )
 
@@ -56,3 +57,114 @@ func min_int(a, b int) int {
 	}
 	return b
 }
+
+func max_int(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// computes the absolute position for a seek request, size is the logical size
+// of the buffer and cur the current position
+// errors:
+//	ErrSeekNegative
+//	ErrWhenceUnknown
+// NOTE:
+//	- overflow checks are left to the caller, buffers differ on whether seeking
+//		to the very end is allowed
+func seek_abs(offset int64, whence int, cur int, size int) (int, error) {
+	pos := int(offset)
+
+	switch whence {
+	case io.SeekStart:
+		// seeking from the beginning
+	case io.SeekCurrent:
+		// inc position by offset, offset can be both positive and negative
+		pos += cur
+	case io.SeekEnd:
+		// set position to buffer length + offset, offset must be negative
+		pos += size
+	default:
+		return -1, ErrWhenceUnknown
+	}
+
+	if pos < 0 {
+		return -1, ErrSeekNegative
+	}
+
+	return pos, nil
+}
+
+// returns the n bytes of buff starting at pos without consuming anything
+// errors:
+//	io.EOF, nothing left at pos
+//	io.ErrUnexpectedEOF, fewer than n bytes left at pos
+func peek_fixed(buff []byte, pos int, n int) ([]byte, error) {
+	if pos >= len(buff) {
+		if n == 0 {
+			return buff[len(buff):], nil
+		}
+		return nil, io.EOF
+	}
+	if len(buff)-pos < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buff[pos : pos+n], nil
+}
+
+// decodes the uvarint of buff starting at pos, returns the value and its size
+// errors:
+//	io.EOF, nothing left at pos
+//	io.ErrUnexpectedEOF, the varint is cut short by the end of buff
+//	ErrVarintOverflow
+func peek_uvarint(buff []byte, pos int) (uint64, int, error) {
+	if pos >= len(buff) {
+		return 0, 0, io.EOF
+	}
+	x, n := binary.Uvarint(buff[pos:])
+	if n == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if n < 0 {
+		return 0, 0, ErrVarintOverflow
+	}
+	return x, n, nil
+}
+
+// decodes the uvarint at pos of a buffer which is not a single slice, read is
+// its readFromPos, nothing is consumed
+// errors:
+//	@peek_uvarint
+//	any other error returned by read
+func read_uvarint_at(read func(p []byte, pos int, incPos bool) (int, error), pos int) (uint64, int, error) {
+	var p [binary.MaxVarintLen64]byte
+	n, err := read(p[:], pos, false)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	return peek_uvarint(p[:max_int(n, 0)], 0)
+}
+
+// decodes a uvarint length prefix followed by that many bytes, returns the
+// payload and the total size consumed
+// errors:
+//	@peek_uvarint
+//	io.ErrUnexpectedEOF, the payload is cut short by the end of buff
+func peek_prefixed(buff []byte, pos int) ([]byte, int, error) {
+	l, n, err := peek_uvarint(buff, pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	if l > uint64(len(buff)-pos-n) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	return buff[pos+n : pos+n+int(l)], n + int(l), nil
+}
+
+// overwrites p with ZERO bytes
+func zero_bytes(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}